@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ALTO v4 namespace and schema.
+const (
+	altoNamespace = "http://www.loc.gov/standards/alto/ns-v4#"
+	altoSchema    = "http://www.loc.gov/standards/alto/v4/alto-4-2.xsd"
+)
+
+// altoEstimatedStep describes how positions were found, as those of text
+// are made up.
+const altoEstimatedStep = "Page and illustration positions from OCR; text block, line, and word positions estimated"
+
+type altoDocument struct {
+	XMLName        xml.Name        `xml:"alto"`
+	Namespace      string          `xml:"xmlns,attr"`
+	XSI            string          `xml:"xmlns:xsi,attr"`
+	SchemaLocation string          `xml:"xsi:schemaLocation,attr"`
+	Description    altoDescription `xml:"Description"`
+	Tags           *altoTags       `xml:"Tags"`
+	Page           altoPage        `xml:"Layout>Page"`
+}
+
+type altoDescription struct {
+	MeasurementUnit string         `xml:"MeasurementUnit"`
+	FileName        string         `xml:"sourceImageInformation>fileName"`
+	Processing      altoProcessing `xml:"Processing"`
+}
+
+type altoProcessing struct {
+	ID       string `xml:"ID,attr"`
+	DateTime string `xml:"processingDateTime"`
+	Step     string `xml:"processingStepDescription"`
+	Software string `xml:"processingSoftware>softwareName"`
+	Version  string `xml:"processingSoftware>softwareVersion"`
+}
+
+type altoTags struct {
+	Structure []altoTag `xml:"StructureTag"`
+}
+
+type altoTag struct {
+	ID    string `xml:"ID,attr"`
+	Type  string `xml:"TYPE,attr"`
+	Label string `xml:"LABEL,attr"`
+}
+
+type altoPage struct {
+	ID         string `xml:"ID,attr"`
+	ImageNr    int    `xml:"PHYSICAL_IMG_NR,attr"`
+	Width      int    `xml:"WIDTH,attr"`
+	Height     int    `xml:"HEIGHT,attr"`
+	PrintSpace altoBlock
+}
+
+// altoBlock is PrintSpace or a block, named by XMLName.
+// Blocks holds its child blocks in reading order.
+type altoBlock struct {
+	XMLName xml.Name
+	ID      string `xml:"ID,attr,omitempty"`
+	Type    string `xml:"TYPE,attr,omitempty"`
+	TagRefs string `xml:"TAGREFS,attr,omitempty"`
+	altoPosition
+	Blocks []altoBlock `xml:",omitempty"`
+	Lines  []altoLine  `xml:"TextLine,omitempty"`
+}
+
+type altoPosition struct {
+	HPos   int `xml:"HPOS,attr"`
+	VPos   int `xml:"VPOS,attr"`
+	Width  int `xml:"WIDTH,attr"`
+	Height int `xml:"HEIGHT,attr"`
+}
+
+type altoLine struct {
+	ID string `xml:"ID,attr"`
+	altoPosition
+	Content []any
+}
+
+type altoString struct {
+	XMLName xml.Name `xml:"String"`
+	ID      string   `xml:"ID,attr"`
+	Content string   `xml:"CONTENT,attr"`
+	altoPosition
+}
+
+type altoSpace struct {
+	XMLName xml.Name `xml:"SP"`
+}
+
+// writeALTOPage writes a page of the OCR result as an ALTO v4 document.
+// Text blocks become TextBlocks, tagged if they are headings or lists,
+// tables become ComposedBlocks of type table, and images Illustrations. The
+// positions of text are estimated, see layoutPage, which the processing
+// step description says.
+func writeALTOPage(src formatSource, page Page) ([]byte, error) {
+	width, height, blocks := layoutPage(page)
+	n := page.Index + 1
+
+	doc := altoDocument{
+		Namespace:      altoNamespace,
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: altoNamespace + " " + altoSchema,
+		Description: altoDescription{
+			MeasurementUnit: "pixel",
+			FileName:        filepath.Base(src.Path),
+			Processing: altoProcessing{
+				ID:       "OCR_0",
+				DateTime: src.Time.UTC().Format(time.RFC3339),
+				Step:     altoEstimatedStep,
+				Software: "ocr",
+				Version:  version,
+			},
+		},
+		Page: altoPage{
+			ID:      fmt.Sprintf("page_%d", n),
+			ImageNr: n,
+			Width:   width,
+			Height:  height,
+			PrintSpace: altoBlock{
+				XMLName:      xml.Name{Local: "PrintSpace"},
+				altoPosition: altoPosition{Width: width, Height: height},
+			},
+		},
+	}
+
+	tags := map[string]altoTag{}
+	var lineNum, wordNum int
+	lines := func(lines []layoutLine) []altoLine {
+		var out []altoLine
+		for _, line := range lines {
+			lineNum++
+			al := altoLine{ID: fmt.Sprintf("line_%d_%d", n, lineNum), altoPosition: altoPos(line.Box)}
+			for i, word := range line.Words {
+				if i > 0 {
+					al.Content = append(al.Content, altoSpace{})
+				}
+				wordNum++
+				al.Content = append(al.Content, altoString{
+					ID: fmt.Sprintf("string_%d_%d", n, wordNum), Content: word.Text, altoPosition: altoPos(word.Box),
+				})
+			}
+			out = append(out, al)
+		}
+		return out
+	}
+
+	for i, block := range blocks {
+		id := i + 1
+		switch {
+		case block.Image != nil:
+			doc.Page.PrintSpace.Blocks = append(doc.Page.PrintSpace.Blocks, altoBlock{
+				XMLName:      xml.Name{Local: "Illustration"},
+				ID:           fmt.Sprintf("image_%d_%d", n, id),
+				altoPosition: altoPos(block.Box),
+			})
+		case block.Kind == blockTable:
+			doc.Page.PrintSpace.Blocks = append(doc.Page.PrintSpace.Blocks, altoBlock{
+				XMLName:      xml.Name{Local: "ComposedBlock"},
+				ID:           fmt.Sprintf("table_%d_%d", n, id),
+				Type:         "table",
+				altoPosition: altoPos(block.Box),
+				Blocks: []altoBlock{{
+					XMLName:      xml.Name{Local: "TextBlock"},
+					ID:           fmt.Sprintf("block_%d_%d", n, id),
+					altoPosition: altoPos(block.Box),
+					Lines:        lines(block.Lines),
+				}},
+			})
+		default:
+			tb := altoBlock{
+				XMLName:      xml.Name{Local: "TextBlock"},
+				ID:           fmt.Sprintf("block_%d_%d", n, id),
+				altoPosition: altoPos(block.Box),
+				Lines:        lines(block.Lines),
+			}
+			switch block.Kind {
+			case blockHeading:
+				tb.TagRefs = fmt.Sprintf("HEADING%d", block.Level)
+				tags[tb.TagRefs] = altoTag{ID: tb.TagRefs, Type: "structure", Label: fmt.Sprintf("heading %d", block.Level)}
+			case blockList:
+				tb.TagRefs = "LIST"
+				tags[tb.TagRefs] = altoTag{ID: tb.TagRefs, Type: "structure", Label: "list"}
+			}
+			doc.Page.PrintSpace.Blocks = append(doc.Page.PrintSpace.Blocks, tb)
+		}
+	}
+
+	if len(tags) > 0 {
+		doc.Tags = &altoTags{}
+		for _, tag := range tags {
+			doc.Tags.Structure = append(doc.Tags.Structure, tag)
+		}
+		slices.SortFunc(doc.Tags.Structure, func(a, b altoTag) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding ALTO: %w", err)
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// altoPos converts a box to ALTO's position attributes.
+func altoPos(box pageBox) altoPosition {
+	return altoPosition{HPos: box.X0, VPos: box.Y0, Width: box.X1 - box.X0, Height: box.Y1 - box.Y0}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/xml"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// altoTestDocument is the part of an ALTO document the tests check.
+type altoTestDocument struct {
+	XMLName    xml.Name
+	Unit       string `xml:"Description>MeasurementUnit"`
+	FileName   string `xml:"Description>sourceImageInformation>fileName"`
+	Processing string `xml:"Description>Processing>processingDateTime"`
+	Step       string `xml:"Description>Processing>processingStepDescription"`
+	Tags       []struct {
+		ID string `xml:"ID,attr"`
+	} `xml:"Tags>StructureTag"`
+	Pages []struct {
+		ID         string `xml:"ID,attr"`
+		ImageNr    int    `xml:"PHYSICAL_IMG_NR,attr"`
+		Width      int    `xml:"WIDTH,attr"`
+		Height     int    `xml:"HEIGHT,attr"`
+		PrintSpace struct {
+			Blocks []altoTestBlock `xml:",any"`
+		}
+	} `xml:"Layout>Page"`
+}
+
+type altoTestBlock struct {
+	XMLName xml.Name
+	ID      string          `xml:"ID,attr"`
+	Type    string          `xml:"TYPE,attr"`
+	TagRefs string          `xml:"TAGREFS,attr"`
+	HPos    int             `xml:"HPOS,attr"`
+	VPos    int             `xml:"VPOS,attr"`
+	Width   int             `xml:"WIDTH,attr"`
+	Height  int             `xml:"HEIGHT,attr"`
+	Blocks  []altoTestBlock `xml:"TextBlock"`
+	Lines   []struct {
+		Strings []struct {
+			Content string `xml:"CONTENT,attr"`
+		} `xml:"String"`
+		Spaces []struct{} `xml:"SP"`
+	} `xml:"TextLine"`
+}
+
+func TestWriteALTOPage(t *testing.T) {
+	resp := layoutTestResponse()
+	processed := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	files, err := outputFormats["alto"](formatSource{Path: "in/report.pdf", Resp: resp, Time: processed}, "report")
+	if err != nil {
+		t.Fatalf("alto failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "alto/page_2.xml" {
+		t.Fatalf("expected one file per page, got %+v", files)
+	}
+
+	var doc altoTestDocument
+	if err := xml.Unmarshal(files[0].Data, &doc); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, files[0].Data)
+	}
+	if doc.XMLName.Space != altoNamespace || doc.XMLName.Local != "alto" {
+		t.Errorf("unexpected root element %v", doc.XMLName)
+	}
+	if doc.Unit != "pixel" || doc.FileName != "report.pdf" || doc.Processing != "2025-03-01T12:00:00Z" ||
+		!strings.Contains(doc.Step, "word positions estimated") {
+		t.Errorf("unexpected description: %q %q %q %q", doc.Unit, doc.FileName, doc.Processing, doc.Step)
+	}
+	if len(doc.Pages) != 1 {
+		t.Fatalf("expected 1 page, got %d", len(doc.Pages))
+	}
+	page := doc.Pages[0]
+	if page.ID != "page_3" || page.ImageNr != 3 || page.Width != 1700 || page.Height != 2200 {
+		t.Errorf("unexpected page attributes: %+v", page)
+	}
+
+	var elements []string
+	var words []string
+	ids := map[string]bool{}
+	collect := func(b altoTestBlock) {
+		for _, line := range b.Lines {
+			if len(line.Spaces) != len(line.Strings)-1 {
+				t.Errorf("expected spaces between the strings of %s", b.ID)
+			}
+			for _, s := range line.Strings {
+				words = append(words, s.Content)
+			}
+		}
+	}
+	for _, b := range page.PrintSpace.Blocks {
+		elements = append(elements, b.XMLName.Local)
+		if ids[b.ID] {
+			t.Errorf("duplicate ID %q", b.ID)
+		}
+		ids[b.ID] = true
+		collect(b)
+		for _, inner := range b.Blocks {
+			collect(inner)
+		}
+	}
+
+	want := []string{"TextBlock", "TextBlock", "Illustration", "TextBlock", "ComposedBlock"}
+	if !slices.Equal(elements, want) {
+		t.Fatalf("expected blocks %v, got %v", want, elements)
+	}
+	blocks := page.PrintSpace.Blocks
+	if img := blocks[2]; img.HPos != 100 || img.VPos != 600 || img.Width != 800 || img.Height != 400 {
+		t.Errorf("expected the illustration at the image's bounding box, got %+v", img)
+	}
+	if blocks[0].TagRefs != "HEADING1" || blocks[3].TagRefs != "LIST" {
+		t.Errorf("expected heading and list tags, got %q and %q", blocks[0].TagRefs, blocks[3].TagRefs)
+	}
+	var tags []string
+	for _, tag := range doc.Tags {
+		tags = append(tags, tag.ID)
+	}
+	if !slices.Equal(tags, []string{"HEADING1", "LIST"}) {
+		t.Errorf("expected the referenced structure tags, got %v", tags)
+	}
+	if table := blocks[4]; table.Type != "table" || len(table.Blocks) != 1 || len(table.Blocks[0].Lines) != 2 {
+		t.Errorf("expected a table with 2 rows, got %+v", table)
+	}
+	if blocks[3].VPos < 1000 {
+		t.Errorf("expected the list below the image, got VPOS %d", blocks[3].VPos)
+	}
+	if got := strings.Join(words, " "); got != "Annual Report Sales grew by 5% & more. North South Region Total North 10" {
+		t.Errorf("unexpected words %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// redacted replaces the API key in output and error messages.
+const redacted = "[REDACTED]"
+
+// errNoAPIKey is returned by App.resolveAPIKey when no API key is configured.
+var errNoAPIKey = errors.New("no API key")
+
+// resolveAPIKey returns the API key and a description of where it came from.
+// The key is read from the file set with -api-key-file or
+// MISTRAL_API_KEY_FILE, the output of api_key_command, or the environment
+// variable envVar, such as MISTRAL_API_KEY. A key file or command set in a
+// config file does not override envVar, but one set by a flag or the
+// environment does.
+//
+// The key file and command are for Mistral: with -provider openai, they are
+// only used if set by a flag, or in the same config file or profile that
+// selects the provider, so that the Mistral key isn't sent to another
+// endpoint.
+func (a *App) resolveAPIKey(ctx context.Context, cfg *Config, envVar string) (string, string, error) {
+	envKey := strings.TrimSpace(a.Getenv(envVar))
+	use := func(key string) bool {
+		layer := cfg.Layer(key)
+		if cfg.String("provider") == providerOpenAI && layer != layerFlag &&
+			(layer == layerEnv || layer != cfg.Layer("provider")) {
+			return false
+		}
+		return envKey == "" || layer >= layerEnv
+	}
+
+	if path := cfg.Path("api_key_file"); path != "" && use("api_key_file") {
+		key, err := readAPIKeyFile(path)
+		if err != nil {
+			return "", "", err
+		}
+		return key, "file " + path, nil
+	}
+
+	if command := cfg.String("api_key_command"); command != "" && use("api_key_command") {
+		key, err := runAPIKeyCommand(ctx, command, a.Stdin, a.Stderr)
+		if err != nil {
+			return "", "", err
+		}
+		return key, "command " + command, nil
+	}
+
+	if envKey != "" {
+		return envKey, "env " + envVar, nil
+	}
+
+	return "", "", fmt.Errorf("%w: set %s, MISTRAL_API_KEY_FILE, -api-key-file, or api_key_command", errNoAPIKey, envVar)
+}
+
+// readAPIKeyFile reads an API key from a secret file, such as a Docker or
+// Kubernetes secret mount. Surrounding whitespace is ignored.
+func readAPIKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading API key file: %w", err)
+	}
+
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("API key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// runAPIKeyCommand runs command with the system shell and returns the first
+// line of its output as the API key. The command gets stdin and stderr, so
+// helpers like pass can prompt for a passphrase.
+func runAPIKeyCommand(ctx context.Context, command string, stdin io.Reader, stderr io.Writer) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+
+	// The output is never included in errors, as it may contain the key.
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running API key command: %w", err)
+	}
+
+	key, _, _ := strings.Cut(stdout.String(), "\n")
+	if key = strings.TrimSpace(key); key == "" {
+		return "", errors.New("API key command printed no key")
+	}
+	return key, nil
+}
+
+// redactedError hides the API key in the message of the wrapped error.
+type redactedError struct {
+	err error
+	key string
+}
+
+func (e *redactedError) Error() string {
+	return redactKey(e.err.Error(), e.key)
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactKey replaces every occurrence of key in s.
+func redactKey(s, key string) string {
+	if key == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, key, redacted)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveAPIKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "api_key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+
+	userDir, workDir := configFixture(t, "api_key_file = '"+keyFile+"'\n", "")
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		dir        string
+		wantKey    string
+		wantSource string
+	}{
+		{
+			name:       "environment",
+			env:        map[string]string{"MISTRAL_API_KEY": "env-key"},
+			dir:        dir,
+			wantKey:    "env-key",
+			wantSource: "env MISTRAL_API_KEY",
+		},
+		{
+			name:       "file from environment",
+			env:        map[string]string{"MISTRAL_API_KEY_FILE": keyFile, "MISTRAL_API_KEY": "env-key"},
+			dir:        dir,
+			wantKey:    "file-key",
+			wantSource: "file " + keyFile,
+		},
+		{
+			name:       "file from flag",
+			args:       []string{"-api-key-file", keyFile},
+			env:        map[string]string{"MISTRAL_API_KEY": "env-key"},
+			dir:        dir,
+			wantKey:    "file-key",
+			wantSource: "file " + keyFile,
+		},
+		{
+			name:       "file from config",
+			env:        map[string]string{"XDG_CONFIG_HOME": userDir},
+			dir:        workDir,
+			wantKey:    "file-key",
+			wantSource: "file " + keyFile,
+		},
+		{
+			name:       "environment overrides config",
+			env:        map[string]string{"XDG_CONFIG_HOME": userDir, "MISTRAL_API_KEY": "env-key"},
+			dir:        workDir,
+			wantKey:    "env-key",
+			wantSource: "env MISTRAL_API_KEY",
+		},
+		{
+			name:       "command",
+			args:       []string{"-api-key-command", "echo command-key; echo second line"},
+			dir:        dir,
+			wantKey:    "command-key",
+			wantSource: "command echo command-key; echo second line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && slices.Contains(tt.args, "-api-key-command") {
+				t.Skip("commands use sh syntax")
+			}
+
+			cfg, err := loadTestConfig(t, tt.args, tt.env, tt.dir)
+			if err != nil {
+				t.Fatalf("loadConfig failed: %v", err)
+			}
+
+			key, source, err := (&App{Getenv: func(k string) string { return tt.env[k] }}).resolveAPIKey(context.Background(), cfg, "MISTRAL_API_KEY")
+			if err != nil {
+				t.Fatalf("resolveAPIKey failed: %v", err)
+			}
+			if key != tt.wantKey {
+				t.Errorf("expected key %q, got %q", tt.wantKey, key)
+			}
+			if source != tt.wantSource {
+				t.Errorf("expected source %q, got %q", tt.wantSource, source)
+			}
+		})
+	}
+}
+
+func TestResolveAPIKey_OpenAI(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "api_key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	userDir, workDir := configFixture(t, "api_key_file = '"+keyFile+"'\n\n[profiles.local]\nprovider = 'openai'\n"+
+		"[profiles.keyed]\nprovider = 'openai'\napi_key_file = '"+keyFile+"'\n", "")
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantKey string
+	}{
+		{name: "mistral key file from config", args: []string{"-provider", "openai"}},
+		{name: "mistral key file from environment", env: map[string]string{"OCR_PROVIDER": "openai", "MISTRAL_API_KEY_FILE": keyFile}},
+		{name: "mistral key file with profile", args: []string{"-profile", "local"}},
+		{name: "key file from flag", args: []string{"-provider", "openai", "-api-key-file", keyFile}, wantKey: "file-key"},
+		{name: "key file in profile", args: []string{"-profile", "keyed"}, wantKey: "file-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"XDG_CONFIG_HOME": userDir}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+			cfg, err := loadTestConfig(t, tt.args, env, workDir)
+			if err != nil {
+				t.Fatalf("loadConfig failed: %v", err)
+			}
+
+			key, _, err := (&App{Getenv: func(k string) string { return env[k] }}).resolveAPIKey(context.Background(), cfg, "OPENAI_API_KEY")
+			if tt.wantKey == "" && !errors.Is(err, errNoAPIKey) {
+				t.Errorf("expected the Mistral key file ignored, got %q (%v)", key, err)
+			}
+			if tt.wantKey != "" && key != tt.wantKey {
+				t.Errorf("expected key %q, got %q (%v)", tt.wantKey, key, err)
+			}
+		})
+	}
+}
+
+func TestResolveAPIKey_CommandStreams(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands use sh syntax")
+	}
+	cfg, err := loadTestConfig(t, []string{"-api-key-command", "echo Passphrase: >&2; read key; echo $key"}, nil, t.TempDir())
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	app := &App{Getenv: func(string) string { return "" }, Stdin: strings.NewReader("typed-key\n"), Stderr: &stderr}
+	key, _, err := app.resolveAPIKey(context.Background(), cfg, "MISTRAL_API_KEY")
+	if err != nil || key != "typed-key" {
+		t.Errorf("expected the key read from the app's stdin, got %q (%v)", key, err)
+	}
+	if stderr.String() != "Passphrase:\n" {
+		t.Errorf("expected the prompt on the app's stderr, got %q", stderr.String())
+	}
+}
+
+func TestResolveAPIKey_Errors(t *testing.T) {
+	dir := t.TempDir()
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"no key", nil, "no API key"},
+		{"missing file", []string{"-api-key-file", filepath.Join(dir, "missing")}, "reading API key file"},
+		{"empty file", []string{"-api-key-file", emptyFile}, "is empty"},
+		{"failing command", []string{"-api-key-command", "echo leaked-key; exit 3"}, "running API key command"},
+		{"command without output", []string{"-api-key-command", "true"}, "printed no key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && slices.Contains(tt.args, "-api-key-command") {
+				t.Skip("commands use sh syntax")
+			}
+
+			cfg, err := loadTestConfig(t, tt.args, nil, dir)
+			if err != nil {
+				t.Fatalf("loadConfig failed: %v", err)
+			}
+
+			_, _, err = (&App{Getenv: func(string) string { return "" }}).resolveAPIKey(context.Background(), cfg, "MISTRAL_API_KEY")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+			if strings.Contains(err.Error(), "leaked-key") {
+				t.Errorf("command output leaked into error: %v", err)
+			}
+		})
+	}
+}
+
+func TestReporter_Redact(t *testing.T) {
+	var buf bytes.Buffer
+	report := NewReporter(&buf, false, true)
+	report.Redact("secret-api-key")
+
+	report.Progress("key %s\n", "secret-api-key")
+	report.Verbose("url https://example.com/?key=secret-api-key\n")
+
+	if strings.Contains(buf.String(), "secret-api-key") {
+		t.Errorf("API key not redacted: %q", buf.String())
+	}
+	if got := strings.Count(buf.String(), redacted); got != 2 {
+		t.Errorf("expected 2 redactions, got %d in %q", got, buf.String())
+	}
+}
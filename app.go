@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// errUsage is returned when the arguments are missing, after the usage
+	// message was printed.
+	errUsage = errors.New("missing arguments")
+	// errFlags is returned when the flags can't be parsed, after the flag
+	// package printed why.
+	errFlags = errors.New("invalid flags")
+)
+
+// App is the command line program. Everything it gets from the process is
+// a field, so that it can be run in tests.
+type App struct {
+	// Args are the command line arguments, starting with the program name.
+	Args   []string
+	Getenv func(string) string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Dir is the working directory that relative paths in arguments,
+	// options, and environment variables are resolved against, and where
+	// the project config file is looked for. Empty means the current
+	// directory.
+	Dir string
+	// Home is the home directory, where the user config file is looked for
+	// unless XDG_CONFIG_HOME is set. Empty means there is none.
+	Home string
+	// StopDir is the last directory searched for a project config file,
+	// going up from Dir. Empty means the root of the file system.
+	StopDir string
+	// NewProvider creates the OCR backend; nil means the one selected by
+	// -provider.
+	NewProvider func(cfg *Config, apiKey string) (Provider, error)
+}
+
+// newApp returns the App for the running process.
+func newApp() *App {
+	home, _ := os.UserHomeDir()
+	return &App{
+		Args:   os.Args,
+		Getenv: os.Getenv,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Home:   home,
+	}
+}
+
+// Run runs the program and returns its exit code: 0 on success, 1 on
+// errors, and 2 for flags that can't be parsed.
+func (a *App) Run() int {
+	err := a.run()
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errFlags):
+		return 2
+	case errors.Is(err, errUsage):
+		return 1
+	default:
+		fmt.Fprintf(a.Stderr, "Error: %v\n", err)
+		return 1
+	}
+}
+
+// name returns the program name for messages.
+func (a *App) name() string {
+	if len(a.Args) == 0 {
+		return "ocr"
+	}
+	return a.Args[0]
+}
+
+// flagSet returns a flag set that reports errors on a.Stderr.
+func (a *App) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.Stderr)
+	return fs
+}
+
+// parse parses args with fs, returning flag.ErrHelp for -h and errFlags
+// for other errors.
+func parse(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errFlags
+	}
+	return err
+}
+
+// workDir returns the directory relative paths are resolved against.
+func (a *App) workDir() (string, error) {
+	if a.Dir != "" {
+		return a.Dir, nil
+	}
+	return os.Getwd()
+}
+
+// path resolves a relative path against a.Dir.
+func (a *App) path(p string) string {
+	if a.Dir == "" || p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(a.Dir, p)
+}
+
+// loadConfig loads the configuration for the flags set on fs.
+func (a *App) loadConfig(fs *flag.FlagSet) (*Config, error) {
+	cwd, err := a.workDir()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig(fs, a.Getenv, configDirs{Home: a.Home, Work: cwd, Stop: a.StopDir})
+	if err != nil {
+		return nil, err
+	}
+	cfg.workDir = a.Dir
+	return cfg, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// manifestName is the name of the manifest in an archive.
+const manifestName = "manifest.json"
+
+// Manifest lists the documents in an archive written with -archive and the
+// files produced for each.
+type Manifest struct {
+	Version   string             `json:"version"`
+	Created   time.Time          `json:"created"`
+	Documents []ManifestDocument `json:"documents"`
+}
+
+// ManifestDocument is a processed document in the manifest. Error is set if
+// processing failed.
+type ManifestDocument struct {
+	Source string         `json:"source"`
+	Pages  int            `json:"pages"`
+	Model  string         `json:"model,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	Files  []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file in the archive.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// newArchiveSink creates the archive at path. The format is chosen by the
+// extension: .zip, .tar, .tar.gz, or .tgz.
+func newArchiveSink(path string) (*manifestSink, error) {
+	lower := strings.ToLower(path)
+	if !strings.HasSuffix(lower, ".zip") && !strings.HasSuffix(lower, ".tar") &&
+		!strings.HasSuffix(lower, ".tar.gz") && !strings.HasSuffix(lower, ".tgz") {
+		return nil, fmt.Errorf("unsupported archive format %q (expected .zip, .tar, .tar.gz, or .tgz)", path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating archive: %w", err)
+	}
+
+	var sink outputSink
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		sink = newZipSink(path, f)
+	case strings.HasSuffix(lower, ".tar"):
+		sink = newTarSink(path, f)
+	default:
+		sink = newTarSink(path, gzipFile{gzip.NewWriter(f), f})
+	}
+
+	return &manifestSink{
+		outputSink: sink,
+		manifest:   Manifest{Version: version, Created: time.Now().UTC(), Documents: []ManifestDocument{}},
+		seen:       make(map[string]bool),
+	}, nil
+}
+
+// gzipFile is a gzip stream to a file; closing it closes both.
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+// zipSink writes files to a zip archive.
+type zipSink struct {
+	name string
+	f    *os.File
+	zw   *zip.Writer
+	now  time.Time
+}
+
+func newZipSink(name string, f *os.File) *zipSink {
+	return &zipSink{name: name, f: f, zw: zip.NewWriter(f), now: time.Now()}
+}
+
+func (s *zipSink) WriteFile(name string, data []byte) (string, error) {
+	w, err := s.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.now})
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	return s.name + ":" + name, nil
+}
+
+func (s *zipSink) Close() error {
+	if err := s.zw.Close(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// manifestSink records the files written to an archive, grouped by
+// document, and adds the manifest when closed.
+type manifestSink struct {
+	outputSink
+	manifest Manifest
+	seen     map[string]bool
+	closed   bool
+}
+
+// StartDocument records that the following files belong to source.
+func (s *manifestSink) StartDocument(source string) {
+	s.manifest.Documents = append(s.manifest.Documents, ManifestDocument{Source: source, Files: []ManifestFile{}})
+}
+
+// FinishDocument records the outcome of processing the current document.
+func (s *manifestSink) FinishDocument(resp *OCRResponse, err error) {
+	doc := &s.manifest.Documents[len(s.manifest.Documents)-1]
+	if err != nil {
+		doc.Error = err.Error()
+		return
+	}
+	doc.Pages = len(resp.Pages)
+	doc.Model = resp.Model
+}
+
+func (s *manifestSink) WriteFile(name string, data []byte) (string, error) {
+	// Archives can't overwrite files the way the output directory does.
+	if s.seen[name] || name == manifestName {
+		return "", fmt.Errorf("duplicate file %s in archive (use an -output-template like \"{name}/{name}\")", name)
+	}
+
+	loc, err := s.outputSink.WriteFile(name, data)
+	if err != nil {
+		return "", err
+	}
+	s.seen[name] = true
+
+	if len(s.manifest.Documents) > 0 {
+		sum := sha256.Sum256(data)
+		doc := &s.manifest.Documents[len(s.manifest.Documents)-1]
+		doc.Files = append(doc.Files, ManifestFile{Name: name, Size: len(data), SHA256: hex.EncodeToString(sum[:])})
+	}
+	return loc, nil
+}
+
+// Close writes the manifest and closes the archive. Further calls do
+// nothing.
+func (s *manifestSink) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	data, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		s.outputSink.Close()
+		return fmt.Errorf("marshaling manifest: %w", err)
+	}
+	if _, err := s.outputSink.WriteFile(manifestName, data); err != nil {
+		s.outputSink.Close()
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return s.outputSink.Close()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestArchive writes two documents, the second failing, to an archive
+// at path.
+func writeTestArchive(t *testing.T, path string) {
+	t.Helper()
+
+	sink, err := newArchiveSink(path)
+	if err != nil {
+		t.Fatalf("newArchiveSink failed: %v", err)
+	}
+
+	sink.StartDocument("scans/report.pdf")
+	for name, data := range map[string]string{
+		"report.md":                "# Report",
+		"images/page_0_img_0.png":  "png data",
+		"images/page_0_img_0.json": `{"type": "photo"}`,
+		"report.annotation.json":   `{"title": "Report"}`,
+	} {
+		if _, err := sink.WriteFile(name, []byte(data)); err != nil {
+			t.Fatalf("WriteFile(%s) failed: %v", name, err)
+		}
+	}
+	sink.FinishDocument(&OCRResponse{Model: "mistral-ocr-2505", Pages: []Page{{}, {}}}, nil)
+
+	sink.StartDocument("broken.pdf")
+	sink.FinishDocument(nil, errors.New("API error (status 500)"))
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func checkManifest(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	if files["report.md"] != "# Report" || files["images/page_0_img_0.png"] != "png data" {
+		t.Errorf("unexpected archive contents: %v", files)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(files[manifestName]), &manifest); err != nil {
+		t.Fatalf("failed to parse manifest: %v", err)
+	}
+	if len(manifest.Documents) != 2 {
+		t.Fatalf("expected 2 documents in manifest, got %d", len(manifest.Documents))
+	}
+
+	doc := manifest.Documents[0]
+	if doc.Source != "scans/report.pdf" || doc.Pages != 2 || doc.Model != "mistral-ocr-2505" || len(doc.Files) != 4 {
+		t.Errorf("unexpected manifest document: %+v", doc)
+	}
+	for _, f := range doc.Files {
+		if f.Size != len(files[f.Name]) || len(f.SHA256) != 64 {
+			t.Errorf("unexpected manifest entry: %+v", f)
+		}
+	}
+
+	if manifest.Documents[1].Error == "" {
+		t.Errorf("expected error for failed document, got %+v", manifest.Documents[1])
+	}
+}
+
+func TestArchiveSink_Zip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.zip")
+	writeTestArchive(t, path)
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("failed to open zip: %v", err)
+	}
+	defer zr.Close()
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	checkManifest(t, files)
+}
+
+func TestArchiveSink_TarGz(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.tar.gz")
+	writeTestArchive(t, path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read gzip: %v", err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("failed to read gzip: %v", err)
+	}
+
+	checkManifest(t, readTar(t, data))
+}
+
+func TestArchiveSink_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := newArchiveSink(filepath.Join(dir, "out.rar")); err == nil || !strings.Contains(err.Error(), "unsupported archive format") {
+		t.Errorf("expected unsupported format error, got: %v", err)
+	}
+
+	sink, err := newArchiveSink(filepath.Join(dir, "out.tar"))
+	if err != nil {
+		t.Fatalf("newArchiveSink failed: %v", err)
+	}
+	defer sink.Close()
+
+	sink.StartDocument("a.pdf")
+	if _, err := sink.WriteFile("images/page_0_img_0.png", nil); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	for _, name := range []string{"images/page_0_img_0.png", manifestName} {
+		if _, err := sink.WriteFile(name, nil); err == nil || !strings.Contains(err.Error(), "duplicate file") {
+			t.Errorf("expected duplicate file error for %s, got: %v", name, err)
+		}
+	}
+}
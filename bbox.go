@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// BoundingBox is a rectangle in page coordinates normalized to the range
+// 0–1, with the origin at the top left of the page.
+type BoundingBox struct {
+	X0 float64 `json:"x0"`
+	Y0 float64 `json:"y0"`
+	X1 float64 `json:"x1"`
+	Y1 float64 `json:"y1"`
+}
+
+// NormalizedBBox returns the image's bounding box relative to the page
+// size, clamped to the page. It returns false if the page size is unknown.
+func (img Image) NormalizedBBox(dim PageDimensions) (BoundingBox, bool) {
+	if dim.Width <= 0 || dim.Height <= 0 {
+		return BoundingBox{}, false
+	}
+
+	w, h := float64(dim.Width), float64(dim.Height)
+	return BoundingBox{
+		X0: clamp01(float64(img.TopLeftX) / w),
+		Y0: clamp01(float64(img.TopLeftY) / h),
+		X1: clamp01(float64(img.BottomRightX) / w),
+		Y1: clamp01(float64(img.BottomRightY) / h),
+	}, true
+}
+
+// PageNormalizedBBox is like NormalizedBBox for an image on page, returning
+// nil when the page has no dimensions.
+func (img Image) PageNormalizedBBox(page Page) *BoundingBox {
+	if page.Dimensions == nil {
+		return nil
+	}
+	bbox, ok := img.NormalizedBBox(*page.Dimensions)
+	if !ok {
+		return nil
+	}
+	return &bbox
+}
+
+func clamp01(v float64) float64 {
+	return min(max(v, 0), 1)
+}
+
+// HasData reports whether the response includes the image's data, which it
+// doesn't when images weren't requested.
+func (img Image) HasData() bool {
+	return img.ImageBase64 != "" || img.File != "" || img.err != nil
+}
+
+// Data returns the decoded image data, from its file if it was streamed to
+// one.
+func (img Image) Data() ([]byte, error) {
+	if img.err != nil {
+		return nil, img.err
+	}
+	if img.File != "" {
+		return os.ReadFile(img.File)
+	}
+
+	b64Data := img.ImageBase64
+	if idx := strings.Index(b64Data, ","); idx != -1 {
+		b64Data = b64Data[idx+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(b64Data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	return data, nil
+}
@@ -9,17 +9,98 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	defaultBaseURL = "https://api.mistral.ai/v1"
 	ocrModel       = "mistral-ocr-latest"
+
+	// maxPagesPerRequest is the largest number of pages the OCR endpoint
+	// accepts in a single request.
+	maxPagesPerRequest = 1000
+
+	// maxInlineDocumentSize is the largest document sent inline as a data
+	// URL. Larger documents are uploaded through the Files API instead.
+	maxInlineDocumentSize = 50 << 20
+
+	// defaultConcurrency is the number of page range requests in flight
+	// when a document is split.
+	defaultConcurrency = 4
+
+	// defaultRetries is the number of times the CLI retries a rate-limited
+	// or failed request. Clients do not retry unless WithRetries is used.
+	defaultRetries = 2
+
+	// defaultRetryDelay is the delay before the first retry. It doubles
+	// with every attempt unless the server sends Retry-After.
+	defaultRetryDelay = time.Second
 )
 
 // OCROptions configures the OCR request.
 type OCROptions struct {
 	ExtractImageMetadata bool
 	DocumentSchema       *JSONSchema
+
+	// MaxPagesPerRequest caps the pages sent in a single request. Longer
+	// PDFs are split into page ranges and the results merged. Zero means
+	// the API limit.
+	MaxPagesPerRequest int
+
+	// Concurrency is the number of page range requests in flight at once
+	// when a document is split. Zero means defaultConcurrency.
+	Concurrency int
+
+	// ExtractHeaders asks the API to return page headers and footers
+	// separately from the Markdown. Models that don't support it ignore it.
+	ExtractHeaders bool
+
+	// TableFormat asks the API to return tables separately from the
+	// Markdown, as "markdown" or "html". Empty leaves them in the Markdown.
+	TableFormat string
+
+	// IncludeImages asks the API for the data of extracted images. Nil
+	// means true. Without data, images are still listed with their
+	// positions, but not saved.
+	IncludeImages *bool
+
+	// ImageLimit caps the number of images extracted from the document, and
+	// ImageMinSize skips images narrower or shorter than it, in pixels. Zero
+	// means no limit.
+	ImageLimit   int
+	ImageMinSize int
+
+	// ImageDir is where ProcessDocumentStream writes image data as it is
+	// decoded, instead of holding it in memory. Empty keeps the data in
+	// Image.ImageBase64.
+	ImageDir string
+}
+
+func (o OCROptions) includeImages() bool {
+	return o.IncludeImages == nil || *o.IncludeImages
+}
+
+func (o OCROptions) maxPages() int {
+	if o.MaxPagesPerRequest > 0 {
+		return min(o.MaxPagesPerRequest, maxPagesPerRequest)
+	}
+	return maxPagesPerRequest
+}
+
+// annotated reports whether the request asks for annotations, which are
+// billed on top of plain OCR.
+func (o OCROptions) annotated() bool {
+	return o.ExtractImageMetadata || o.DocumentSchema != nil
+}
+
+func (o OCROptions) concurrency() int {
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	return defaultConcurrency
 }
 
 // ImageMetadataSchema is the built-in schema for bbox annotations.
@@ -136,16 +217,58 @@ var ImageMetadataSchema = JSONSchema{
 type Client struct {
 	apiKey     string
 	baseURL    string
+	model      string
+	retries    int
+	retryDelay time.Duration
 	httpClient *http.Client
 }
 
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithBaseURL sets the API base URL, e.g. to route requests through a
+// gateway or to a self-hosted compatible deployment.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithModel sets the OCR model, e.g. to pin a model version.
+func WithModel(model string) ClientOption {
+	return func(c *Client) {
+		c.model = model
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for API requests.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a request is retried when it is rate
+// limited, fails with a server error, or cannot be sent.
+func WithRetries(retries int) ClientOption {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
 // NewClient creates a new Mistral OCR client.
-func NewClient(apiKey string) *Client {
-	return &Client{
+func NewClient(apiKey string, opts ...ClientOption) *Client {
+	c := &Client{
 		apiKey:     apiKey,
 		baseURL:    defaultBaseURL,
+		model:      ocrModel,
+		retryDelay: defaultRetryDelay,
 		httpClient: http.DefaultClient,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // ProcessPDF reads a PDF file and sends it to the Mistral OCR API.
@@ -154,22 +277,116 @@ func (c *Client) ProcessPDF(ctx context.Context, pdfPath string) (*OCRResponse,
 }
 
 // ProcessDocument reads a document file and sends it to the Mistral OCR API with options.
+// The document is validated locally first, so corrupt, encrypted, or
+// unsupported files fail before anything is uploaded. Documents that exceed
+// the per-request page or size limits are split into page ranges that are
+// processed concurrently, or uploaded; see processSplit.
 func (c *Client) ProcessDocument(ctx context.Context, docPath string, opts OCROptions) (*OCRResponse, error) {
 	docData, err := os.ReadFile(docPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading PDF file: %w", err)
 	}
 
-	encoded := base64.StdEncoding.EncodeToString(docData)
-	dataURL := "data:application/pdf;base64," + encoded
+	return c.ProcessBytes(ctx, filepath.Base(docPath), docData, opts)
+}
+
+// ProcessBytes sends a document held in memory, such as one read from
+// stdin, to the Mistral OCR API. The document type is detected from its
+// contents; name is only used in messages and for uploads.
+func (c *Client) ProcessBytes(ctx context.Context, name string, docData []byte, opts OCROptions) (*OCRResponse, error) {
+	info := inspectDocument(name, docData)
+	if err := info.Validate(); err != nil {
+		return nil, err
+	}
+
+	if info.Splittable() && info.Pages > opts.maxPages() || len(docData) > maxInlineDocumentSize {
+		return c.processSplit(ctx, info, docData, opts, c.doRequest)
+	}
+
+	document := newDocumentURL(info.MIMEType, dataURL(info.MIMEType, docData))
+	return c.doRequest(ctx, c.newOCRRequest(document, nil, opts))
+}
+
+// ProcessDocumentStream is like ProcessDocument, but decodes the response as
+// it arrives and calls fn with each page in order instead of collecting
+// them, so that image-heavy documents don't need to fit in memory. Image
+// data is written to files in opts.ImageDir when set. The returned response
+// has everything but the pages. An error from fn stops processing and is
+// returned.
+func (c *Client) ProcessDocumentStream(ctx context.Context, docPath string, opts OCROptions, fn func(Page) error) (*OCRResponse, error) {
+	docData, err := os.ReadFile(docPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading PDF file: %w", err)
+	}
+
+	return c.ProcessBytesStream(ctx, filepath.Base(docPath), docData, opts, fn)
+}
+
+// ProcessBytesStream is like ProcessDocumentStream for a document held in
+// memory. The pages of documents split into page ranges are passed to fn
+// once all ranges are done.
+func (c *Client) ProcessBytesStream(ctx context.Context, name string, docData []byte, opts OCROptions, fn func(Page) error) (*OCRResponse, error) {
+	info := inspectDocument(name, docData)
+	if err := info.Validate(); err != nil {
+		return nil, err
+	}
+
+	if info.Splittable() && info.Pages > opts.maxPages() || len(docData) > maxInlineDocumentSize {
+		resp, err := c.processSplit(ctx, info, docData, opts, func(ctx context.Context, req OCRRequest) (*OCRResponse, error) {
+			var pages []Page
+			resp, err := c.doRequestStream(ctx, req, opts.ImageDir, func(page Page) error {
+				pages = append(pages, page)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			resp.Pages = pages
+			return resp, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range resp.Pages {
+			if err := fn(page); err != nil {
+				return nil, err
+			}
+		}
+		resp.Pages = nil
+		return resp, nil
+	}
+
+	document := newDocumentURL(info.MIMEType, dataURL(info.MIMEType, docData))
+	return c.doRequestStream(ctx, c.newOCRRequest(document, nil, opts), opts.ImageDir, fn)
+}
+
+// dataURL encodes a document as a base64 data URL.
+func dataURL(mimeType string, data []byte) string {
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// newDocumentURL references the document at url: images as an image_url
+// chunk, other documents as a document_url chunk.
+func newDocumentURL(mimeType, url string) DocumentURL {
+	if strings.HasPrefix(mimeType, "image/") {
+		return DocumentURL{Type: "image_url", ImageURL: url}
+	}
+	return DocumentURL{Type: "document_url", DocumentURL: url}
+}
 
+// newOCRRequest builds the request for the given document. A nil pages
+// slice requests the whole document.
+func (c *Client) newOCRRequest(document DocumentURL, pages []int, opts OCROptions) OCRRequest {
 	req := OCRRequest{
-		Model: ocrModel,
-		Document: DocumentURL{
-			Type:        "document_url",
-			DocumentURL: dataURL,
-		},
-		IncludeImageBase64: true,
+		Model:              c.model,
+		Document:           document,
+		Pages:              pages,
+		IncludeImageBase64: opts.includeImages(),
+		ImageLimit:         opts.ImageLimit,
+		ImageMinSize:       opts.ImageMinSize,
+		ExtractHeader:      opts.ExtractHeaders,
+		ExtractFooter:      opts.ExtractHeaders,
+		TableFormat:        opts.TableFormat,
 	}
 
 	if opts.ExtractImageMetadata {
@@ -186,11 +403,48 @@ func (c *Client) ProcessDocument(ctx context.Context, docPath string, opts OCROp
 		}
 	}
 
-	return c.doRequest(ctx, req)
+	return req
 }
 
 // doRequest sends the OCR request to the Mistral API.
 func (c *Client) doRequest(ctx context.Context, ocrReq OCRRequest) (*OCRResponse, error) {
+	req, err := c.newHTTPRequest(ctx, ocrReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var ocrResp OCRResponse
+	if err := c.do(req, &ocrResp); err != nil {
+		return nil, err
+	}
+
+	return &ocrResp, nil
+}
+
+// doRequestStream sends the OCR request to the Mistral API and decodes the
+// response as it arrives with decodeResponseStream.
+func (c *Client) doRequestStream(ctx context.Context, ocrReq OCRRequest, imageDir string, fn func(Page) error) (*OCRResponse, error) {
+	req, err := c.newHTTPRequest(ctx, ocrReq)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.openWithRetries(req)
+	if err != nil {
+		return nil, c.redact(err)
+	}
+	defer resp.Body.Close()
+
+	ocrResp, err := decodeResponseStream(resp.Body, imageDir, fn)
+	if err != nil {
+		return nil, c.redact(err)
+	}
+
+	return ocrResp, nil
+}
+
+// newHTTPRequest creates the HTTP request for an OCR request.
+func (c *Client) newHTTPRequest(ctx context.Context, ocrReq OCRRequest) (*http.Request, error) {
 	body, err := json.Marshal(ocrReq)
 	if err != nil {
 		return nil, fmt.Errorf("marshaling request: %w", err)
@@ -202,27 +456,139 @@ func (c *Client) doRequest(ctx context.Context, ocrReq OCRRequest) (*OCRResponse
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	return req, nil
+}
+
+// do authenticates and sends an API request and unmarshals the JSON
+// response into v. A nil v discards the response body. The API key is
+// redacted from returned errors.
+func (c *Client) do(req *http.Request, v any) error {
+	respBody, err := c.sendWithRetries(req)
+	if err != nil {
+		return c.redact(err)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, v); err != nil {
+		return c.redact(fmt.Errorf("unmarshaling response: %w", err))
+	}
 
+	return nil
+}
+
+// sendWithRetries sends req and returns the body of a successful response.
+// Rate-limited and failed requests are retried up to c.retries times.
+func (c *Client) sendWithRetries(req *http.Request) ([]byte, error) {
+	respBody, _, err := c.retry(req, false)
+	return respBody, err
+}
+
+// openWithRetries is like sendWithRetries, but returns a successful
+// response with its body unread, to be decoded as it arrives. The caller
+// closes the body.
+func (c *Client) openWithRetries(req *http.Request) (*http.Response, error) {
+	_, resp, err := c.retry(req, true)
+	return resp, err
+}
+
+// retry sends req until it succeeds or the retries are used up. When
+// stream is set, the body of a successful response is left unread.
+func (c *Client) retry(req *http.Request, stream bool) ([]byte, *http.Response, error) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	for attempt := 0; ; attempt++ {
+		respBody, resp, err := c.send(req, stream)
+
+		retry := err != nil && req.Context().Err() == nil ||
+			err == nil && retryableStatus(resp.StatusCode)
+		if attempt < c.retries && retry {
+			select {
+			case <-time.After(c.backoff(attempt, resp)):
+			case <-req.Context().Done():
+				return nil, nil, fmt.Errorf("sending request: %w", req.Context().Err())
+			}
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, nil, fmt.Errorf("creating request: %w", err)
+				}
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !successStatus(resp.StatusCode) {
+			return nil, nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
+		}
+
+		return respBody, resp, nil
+	}
+}
+
+// redact hides the API key in err, in case the server or a proxy echoes
+// it back.
+func (c *Client) redact(err error) error {
+	if c.apiKey == "" {
+		return err
+	}
+	return &redactedError{err: err, key: c.apiKey}
+}
+
+// send performs a single attempt of req and reads the response body,
+// unless stream is set and the request succeeded.
+func (c *Client) send(req *http.Request, stream bool) ([]byte, *http.Response, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return nil, nil, fmt.Errorf("sending request: %w", err)
+	}
+	if stream && successStatus(resp.StatusCode) {
+		return nil, resp, nil
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
+		return nil, nil, fmt.Errorf("reading response: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(respBody))
-	}
+	return respBody, resp, nil
+}
 
-	var ocrResp OCRResponse
-	if err := json.Unmarshal(respBody, &ocrResp); err != nil {
-		return nil, fmt.Errorf("unmarshaling response: %w", err)
+// successStatus reports whether status is a 2xx success.
+func successStatus(status int) bool {
+	return status >= 200 && status <= 299
+}
+
+// retryableStatus reports whether a request that failed with status may
+// succeed when retried.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
 	}
+	return false
+}
 
-	return &ocrResp, nil
+// backoff returns how long to wait before retrying after the given attempt,
+// honoring the server's Retry-After header when present.
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			if secs, err := strconv.Atoi(after); err == nil && secs >= 0 {
+				return time.Duration(secs) * time.Second
+			}
+			if t, err := http.ParseTime(after); err == nil {
+				return max(time.Until(t), 0)
+			}
+		}
+	}
+	return c.retryDelay << attempt
 }
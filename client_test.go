@@ -1,15 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"ocr/ocrtest"
 )
 
 func TestProcessPDF_Success(t *testing.T) {
@@ -69,7 +79,7 @@ func TestProcessPDF_Success(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	pdfPath := filepath.Join(tmpDir, "test.pdf")
-	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4 fake pdf"), 0644); err != nil {
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
 		t.Fatalf("failed to create test PDF: %v", err)
 	}
 
@@ -103,7 +113,7 @@ func TestProcessPDF_APIError(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	pdfPath := filepath.Join(tmpDir, "test.pdf")
-	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4 fake pdf"), 0644); err != nil {
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
 		t.Fatalf("failed to create test PDF: %v", err)
 	}
 
@@ -117,6 +127,35 @@ func TestProcessPDF_APIError(t *testing.T) {
 	}
 }
 
+func TestProcessPDF_RedactsAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A misbehaving proxy that echoes the request headers.
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": "bad request", "authorization": %q}`, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	client := NewClient("secret-api-key", WithBaseURL(server.URL))
+
+	tmpDir := t.TempDir()
+	pdfPath := filepath.Join(tmpDir, "test.pdf")
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
+		t.Fatalf("failed to create test PDF: %v", err)
+	}
+
+	_, err := client.ProcessPDF(context.Background(), pdfPath)
+	if err == nil {
+		t.Fatal("expected error for bad request")
+	}
+
+	if strings.Contains(err.Error(), "secret-api-key") {
+		t.Errorf("API key not redacted: %v", err)
+	}
+	if !strings.Contains(err.Error(), "Bearer [REDACTED]") {
+		t.Errorf("expected redacted key in error, got: %v", err)
+	}
+}
+
 func TestProcessPDF_FileNotFound(t *testing.T) {
 	client := NewClient("test-api-key")
 
@@ -197,7 +236,7 @@ func TestProcessDocument_WithBBoxAnnotation(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	pdfPath := filepath.Join(tmpDir, "test.pdf")
-	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4 fake pdf"), 0644); err != nil {
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
 		t.Fatalf("failed to create test PDF: %v", err)
 	}
 
@@ -273,7 +312,7 @@ func TestProcessDocument_WithDocumentAnnotation(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	pdfPath := filepath.Join(tmpDir, "test.pdf")
-	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4 fake pdf"), 0644); err != nil {
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
 		t.Fatalf("failed to create test PDF: %v", err)
 	}
 
@@ -355,7 +394,7 @@ func TestProcessDocument_WithBothAnnotations(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	pdfPath := filepath.Join(tmpDir, "test.pdf")
-	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4 fake pdf"), 0644); err != nil {
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
 		t.Fatalf("failed to create test PDF: %v", err)
 	}
 
@@ -379,3 +418,413 @@ func TestProcessDocument_WithBothAnnotations(t *testing.T) {
 		t.Error("expected image annotation")
 	}
 }
+
+func TestProcessDocument_SplitsLongPDF(t *testing.T) {
+	var mu sync.Mutex
+	var requested [][]int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OCRRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+
+		mu.Lock()
+		requested = append(requested, req.Pages)
+		mu.Unlock()
+
+		// Every range numbers its images from zero, as the API does.
+		resp := OCRResponse{
+			Model:     "mistral-ocr-2505",
+			UsageInfo: &UsageInfo{PagesProcessed: len(req.Pages), DocSizeBytes: 4096},
+			DocumentAnnotation: map[string]any{
+				"title": "Annual Report",
+				"pages": []any{req.Pages[0]},
+			},
+		}
+		for _, idx := range req.Pages {
+			resp.Pages = append(resp.Pages, Page{
+				Index:    idx,
+				Markdown: "![img-0.jpeg](img-0.jpeg)",
+				Images:   []Image{{ID: "img-0.jpeg"}},
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-api-key")
+	client.baseURL = server.URL
+
+	tmpDir := t.TempDir()
+	pdfPath := filepath.Join(tmpDir, "long.pdf")
+	if err := os.WriteFile(pdfPath, buildTestPDF(5, false), 0644); err != nil {
+		t.Fatalf("failed to create test PDF: %v", err)
+	}
+
+	opts := OCROptions{MaxPagesPerRequest: 2, Concurrency: 2}
+	resp, err := client.ProcessDocument(context.Background(), pdfPath, opts)
+	if err != nil {
+		t.Fatalf("ProcessDocument failed: %v", err)
+	}
+
+	if len(requested) != 3 {
+		t.Fatalf("expected 3 requests, got %d: %v", len(requested), requested)
+	}
+
+	if len(resp.Pages) != 5 {
+		t.Fatalf("expected 5 pages, got %d", len(resp.Pages))
+	}
+
+	for i, page := range resp.Pages {
+		if page.Index != i {
+			t.Errorf("page %d: expected index %d, got %d", i, i, page.Index)
+		}
+
+		id := fmt.Sprintf("img-%d.jpeg", i)
+		if page.Images[0].ID != id {
+			t.Errorf("page %d: expected image ID %s, got %s", i, id, page.Images[0].ID)
+		}
+
+		if want := "![" + id + "](" + id + ")"; page.Markdown != want {
+			t.Errorf("page %d: expected markdown %q, got %q", i, want, page.Markdown)
+		}
+	}
+
+	if resp.Model != "mistral-ocr-2505" {
+		t.Errorf("expected model mistral-ocr-2505, got %q", resp.Model)
+	}
+
+	if resp.UsageInfo == nil || resp.UsageInfo.PagesProcessed != 5 || resp.UsageInfo.DocSizeBytes != 4096 {
+		t.Errorf("expected usage of 5 pages and 4096 bytes, got %+v", resp.UsageInfo)
+	}
+
+	annotation, ok := resp.DocumentAnnotation.(map[string]any)
+	if !ok {
+		t.Fatalf("expected document annotation to be a map, got %T", resp.DocumentAnnotation)
+	}
+
+	if annotation["title"] != "Annual Report" {
+		t.Errorf("expected title 'Annual Report', got %v", annotation["title"])
+	}
+
+	if pages, _ := annotation["pages"].([]any); len(pages) != 3 {
+		t.Errorf("expected arrays from 3 ranges to be concatenated, got %v", annotation["pages"])
+	}
+}
+
+func TestProcessDocument_SplitError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OCRRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Pages[0] == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "boom"}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(OCRResponse{})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-api-key")
+	client.baseURL = server.URL
+
+	tmpDir := t.TempDir()
+	pdfPath := filepath.Join(tmpDir, "long.pdf")
+	if err := os.WriteFile(pdfPath, buildTestPDF(4, false), 0644); err != nil {
+		t.Fatalf("failed to create test PDF: %v", err)
+	}
+
+	_, err := client.ProcessDocument(context.Background(), pdfPath, OCROptions{MaxPagesPerRequest: 2})
+	if err == nil {
+		t.Fatal("expected error from failing range")
+	}
+
+	if !strings.Contains(err.Error(), "pages 3-4") || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected error for pages 3-4 with status 500, got: %v", err)
+	}
+}
+
+func TestProcessDocument_DataURLs(t *testing.T) {
+	var pngData, jpegData bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatalf("failed to encode JPEG: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantType string
+		prefix   string
+	}{
+		{"doc.pdf", buildTestPDF(1, false), "document_url", "data:application/pdf;base64,"},
+		{"doc.docx", buildTestZip(t, map[string][]byte{"word/document.xml": nil}), "document_url",
+			"data:application/vnd.openxmlformats-officedocument.wordprocessingml.document;base64,"},
+		{"doc.pptx", buildTestZip(t, map[string][]byte{"ppt/presentation.xml": nil}), "document_url",
+			"data:application/vnd.openxmlformats-officedocument.presentationml.presentation;base64,"},
+		{"doc.odt", buildTestZip(t, map[string][]byte{"mimetype": []byte(mimeODT)}), "document_url",
+			"data:application/vnd.oasis.opendocument.text;base64,"},
+		{"doc.epub", buildTestZip(t, map[string][]byte{"mimetype": []byte(mimeEPUB)}), "document_url",
+			"data:application/epub+zip;base64,"},
+		{"scan.png", pngData.Bytes(), "image_url", "data:image/png;base64,"},
+		{"scan.jpg", jpegData.Bytes(), "image_url", "data:image/jpeg;base64,"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OCRRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("failed to decode request: %v", err)
+				}
+				json.NewEncoder(w).Encode(OCRResponse{Pages: []Page{{Markdown: "text"}}})
+			}))
+			defer server.Close()
+
+			docPath := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(docPath, tt.data, 0644); err != nil {
+				t.Fatalf("failed to write document: %v", err)
+			}
+
+			client := NewClient("test-api-key", WithBaseURL(server.URL))
+			if _, err := client.ProcessDocument(context.Background(), docPath, OCROptions{}); err != nil {
+				t.Fatalf("ProcessDocument failed: %v", err)
+			}
+
+			if req.Document.Type != tt.wantType {
+				t.Errorf("expected document type %s, got %s", tt.wantType, req.Document.Type)
+			}
+			url := req.Document.DocumentURL + req.Document.ImageURL
+			if !strings.HasPrefix(url, tt.prefix) {
+				t.Errorf("expected URL prefix %s, got %.80s", tt.prefix, url)
+			}
+			if want := base64.StdEncoding.EncodeToString(tt.data); strings.TrimPrefix(url, tt.prefix) != want {
+				t.Error("expected the document to be encoded after the prefix")
+			}
+		})
+	}
+}
+
+func TestProcessDocument_SplitDataURLs(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	// Office documents are sent whole whatever their page metadata says. An
+	// image only goes through the split path when it is too large to be sent
+	// inline, and is then uploaded.
+	largePNG := append(pngData.Bytes(), make([]byte, maxInlineDocumentSize)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		mimeType string
+		wantType string
+		requests int
+	}{
+		{"doc.docx", buildTestZip(t, map[string][]byte{
+			"word/document.xml": nil, "docProps/app.xml": []byte("<Properties><Pages>3</Pages></Properties>"),
+		}), mimeDOCX, "document_url", 1},
+		{"doc.pptx", buildTestZip(t, map[string][]byte{
+			"ppt/presentation.xml": nil, "ppt/slides/slide1.xml": nil, "ppt/slides/slide2.xml": nil, "ppt/slides/slide3.xml": nil,
+		}), mimePPTX, "document_url", 1},
+		{"doc.odt", buildTestZip(t, map[string][]byte{
+			"mimetype": []byte(mimeODT), "meta.xml": []byte(`<meta:document-statistic meta:page-count="3"/>`),
+		}), mimeODT, "document_url", 1},
+		{"scan.png", largePNG, "image/png", "image_url", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := ocrtest.New(t)
+			server.ExpectMIMEType(tt.mimeType)
+
+			client := NewClient("test-api-key", WithBaseURL(server.URL))
+			if _, err := client.ProcessBytes(context.Background(), tt.name, tt.data, OCROptions{MaxPagesPerRequest: 2}); err != nil {
+				t.Fatalf("ProcessBytes failed: %v", err)
+			}
+
+			requests := server.OCRRequests()
+			if len(requests) != tt.requests {
+				t.Fatalf("expected %d requests, got %d", tt.requests, len(requests))
+			}
+			for _, req := range requests {
+				if req.OCR.Document.Type != tt.wantType || req.MIMEType() != tt.mimeType {
+					t.Errorf("expected a %s of type %s, got %s of type %s", tt.wantType, tt.mimeType, req.OCR.Document.Type, req.MIMEType())
+				}
+				if req.OCR.Pages != nil {
+					t.Errorf("expected the whole document, got pages %v", req.OCR.Pages)
+				}
+			}
+		})
+	}
+}
+
+func TestNewClient_Options(t *testing.T) {
+	var gotModel string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gateway/v1/ocr" {
+			t.Errorf("expected /gateway/v1/ocr, got %s", r.URL.Path)
+		}
+
+		var req OCRRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		gotModel = req.Model
+
+		json.NewEncoder(w).Encode(OCRResponse{})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-api-key",
+		WithBaseURL(server.URL+"/gateway/v1/"),
+		WithModel("mistral-ocr-2505"),
+		WithHTTPClient(server.Client()),
+	)
+
+	tmpDir := t.TempDir()
+	pdfPath := filepath.Join(tmpDir, "test.pdf")
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
+		t.Fatalf("failed to create test PDF: %v", err)
+	}
+
+	if _, err := client.ProcessPDF(context.Background(), pdfPath); err != nil {
+		t.Fatalf("ProcessPDF failed: %v", err)
+	}
+
+	if gotModel != "mistral-ocr-2505" {
+		t.Errorf("expected model mistral-ocr-2505, got %s", gotModel)
+	}
+}
+
+func TestProcessDocument_Retries(t *testing.T) {
+	server := ocrtest.New(t)
+	server.Script(ocrtest.RateLimited("0"), ocrtest.Fail(http.StatusBadGateway), ocrtest.Truncated(5))
+
+	client := NewClient("test-api-key", WithBaseURL(server.URL), WithRetries(3))
+	client.retryDelay = time.Millisecond
+
+	tmpDir := t.TempDir()
+	pdfPath := filepath.Join(tmpDir, "test.pdf")
+	if err := os.WriteFile(pdfPath, buildTestPDF(1, false), 0644); err != nil {
+		t.Fatalf("failed to create test PDF: %v", err)
+	}
+
+	resp, err := client.ProcessPDF(context.Background(), pdfPath)
+	if err != nil {
+		t.Fatalf("ProcessPDF failed: %v", err)
+	}
+
+	if attempts := len(server.OCRRequests()); attempts != 4 {
+		t.Errorf("expected 4 attempts, got %d", attempts)
+	}
+	if len(resp.Pages) != 1 {
+		t.Errorf("expected 1 page, got %d", len(resp.Pages))
+	}
+
+	// Client errors are not retried.
+	client.retries = 5
+	server.Script(ocrtest.Fail(http.StatusBadRequest))
+
+	if _, err := client.ProcessPDF(context.Background(), pdfPath); err == nil {
+		t.Fatal("expected error for bad request")
+	}
+	if attempts := len(server.OCRRequests()) - 4; attempts != 1 {
+		t.Errorf("expected 1 attempt for a client error, got %d", attempts)
+	}
+}
+
+func TestProcessFile_Fixture(t *testing.T) {
+	server := ocrtest.New(t)
+	server.RespondFixture("testdata/invoice.json")
+	server.ExpectModel(ocrModel)
+	server.ExpectMIMEType("application/pdf")
+	server.ExpectBBoxAnnotation("image_metadata")
+	server.ExpectDocumentAnnotation("invoice")
+
+	dir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: filepath.Join(dir, "invoice.pdf"), Data: buildTestPDF(1, false), Dir: dir}
+	ro := runOptions{OCR: OCROptions{ExtractImageMetadata: true, DocumentSchema: &JSONSchema{Name: "invoice"}}}
+
+	if _, err := processFile(context.Background(), client, doc, ro, NewReporter(io.Discard, true, false)); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"invoice.md":               "# Invoice 2024-001",
+		"invoice.annotation.json":  `"vendor": "ACME Corp."`,
+		"images/page_0_img_0.png":  "\x89PNG",
+		"images/page_0_img_0.json": `"description": "ACME logo"`,
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in %s, got %q (%v)", want, name, data, err)
+		}
+	}
+	if requests := server.OCRRequests(); len(requests) != 1 || requests[0].Header.Get("Authorization") != "Bearer test-api-key" {
+		t.Errorf("expected one authenticated request, got %+v", requests)
+	}
+}
+
+func TestProcessFile_NoImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OCRRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.IncludeImageBase64 || req.ImageLimit != 5 || req.ImageMinSize != 50 {
+			t.Errorf("unexpected image options: include %v, limit %d, min size %d", req.IncludeImageBase64, req.ImageLimit, req.ImageMinSize)
+		}
+		json.NewEncoder(w).Encode(OCRResponse{Pages: []Page{{
+			Index:    0,
+			Markdown: "# Title\n\n![img-0.jpeg](img-0.jpeg)\n\nSee ![img-1.jpeg](img-1.jpeg) here.",
+			Images:   []Image{{ID: "img-0.jpeg", BottomRightX: 10, BottomRightY: 10}, {ID: "img-1.jpeg"}},
+		}}})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: filepath.Join(dir, "scan.pdf"), Data: buildTestPDF(1, false), Dir: dir}
+	include := false
+	ro := runOptions{
+		OCR:        OCROptions{IncludeImages: &include, ImageLimit: 5, ImageMinSize: 50},
+		JSONExport: true,
+	}
+
+	if _, err := processFile(context.Background(), client, doc, ro, NewReporter(io.Discard, true, false)); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	md, err := os.ReadFile(filepath.Join(dir, "scan.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Title\n\nSee  here.\n\n"; string(md) != want {
+		t.Errorf("expected links to missing images dropped, got %q", md)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "images")); !os.IsNotExist(err) {
+		t.Errorf("expected no images directory, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "scan.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export Export
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+	if images := export.Pages[0].Images; len(images) != 2 || images[0].File != "" || images[0].BottomRightX != 10 {
+		t.Errorf("expected images listed with positions but no files, got %s", data)
+	}
+}
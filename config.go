@@ -0,0 +1,595 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// projectConfigName is the name of the project configuration file, looked
+// up in the working directory and its parents.
+const projectConfigName = ".ocr.yaml"
+
+// settingKind is the type of a configuration value.
+type settingKind int
+
+const (
+	kindString settingKind = iota
+	kindPath               // a string resolved relative to the file that sets it
+	kindBool
+	kindInt
+)
+
+// setting describes a configuration key and the flag and environment
+// variable that can set it.
+type setting struct {
+	Key     string
+	Flag    string
+	Env     string
+	Kind    settingKind
+	Default any
+	Usage   string
+}
+
+// settings lists every configuration key, in the order config show prints
+// them.
+var settings = []setting{
+	{Key: "output_dir", Flag: "o", Env: "OCR_OUTPUT_DIR", Kind: kindPath, Default: "",
+		Usage: "Output directory (default: same directory as input)"},
+	{Key: "output_template", Flag: "output-template", Env: "OCR_OUTPUT_TEMPLATE", Kind: kindString, Default: "{name}",
+		Usage: "Output file name without extension: {name} is the input basename, {ext} its extension, {date} today's date"},
+	{Key: "format", Flag: "format", Env: "OCR_FORMAT", Kind: kindString, Default: formatMarkdown,
+		Usage: "Comma-separated output formats: markdown, searchable-pdf, docx, epub, hocr, alto"},
+	{Key: "title", Flag: "title", Env: "OCR_TITLE", Kind: kindString, Default: "",
+		Usage: "Title of EPUB output (default: from the document annotation, or the file name)"},
+	{Key: "author", Flag: "author", Env: "OCR_AUTHOR", Kind: kindString, Default: "",
+		Usage: "Author of EPUB output (default: from the document annotation)"},
+	{Key: "image_metadata", Flag: "m", Env: "OCR_IMAGE_METADATA", Kind: kindBool, Default: false,
+		Usage: "Extract image metadata (description, type, structured data)"},
+	{Key: "no_images", Flag: "no-images", Env: "OCR_NO_IMAGES", Kind: kindBool, Default: false,
+		Usage: "Don't request image data, for text-only output; images are still listed in the JSON export"},
+	{Key: "image_limit", Flag: "image-limit", Env: "OCR_IMAGE_LIMIT", Kind: kindInt, Default: 0,
+		Usage: "Maximum number of images to extract per document (0: no limit)"},
+	{Key: "image_min_size", Flag: "image-min-size", Env: "OCR_IMAGE_MIN_SIZE", Kind: kindInt, Default: 0,
+		Usage: "Skip images smaller than this width or height in pixels (0: no minimum)"},
+	{Key: "schema", Flag: "a", Env: "OCR_SCHEMA", Kind: kindPath, Default: "",
+		Usage: "Extract document data using JSON schema file, or a schema name from the config file"},
+	{Key: "json", Flag: "j", Env: "OCR_JSON", Kind: kindBool, Default: false,
+		Usage: "Write JSON export (<basename>.json) with pages, dimensions, image positions, and usage"},
+	{Key: "front_matter", Flag: "front-matter", Env: "OCR_FRONT_MATTER", Kind: kindBool, Default: false,
+		Usage: "Start the Markdown with YAML front matter: source, SHA-256, pages, model, options, and annotation fields"},
+	{Key: "quiet", Flag: "q", Env: "OCR_QUIET", Kind: kindBool, Default: false,
+		Usage: "Quiet mode (suppress progress output)"},
+	{Key: "verbose", Flag: "v", Env: "OCR_VERBOSE", Kind: kindBool, Default: false,
+		Usage: "Verbose mode (extra details to stderr)"},
+	{Key: "extract_headers", Flag: "extract-headers", Env: "OCR_EXTRACT_HEADERS", Kind: kindBool, Default: false,
+		Usage: "Ask the API to return page headers and footers separately from the Markdown (newer models)"},
+	{Key: "strip_headers", Flag: "strip-headers", Env: "OCR_STRIP_HEADERS", Kind: kindBool, Default: false,
+		Usage: "Remove running headers, footers, and page numbers repeated across pages from the Markdown"},
+	{Key: "reflow", Flag: "reflow", Env: "OCR_REFLOW", Kind: kindBool, Default: false,
+		Usage: "Join paragraphs and tables split across pages and words hyphenated at line ends in the Markdown"},
+	{Key: "tables", Flag: "tables", Env: "OCR_TABLES", Kind: kindBool, Default: false,
+		Usage: "Write each table as CSV to tables/ and an index to <basename>.tables.json"},
+	{Key: "table_links", Flag: "table-links", Env: "OCR_TABLE_LINKS", Kind: kindBool, Default: false,
+		Usage: "With -tables, replace each table in the Markdown with a link to its CSV file"},
+	{Key: "table_format", Flag: "table-format", Env: "OCR_TABLE_FORMAT", Kind: kindString, Default: "",
+		Usage: "Ask the API to return tables separately as \"markdown\" or \"html\" (newer models)"},
+	{Key: "max_pages", Flag: "max-pages", Env: "OCR_MAX_PAGES", Kind: kindInt, Default: maxPagesPerRequest,
+		Usage: "Maximum pages per API request; longer PDFs are split"},
+	{Key: "concurrency", Flag: "concurrency", Env: "OCR_CONCURRENCY", Kind: kindInt, Default: defaultConcurrency,
+		Usage: "Number of concurrent requests when splitting"},
+	{Key: "retries", Flag: "retries", Env: "OCR_RETRIES", Kind: kindInt, Default: defaultRetries,
+		Usage: "Number of retries for rate-limited or failed requests"},
+	{Key: "budget_pages", Flag: "budget-pages", Env: "OCR_BUDGET_PAGES", Kind: kindInt, Default: 0,
+		Usage: "Refuse to process more than this many pages in total (0: no limit)"},
+	{Key: "price_table", Flag: "price-table", Env: "OCR_PRICE_TABLE", Kind: kindPath, Default: "",
+		Usage: "Price table JSON file for the cost summary"},
+	{Key: "provider", Flag: "provider", Env: "OCR_PROVIDER", Kind: kindString, Default: providerMistral,
+		Usage: "OCR backend: mistral, or openai for an OpenAI-compatible vision model endpoint"},
+	{Key: "model", Flag: "model", Env: "OCR_MODEL", Kind: kindString, Default: ocrModel,
+		Usage: "OCR model"},
+	{Key: "base_url", Flag: "base-url", Env: "MISTRAL_BASE_URL", Kind: kindString, Default: defaultBaseURL,
+		Usage: "API base URL"},
+	{Key: "record", Flag: "record", Env: "OCR_RECORD", Kind: kindPath, Default: "",
+		Usage: "Save each API request and response to this directory, which must be empty or new"},
+	{Key: "replay", Flag: "replay", Env: "OCR_REPLAY", Kind: kindPath, Default: "",
+		Usage: "Serve API responses from a directory saved with -record instead of calling the API"},
+	{Key: "profile", Flag: "profile", Env: "OCR_PROFILE", Kind: kindString, Default: "",
+		Usage: "Named profile from the config file"},
+	{Key: "api_key_file", Flag: "api-key-file", Env: "MISTRAL_API_KEY_FILE", Kind: kindPath, Default: "",
+		Usage: "Read the API key from this file"},
+	{Key: "api_key_command", Flag: "api-key-command", Env: "OCR_API_KEY_COMMAND", Kind: kindString, Default: "",
+		Usage: "Shell command that prints the API key, e.g. \"pass show mistral\""},
+}
+
+// userOnlyKeys can't be set in a project config file, which comes with the
+// directory a command runs in: they run commands, read secrets, or decide
+// where documents and the API key are sent.
+var userOnlyKeys = []string{"api_key_command", "api_key_file", "base_url", "provider", "record", "replay"}
+
+// Configuration layers, from lowest to highest precedence.
+const (
+	layerDefault = iota
+	layerUser
+	layerProject
+	layerProfile
+	layerEnv
+	layerFlag
+)
+
+// Config is the effective configuration of a run. Every value records the
+// layer and source it came from.
+type Config struct {
+	values  map[string]any
+	layers  map[string]int
+	sources map[string]string
+	dirs    map[string]string
+
+	// Schemas maps schema names to JSON schema files, for use with -a.
+	Schemas map[string]string
+
+	profiles map[string]map[string]any
+
+	// workDir, if set, is the directory that paths from flags and the
+	// environment are resolved against.
+	workDir string
+	// home is the directory paths starting with ~/ are relative to.
+	home string
+}
+
+// configDirs are the directories loadConfig looks for config files in.
+type configDirs struct {
+	// Home is the home directory, for ~/.config/ocr/config.toml if
+	// XDG_CONFIG_HOME isn't set, and for paths starting with ~/. Empty
+	// means there is none.
+	Home string
+	// Work is where the search for a project config file starts.
+	Work string
+	// Stop is the last directory searched for a project config file; empty
+	// means the root of the file system.
+	Stop string
+}
+
+// defineSettingFlags registers a flag for every setting on fs.
+func defineSettingFlags(fs *flag.FlagSet) {
+	for _, s := range settings {
+		switch s.Kind {
+		case kindBool:
+			fs.Bool(s.Flag, s.Default.(bool), s.Usage)
+		case kindInt:
+			fs.Int(s.Flag, s.Default.(int), s.Usage)
+		default:
+			fs.String(s.Flag, s.Default.(string), s.Usage)
+		}
+	}
+}
+
+// loadConfig resolves the configuration with the precedence flags, then
+// environment, then the selected profile, then the project config file
+// (.ocr.yaml in dir or a parent), then the user config file, then defaults.
+// Only flags that were set explicitly on fs override other layers.
+func loadConfig(fs *flag.FlagSet, getenv func(string) string, dirs configDirs) (*Config, error) {
+	c := &Config{
+		home:     dirs.Home,
+		values:   make(map[string]any),
+		layers:   make(map[string]int),
+		sources:  make(map[string]string),
+		dirs:     make(map[string]string),
+		Schemas:  make(map[string]string),
+		profiles: make(map[string]map[string]any),
+	}
+
+	for _, s := range settings {
+		c.set(s.Key, s.Default, layerDefault, "default", "")
+	}
+
+	if path := userConfigPath(getenv, dirs.Home); path != "" {
+		if err := c.applyFile(path, parseTOML, layerUser); err != nil {
+			return nil, err
+		}
+	}
+
+	if path := findProjectConfig(dirs.Work, dirs.Stop); path != "" {
+		if err := c.applyFile(path, parseYAML, layerProject); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		raw := getenv(s.Env)
+		if raw == "" {
+			continue
+		}
+		value, err := coerceSetting(s, raw)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", s.Env, err)
+		}
+		c.set(s.Key, value, layerEnv, "env "+s.Env, "")
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := lookupSettingByFlag(f.Name)
+		if !ok || flagErr != nil {
+			return
+		}
+		value, err := coerceSetting(s, f.Value.(flag.Getter).Get())
+		if err != nil {
+			flagErr = fmt.Errorf("flag -%s: %w", f.Name, err)
+			return
+		}
+		c.set(s.Key, value, layerFlag, "flag -"+f.Name, "")
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if name := c.String("profile"); name != "" {
+		if err := c.applyProfile(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// applyFile parses a configuration file and applies it as the given layer.
+// A missing file is skipped.
+func (c *Config) applyFile(path string, parse func([]byte) (map[string]any, error), layer int) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	m, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for key, value := range m {
+		switch key {
+		case "schemas":
+			schemas, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: schemas: expected a table of name = path", path)
+			}
+			for name, p := range schemas {
+				str, ok := p.(string)
+				if !ok {
+					return fmt.Errorf("%s: schemas.%s: expected a path", path, name)
+				}
+				c.Schemas[name] = resolvePath(dir, c.home, str)
+			}
+		case "profiles":
+			profiles, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: profiles: expected a table of profiles", path)
+			}
+			for name, p := range profiles {
+				table, ok := p.(map[string]any)
+				if !ok {
+					return fmt.Errorf("%s: profiles.%s: expected a table", path, name)
+				}
+				if err := validateKeys(table); err != nil {
+					return fmt.Errorf("%s: profiles.%s: %w", path, name, err)
+				}
+				if err := checkUserOnly(table, layer); err != nil {
+					return fmt.Errorf("%s: profiles.%s: %w", path, name, err)
+				}
+				profile := maps.Clone(table)
+				profile[profileDirKey] = dir
+				profile[profileSourceKey] = path
+				c.profiles[name] = profile
+			}
+		default:
+			s, ok := lookupSetting(key)
+			if !ok {
+				return fmt.Errorf("%s: unknown key %q", path, key)
+			}
+			if err := checkUserOnly(map[string]any{key: value}, layer); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			v, err := coerceSetting(s, value)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", path, key, err)
+			}
+			c.set(key, v, layer, path, dir)
+		}
+	}
+
+	return nil
+}
+
+// Profiles keep track of the file that defined them, so relative paths
+// resolve against it. These keys cannot clash with settings.
+const (
+	profileDirKey    = ".dir"
+	profileSourceKey = ".source"
+)
+
+// applyProfile applies the named profile on top of the config files. Values
+// set by the environment or flags keep precedence.
+func (c *Config) applyProfile(name string) error {
+	profile, ok := c.profiles[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(c.profiles))
+		if len(names) == 0 {
+			return fmt.Errorf("profile %q not found: no profiles configured", name)
+		}
+		return fmt.Errorf("profile %q not found (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	dir, _ := profile[profileDirKey].(string)
+	for key, value := range profile {
+		s, ok := lookupSetting(key)
+		if !ok || c.layers[key] > layerProfile {
+			continue
+		}
+		v, err := coerceSetting(s, value)
+		if err != nil {
+			return fmt.Errorf("profile %s: %s: %w", name, key, err)
+		}
+		c.set(key, v, layerProfile, "profile "+name, dir)
+	}
+	return nil
+}
+
+func (c *Config) set(key string, value any, layer int, source, dir string) {
+	c.values[key] = value
+	c.layers[key] = layer
+	c.sources[key] = source
+	c.dirs[key] = dir
+}
+
+// String returns a string setting.
+func (c *Config) String(key string) string {
+	s, _ := c.values[key].(string)
+	return s
+}
+
+// Path returns a path setting, resolved relative to the config file that
+// set it.
+func (c *Config) Path(key string) string {
+	dir := c.dirs[key]
+	if dir == "" {
+		dir = c.workDir
+	}
+	return resolvePath(dir, c.home, c.String(key))
+}
+
+// Bool returns a boolean setting.
+func (c *Config) Bool(key string) bool {
+	b, _ := c.values[key].(bool)
+	return b
+}
+
+// Int returns an integer setting.
+func (c *Config) Int(key string) int {
+	n, _ := c.values[key].(int)
+	return n
+}
+
+// Source returns where the value of key came from.
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Layer returns the configuration layer that set key.
+func (c *Config) Layer(key string) int {
+	return c.layers[key]
+}
+
+// SchemaPath returns the JSON schema file selected with -a: a schema name
+// from the config file, or a file path.
+func (c *Config) SchemaPath() string {
+	if path, ok := c.Schemas[c.String("schema")]; ok {
+		return path
+	}
+	return c.Path("schema")
+}
+
+// Print writes the effective configuration with the source of each value.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range settings {
+		fmt.Fprintf(tw, "%s\t= %s\t# %s\n", s.Key, formatSetting(c.values[s.Key]), c.sources[s.Key])
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Schemas)) {
+		fmt.Fprintf(tw, "schemas.%s\t= %s\t\n", name, strconv.Quote(c.Schemas[name]))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.profiles)) {
+		fmt.Fprintf(tw, "profiles.%s\t\t# %s\n", name, c.profiles[name][profileSourceKey])
+	}
+	return tw.Flush()
+}
+
+func formatSetting(v any) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// coerceSetting converts a value from a config file, the environment, or a
+// flag to the type of the setting.
+func coerceSetting(s setting, value any) (any, error) {
+	switch s.Kind {
+	case kindBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("expected true or false, got %q", v)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("expected true or false, got %v", value)
+
+	case kindInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		case string:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("expected an integer, got %q", v)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("expected an integer, got %v", value)
+
+	default:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("expected a string, got %v", value)
+	}
+}
+
+// validateKeys checks that every key of a profile is a known setting.
+func validateKeys(table map[string]any) error {
+	for key := range table {
+		if _, ok := lookupSetting(key); !ok || key == "profile" {
+			return fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return nil
+}
+
+// checkUserOnly returns an error if a project config file sets one of the
+// userOnlyKeys.
+func checkUserOnly(table map[string]any, layer int) error {
+	if layer != layerProject {
+		return nil
+	}
+	for _, key := range userOnlyKeys {
+		if _, ok := table[key]; ok {
+			return fmt.Errorf("%s can't be set in a project config file (use the user config, the environment, or a flag)", key)
+		}
+	}
+	return nil
+}
+
+func lookupSetting(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+func lookupSettingByFlag(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.Flag == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// resolvePath resolves a relative path against dir, and a path starting
+// with ~/ against home. Empty paths and paths from flags or the environment
+// (empty dir) are returned unchanged.
+func resolvePath(dir, home, path string) string {
+	if path == "" || dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	if strings.HasPrefix(path, "~/") && home != "" {
+		return filepath.Join(home, path[2:])
+	}
+	return filepath.Join(dir, path)
+}
+
+// userConfigPath returns the path of the user configuration file:
+// $XDG_CONFIG_HOME/ocr/config.toml, or ~/.config/ocr/config.toml, or an
+// empty string if there is neither.
+func userConfigPath(getenv func(string) string, home string) string {
+	dir := getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "ocr", "config.toml")
+}
+
+// findProjectConfig returns the path of the nearest .ocr.yaml in dir or one
+// of its parents up to stop, or an empty string if there is none.
+func findProjectConfig(dir, stop string) string {
+	if dir == "" {
+		return ""
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	if stop != "" {
+		if stop, err = filepath.Abs(stop); err != nil {
+			return ""
+		}
+	}
+
+	for {
+		path := filepath.Join(dir, projectConfigName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || dir == stop {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// runConfig implements "ocr config show": it prints the effective
+// configuration, with the source of every value, for the given options.
+func (a *App) runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("usage: %s config show [options]", a.name())
+	}
+
+	fs := a.flagSet("config show")
+	defineSettingFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(a.Stderr, `Usage: %s config show [options]
+
+Prints the effective configuration and the source of each value. Options are
+the same as for processing documents and take precedence as they would there.
+
+Options:
+`, a.name())
+		fs.PrintDefaults()
+	}
+	if err := parse(fs, args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := a.loadConfig(fs)
+	if err != nil {
+		return err
+	}
+
+	return cfg.Print(a.Stdout)
+}
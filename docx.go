@@ -0,0 +1,374 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Layout of the Word document, in twentieths of a point (twips) and English
+// Metric Units (EMU) for images.
+const (
+	docxPageWidth    = 11906 // A4
+	docxPageHeight   = 16838
+	docxMargin       = 1440 // 1 inch
+	emuPerInch       = 914400
+	twipsPerInch     = 1440
+	docxDefaultDPI   = 96
+	docxMaxImageSize = float64(docxPageWidth-2*docxMargin) / twipsPerInch // inches
+)
+
+const (
+	docxMainNS    = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	docxRelNS     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	docxPackageNS = "http://schemas.openxmlformats.org/package/2006/relationships"
+)
+
+// docxBulletNum is the numbering of bulleted lists. Each ordered list gets
+// its own numbering, so that it starts at 1.
+const docxBulletNum = 1
+
+// docxWriter builds the main part of a Word document.
+type docxWriter struct {
+	body strings.Builder
+	// media are the embedded images, named word/media/image<n>.<ext>.
+	media []formatFile
+	// orderedLists is the number of ordered lists, numbered after the
+	// bullets.
+	orderedLists int
+	drawings     int
+}
+
+// writeDOCX writes the OCR result as a Word document: headings, lists,
+// emphasis, tables, and the extracted images, with a page break between the
+// pages of the source. Display math is kept as LaTeX in a paragraph of its
+// own, and image descriptions from -m become captions.
+func writeDOCX(src formatSource) ([]byte, error) {
+	w := &docxWriter{}
+	for i, page := range src.Resp.Pages {
+		if i > 0 {
+			w.body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+		}
+		w.writePage(page)
+	}
+	fmt.Fprintf(&w.body, `<w:sectPr><w:pgSz w:w="%d" w:h="%d"/><w:pgMar w:top="%d" w:right="%d" w:bottom="%d" w:left="%d" w:header="720" w:footer="720" w:gutter="0"/></w:sectPr>`,
+		docxPageWidth, docxPageHeight, docxMargin, docxMargin, docxMargin, docxMargin)
+
+	title := strings.TrimSuffix(filepath.Base(src.Path), filepath.Ext(src.Path))
+	files := []formatFile{
+		{Name: "[Content_Types].xml", Data: []byte(docxContentTypes(w.media))},
+		{Name: "_rels/.rels", Data: []byte(xmlDeclaration + `<Relationships xmlns="` + docxPackageNS + `">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+			`</Relationships>`)},
+		{Name: "docProps/core.xml", Data: []byte(xmlDeclaration +
+			`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+			`<dc:title>` + html.EscapeString(title) + `</dc:title><dc:creator>ocr</dc:creator>` +
+			`<dcterms:created xsi:type="dcterms:W3CDTF">` + src.Time.UTC().Format("2006-01-02T15:04:05Z") + `</dcterms:created>` +
+			`</cp:coreProperties>`)},
+		{Name: "word/document.xml", Data: []byte(xmlDeclaration + `<w:document xmlns:w="` + docxMainNS + `" xmlns:r="` + docxRelNS + `"` +
+			` xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"` +
+			` xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"` +
+			` xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture">` +
+			`<w:body>` + w.body.String() + `</w:body></w:document>`)},
+		{Name: "word/_rels/document.xml.rels", Data: []byte(w.relationships())},
+		{Name: "word/styles.xml", Data: []byte(docxStyles)},
+		{Name: "word/numbering.xml", Data: []byte(w.numbering())},
+	}
+	files = append(files, w.media...)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: src.Time})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlDeclaration starts the XML parts of OOXML and EPUB packages.
+const xmlDeclaration = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+// writePage writes the blocks of a page.
+func (w *docxWriter) writePage(page Page) {
+	images := make(map[string]Image, len(page.Images))
+	for _, img := range page.Images {
+		images[img.ID] = img
+	}
+
+	for _, block := range markdownBlocks(page.Markdown) {
+		switch block.Kind {
+		case blockHeading:
+			w.paragraph(fmt.Sprintf("Heading%d", block.Level), "", inlineSpans(block.Text))
+		case blockParagraph:
+			w.paragraph("", "", inlineSpans(block.Text))
+		case blockList:
+			orderedNum := 0
+			for _, item := range block.Items {
+				num := docxBulletNum
+				if item.Ordered {
+					if orderedNum == 0 {
+						w.orderedLists++
+						orderedNum = docxBulletNum + w.orderedLists
+					}
+					num = orderedNum
+				}
+				numPr := fmt.Sprintf(`<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, min(item.Depth, 8), num)
+				w.paragraph("ListParagraph", numPr, inlineSpans(item.Text))
+			}
+		case blockTable:
+			w.table(block.Table)
+		case blockCode:
+			for _, line := range block.Lines {
+				w.paragraph("Code", "", []inlineSpan{{Text: line}})
+			}
+		case blockMath:
+			// Word can't show LaTeX, so it is kept as text.
+			latex := strings.TrimSpace(strings.Join(block.Lines, "\n"))
+			latex = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(latex, "$$"), "$$"))
+			for _, line := range strings.Split(latex, "\n") {
+				w.paragraph("Equation", "", []inlineSpan{{Text: line}})
+			}
+		case blockImage:
+			if img, ok := images[block.ImageID]; ok {
+				w.image(img, page.Dimensions)
+			}
+		}
+	}
+}
+
+// paragraph writes a paragraph with the given style and extra properties.
+func (w *docxWriter) paragraph(style, props string, spans []inlineSpan) {
+	w.body.WriteString("<w:p>")
+	if style != "" || props != "" {
+		w.body.WriteString("<w:pPr>")
+		if style != "" {
+			fmt.Fprintf(&w.body, `<w:pStyle w:val="%s"/>`, style)
+		}
+		w.body.WriteString(props + "</w:pPr>")
+	}
+	for _, span := range spans {
+		w.run(span)
+	}
+	w.body.WriteString("</w:p>")
+}
+
+// run writes a run of text.
+func (w *docxWriter) run(span inlineSpan) {
+	w.body.WriteString("<w:r>")
+	if span.Bold || span.Italic || span.Code {
+		w.body.WriteString("<w:rPr>")
+		if span.Code {
+			w.body.WriteString(`<w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/>`)
+		}
+		if span.Bold {
+			w.body.WriteString("<w:b/>")
+		}
+		if span.Italic {
+			w.body.WriteString("<w:i/>")
+		}
+		w.body.WriteString("</w:rPr>")
+	}
+	fmt.Fprintf(&w.body, `<w:t xml:space="preserve">%s</w:t></w:r>`, docxText(span.Text))
+}
+
+// table writes a table, with its header row repeated on each page.
+func (w *docxWriter) table(t Table) {
+	rows := t.Rows
+	if t.HeaderRows > 0 {
+		rows = append([][]string{t.Header}, rows...)
+	}
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	if cols == 0 {
+		return
+	}
+
+	w.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="0" w:type="auto"/></w:tblPr><w:tblGrid>`)
+	for range cols {
+		fmt.Fprintf(&w.body, `<w:gridCol w:w="%d"/>`, (docxPageWidth-2*docxMargin)/cols)
+	}
+	w.body.WriteString("</w:tblGrid>")
+	for i, row := range rows {
+		header := i == 0 && t.HeaderRows > 0
+		w.body.WriteString("<w:tr>")
+		if header {
+			w.body.WriteString("<w:trPr><w:tblHeader/></w:trPr>")
+		}
+		for _, cell := range padRow(slices.Clone(row), cols) {
+			w.body.WriteString(`<w:tc><w:tcPr><w:tcW w:w="0" w:type="auto"/></w:tcPr>`)
+			w.paragraph("", "", []inlineSpan{{Text: cell, Bold: header}})
+			w.body.WriteString("</w:tc>")
+		}
+		w.body.WriteString("</w:tr>")
+	}
+	w.body.WriteString("</w:tbl>")
+}
+
+// image embeds an extracted image at its size on the page, followed by its
+// description as a caption. Images without data only get the caption.
+func (w *docxWriter) image(img Image, dims *PageDimensions) {
+	data := decodeImageData(img)
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		width, height := float64(config.Width)/docxDefaultDPI, float64(config.Height)/docxDefaultDPI
+		if bw, bh := img.BottomRightX-img.TopLeftX, img.BottomRightY-img.TopLeftY; dims != nil && dims.DPI > 0 && bw > 0 && bh > 0 {
+			width, height = float64(bw)/float64(dims.DPI), float64(bh)/float64(dims.DPI)
+		}
+		if width > docxMaxImageSize {
+			width, height = docxMaxImageSize, height*docxMaxImageSize/width
+		}
+		cx, cy := int(width*emuPerInch), int(height*emuPerInch)
+
+		w.drawings++
+		name := fmt.Sprintf("image%d.%s", w.drawings, format)
+		w.media = append(w.media, formatFile{Name: "word/media/" + name, Data: data})
+		rel := fmt.Sprintf("rIdImage%d", w.drawings)
+
+		w.body.WriteString(`<w:p><w:pPr><w:keepNext/><w:jc w:val="center"/></w:pPr><w:r><w:drawing>`)
+		fmt.Fprintf(&w.body, `<wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent cx="%d" cy="%d"/>`, cx, cy)
+		fmt.Fprintf(&w.body, `<wp:docPr id="%d" name="%s"/>`, w.drawings, html.EscapeString(img.ID))
+		w.body.WriteString(`<wp:cNvGraphicFramePr><a:graphicFrameLocks noChangeAspect="1"/></wp:cNvGraphicFramePr>`)
+		w.body.WriteString(`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:pic>`)
+		fmt.Fprintf(&w.body, `<pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`, w.drawings, name)
+		fmt.Fprintf(&w.body, `<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`, rel)
+		fmt.Fprintf(&w.body, `<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`, cx, cy)
+		w.body.WriteString(`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>`)
+	}
+
+	if description := imageDescription(img); description != "" {
+		w.paragraph("Caption", "", []inlineSpan{{Text: description}})
+	}
+}
+
+// imageDescription returns the description of an image from its
+// annotation, as requested with -m.
+func imageDescription(img Image) string {
+	annotation, _ := decodeAnnotation(img.ImageAnnotation).(map[string]any)
+	description, _ := annotation["description"].(string)
+	return strings.TrimSpace(description)
+}
+
+// relationships returns the relationships of the main part.
+func (w *docxWriter) relationships() string {
+	var b strings.Builder
+	b.WriteString(xmlDeclaration + `<Relationships xmlns="` + docxPackageNS + `">`)
+	b.WriteString(`<Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`)
+	b.WriteString(`<Relationship Id="rIdNumbering" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>`)
+	for i, f := range w.media {
+		fmt.Fprintf(&b, `<Relationship Id="rIdImage%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/%s"/>`,
+			i+1, path.Base(f.Name))
+	}
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+// numbering returns the numbering part: bullets, and a numbering per
+// ordered list.
+func (w *docxWriter) numbering() string {
+	var b strings.Builder
+	b.WriteString(xmlDeclaration + `<w:numbering xmlns:w="` + docxMainNS + `">`)
+	bullets := []string{"•", "◦", "▪"}
+	for abstract, ordered := range []bool{false, true} {
+		fmt.Fprintf(&b, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, abstract)
+		for lvl := range 9 {
+			format, text := "bullet", bullets[lvl%len(bullets)]
+			if ordered {
+				format, text = "decimal", fmt.Sprintf("%%%d.", lvl+1)
+			}
+			fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/>`+
+				`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, lvl, format, text, 720*(lvl+1))
+		}
+		b.WriteString("</w:abstractNum>")
+	}
+	fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="0"/></w:num>`, docxBulletNum)
+	for i := range w.orderedLists {
+		fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="1"/>`, docxBulletNum+1+i)
+		for lvl := range 9 {
+			fmt.Fprintf(&b, `<w:lvlOverride w:ilvl="%d"><w:startOverride w:val="1"/></w:lvlOverride>`, lvl)
+		}
+		b.WriteString("</w:num>")
+	}
+	b.WriteString("</w:numbering>")
+	return b.String()
+}
+
+// docxContentTypes returns the content types of the package.
+func docxContentTypes(media []formatFile) string {
+	var b strings.Builder
+	b.WriteString(xmlDeclaration + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	seen := map[string]bool{}
+	for _, f := range media {
+		ext := strings.TrimPrefix(filepath.Ext(f.Name), ".")
+		if !seen[ext] {
+			seen[ext] = true
+			fmt.Fprintf(&b, `<Default Extension="%s" ContentType="image/%s"/>`, ext, ext)
+		}
+	}
+	for _, part := range [][2]string{
+		{"/word/document.xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"},
+		{"/word/styles.xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"},
+		{"/word/numbering.xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"},
+		{"/docProps/core.xml", "application/vnd.openxmlformats-package.core-properties+xml"},
+	} {
+		fmt.Fprintf(&b, `<Override PartName="%s" ContentType="%s"/>`, part[0], part[1])
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+// docxText escapes text for a w:t element, dropping the control characters
+// XML doesn't allow.
+func docxText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	return html.EscapeString(s)
+}
+
+// docxStyles defines the styles the document uses.
+var docxStyles = xmlDeclaration + `<w:styles xmlns:w="` + docxMainNS + `">` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:eastAsia="Calibri" w:cs="Calibri"/><w:sz w:val="22"/><w:szCs w:val="22"/><w:lang w:val="en-US"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="259" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>` +
+	docxHeadingStyle(1, 32) + docxHeadingStyle(2, 28) + docxHeadingStyle(3, 26) +
+	docxHeadingStyle(4, 24) + docxHeadingStyle(5, 22) + docxHeadingStyle(6, 22) +
+	`<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:qFormat/><w:pPr><w:spacing w:after="0"/><w:contextualSpacing/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Caption"><w:name w:val="caption"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/><w:pPr><w:jc w:val="center"/></w:pPr><w:rPr><w:i/><w:color w:val="595959"/><w:sz w:val="18"/><w:szCs w:val="18"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:qFormat/><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr><w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/><w:szCs w:val="20"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Equation"><w:name w:val="Equation"/><w:basedOn w:val="Code"/><w:qFormat/><w:pPr><w:jc w:val="center"/></w:pPr></w:style>` +
+	`<w:style w:type="table" w:default="1" w:styleId="TableNormal"><w:name w:val="Normal Table"/><w:tblPr><w:tblInd w:w="0" w:type="dxa"/><w:tblCellMar><w:top w:w="0" w:type="dxa"/><w:left w:w="108" w:type="dxa"/><w:bottom w:w="0" w:type="dxa"/><w:right w:w="108" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>` +
+	`<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:basedOn w:val="TableNormal"/><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr><w:tblPr><w:tblBorders>` +
+	`<w:top w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:left w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:bottom w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+	`<w:right w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:insideH w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+	`</w:tblBorders></w:tblPr></w:style>` +
+	`</w:styles>`
+
+// docxHeadingStyle defines the style of a heading level, with the font size
+// in half points.
+func docxHeadingStyle(level, size int) string {
+	return fmt.Sprintf(`<w:style w:type="paragraph" w:styleId="Heading%d"><w:name w:val="heading %d"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>`+
+		`<w:pPr><w:keepNext/><w:keepLines/><w:spacing w:before="240" w:after="80"/><w:outlineLvl w:val="%d"/></w:pPr>`+
+		`<w:rPr><w:b/><w:sz w:val="%d"/><w:szCs w:val="%d"/></w:rPr></w:style>`, level, level, level-1, size, size)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readZip returns the entries of a zip file in order, checking that the XML
+// ones are well-formed.
+func readZip(t *testing.T, data []byte) ([]string, map[string]string) {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	var names []string
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		names = append(names, f.Name)
+		files[f.Name] = string(content)
+
+		if strings.HasSuffix(f.Name, ".xml") || strings.HasSuffix(f.Name, ".rels") ||
+			strings.HasSuffix(f.Name, ".xhtml") || strings.HasSuffix(f.Name, ".opf") {
+			dec := xml.NewDecoder(bytes.NewReader(content))
+			for {
+				if _, err := dec.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s is not well-formed: %v", f.Name, err)
+				}
+			}
+		}
+	}
+	return names, files
+}
+
+func TestWriteDOCX(t *testing.T) {
+	figure := testImage(t, 4, 2, encodePNG)
+	resp := &OCRResponse{Pages: []Page{
+		{
+			Index: 0,
+			Markdown: "# Contract & Terms\n\nThe **parties** agree *in good faith*.\n\n- First\n- Second\n  1. Nested\n\n" +
+				"1. One\n2. Two\n\n| Name | Amount |\n|---|---|\n| Fee | 10 <b>€</b> |\n\n$$\nx = \\frac{a}{b}\n$$",
+		},
+		{
+			Index:      1,
+			Markdown:   "![img-0.png](img-0.png)\n\n```\nif x < 1 {\n```",
+			Dimensions: &PageDimensions{DPI: 200, Width: 1700, Height: 2200},
+			Images: []Image{{
+				ID: "img-0.png", TopLeftX: 0, TopLeftY: 0, BottomRightX: 400, BottomRightY: 200,
+				ImageBase64:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(figure),
+				ImageAnnotation: `{"description": "A signature", "type": "photo"}`,
+			}},
+		},
+	}}
+
+	data, err := writeDOCX(formatSource{Path: "in/contract.pdf", Resp: resp})
+	if err != nil {
+		t.Fatalf("writeDOCX failed: %v", err)
+	}
+	names, files := readZip(t, data)
+	if names[0] != "[Content_Types].xml" {
+		t.Errorf("expected the content types first, got %q", names[0])
+	}
+	for _, name := range []string{"_rels/.rels", "word/document.xml", "word/_rels/document.xml.rels", "word/styles.xml", "word/numbering.xml", "docProps/core.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	if files["word/media/image1.png"] != string(figure) {
+		t.Error("expected the image in word/media")
+	}
+	if !strings.Contains(files["[Content_Types].xml"], `<Default Extension="png" ContentType="image/png"/>`) {
+		t.Error("expected a content type for the image")
+	}
+	if !strings.Contains(files["word/_rels/document.xml.rels"], `Id="rIdImage1"`) || !strings.Contains(files["docProps/core.xml"], "<dc:title>contract</dc:title>") {
+		t.Error("expected the image relationship and the title")
+	}
+
+	doc := files["word/document.xml"]
+	for _, want := range []string{
+		`<w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t xml:space="preserve">Contract &amp; Terms</w:t>`,
+		`<w:r><w:rPr><w:b/></w:rPr><w:t xml:space="preserve">parties</w:t></w:r>`,
+		`<w:r><w:rPr><w:i/></w:rPr><w:t xml:space="preserve">in good faith</w:t></w:r>`,
+		`<w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">First</w:t>`,
+		`<w:numPr><w:ilvl w:val="1"/><w:numId w:val="2"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">Nested</w:t>`,
+		`<w:numPr><w:ilvl w:val="0"/><w:numId w:val="3"/></w:numPr></w:pPr><w:r><w:t xml:space="preserve">One</w:t>`,
+		`<w:trPr><w:tblHeader/></w:trPr>`,
+		`<w:t xml:space="preserve">10 €</w:t>`,
+		`<w:pStyle w:val="Equation"/></w:pPr><w:r><w:t xml:space="preserve">x = \frac{a}{b}</w:t>`,
+		`<w:br w:type="page"/>`,
+		`<wp:extent cx="1828800" cy="914400"/>`,
+		`<a:blip r:embed="rIdImage1"/>`,
+		`<w:pStyle w:val="Caption"/></w:pPr><w:r><w:t xml:space="preserve">A signature</w:t>`,
+		`<w:pStyle w:val="Code"/></w:pPr><w:r><w:t xml:space="preserve">if x &lt; 1 {</w:t>`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("expected %s in document.xml", want)
+		}
+	}
+	if strings.Index(doc, "Contract") > strings.Index(doc, `w:type="page"`) || strings.Index(doc, `w:type="page"`) > strings.Index(doc, "<w:drawing>") {
+		t.Error("expected a page break between the pages")
+	}
+	if n := strings.Count(files["word/numbering.xml"], "<w:num "); n != 3 {
+		t.Errorf("expected a numbering for bullets and for each ordered list, got %d", n)
+	}
+}
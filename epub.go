@@ -0,0 +1,356 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// epubMediaTypes are the image types EPUB readers must support.
+var epubMediaTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true}
+
+// epubChapter is a chapter of an EPUB: a top-level heading and the blocks up
+// to the next one.
+type epubChapter struct {
+	Title string
+	// Level is the level of the top-level headings.
+	Level  int
+	Blocks []mdBlock
+}
+
+// epubImage is an image embedded in an EPUB.
+type epubImage struct {
+	Href, MediaType, Description string
+	Data                         []byte
+}
+
+// writeEPUB writes the OCR result as an EPUB 3 book, with a chapter for each
+// top-level heading of the Markdown, a table of contents, and the extracted
+// images. The title and author are taken from -title and -author, or else
+// from the document annotation.
+func writeEPUB(src formatSource) ([]byte, error) {
+	title, author, language := documentMetadata(src.Resp.DocumentAnnotation)
+	if src.Title != "" {
+		title = src.Title
+	}
+	if src.Author != "" {
+		author = src.Author
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(src.Path), filepath.Ext(src.Path))
+	}
+	if language == "" {
+		language = "en"
+	}
+
+	// Images are named as extractImages names them.
+	images := map[string]epubImage{}
+	var imageOrder []string
+	imgIndex := 0
+	for _, page := range src.Resp.Pages {
+		for _, img := range page.Images {
+			name := imageFileName(img, page.Index, imgIndex)
+			imgIndex++
+			data := decodeImageData(img)
+			mediaType := http.DetectContentType(data)
+			if _, ok := images[img.ID]; ok || len(data) == 0 || !epubMediaTypes[mediaType] {
+				continue
+			}
+			images[img.ID] = epubImage{Href: "images/" + name, MediaType: mediaType, Description: imageDescription(img), Data: data}
+			imageOrder = append(imageOrder, img.ID)
+		}
+	}
+
+	chapters := epubChapters(markdownBlocks(src.Markdown))
+	if len(chapters) == 0 {
+		chapters = []epubChapter{{}}
+	}
+
+	var files []formatFile
+	var nav strings.Builder
+	for i, chapter := range chapters {
+		name := fmt.Sprintf("chapter_%d.xhtml", i+1)
+		chapterTitle := chapter.Title
+		if chapterTitle == "" {
+			chapterTitle = title
+		}
+
+		var body strings.Builder
+		var sections []string
+		for _, block := range chapter.Blocks {
+			// Headings one level down are listed under the chapter.
+			section := 0
+			if block.Kind == blockHeading && block.Level == chapter.Level+1 {
+				sections = append(sections, fmt.Sprintf(`<li><a href="%s#heading-%d">%s</a></li>`,
+					name, len(sections)+1, html.EscapeString(inlineText(block.Text))))
+				section = len(sections)
+			}
+			writeBlockHTML(&body, block, images, section)
+		}
+		files = append(files, formatFile{Name: "OEBPS/" + name, Data: []byte(epubPage(chapterTitle, language,
+			`<section epub:type="chapter">`+"\n"+body.String()+"</section>\n"))})
+
+		fmt.Fprintf(&nav, `<li><a href="%s">%s</a>`, name, html.EscapeString(chapterTitle))
+		if len(sections) > 0 {
+			nav.WriteString("\n<ol>\n" + strings.Join(sections, "\n") + "\n</ol>\n")
+		}
+		nav.WriteString("</li>\n")
+	}
+
+	files = append(files,
+		formatFile{Name: "OEBPS/nav.xhtml", Data: []byte(epubPage(title, language,
+			`<nav epub:type="toc" id="toc">`+"\n<h1>Contents</h1>\n<ol>\n"+nav.String()+"</ol>\n</nav>\n"))},
+		formatFile{Name: "OEBPS/style.css", Data: []byte(epubStyle)},
+	)
+	for _, id := range imageOrder {
+		files = append(files, formatFile{Name: "OEBPS/" + images[id].Href, Data: images[id].Data})
+	}
+
+	var opf strings.Builder
+	sum := sha256.Sum256(src.Data)
+	opf.WriteString(xmlDeclaration)
+	fmt.Fprintf(&opf, `<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="%s">`+"\n", html.EscapeString(language))
+	opf.WriteString(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	// The identifier is derived from the input, so it is the same each time
+	// the document is converted.
+	fmt.Fprintf(&opf, "<dc:identifier id=\"book-id\">urn:uuid:%x-%x-%x-%x-%x</dc:identifier>\n",
+		sum[0:4], sum[4:6], append([]byte{0x50 | sum[6]&0x0f}, sum[7]), append([]byte{0x80 | sum[8]&0x3f}, sum[9]), sum[10:16])
+	fmt.Fprintf(&opf, "<dc:title>%s</dc:title>\n<dc:language>%s</dc:language>\n", html.EscapeString(title), html.EscapeString(language))
+	if author != "" {
+		fmt.Fprintf(&opf, "<dc:creator>%s</dc:creator>\n", html.EscapeString(author))
+	}
+	fmt.Fprintf(&opf, "<meta property=\"dcterms:modified\">%s</meta>\n</metadata>\n<manifest>\n", src.Time.UTC().Format("2006-01-02T15:04:05Z"))
+	opf.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	opf.WriteString(`<item id="style" href="style.css" media-type="text/css"/>` + "\n")
+	for i := range chapters {
+		fmt.Fprintf(&opf, "<item id=\"chapter-%d\" href=\"chapter_%d.xhtml\" media-type=\"application/xhtml+xml\"/>\n", i+1, i+1)
+	}
+	for i, id := range imageOrder {
+		fmt.Fprintf(&opf, "<item id=\"image-%d\" href=\"%s\" media-type=\"%s\"/>\n", i+1, html.EscapeString(images[id].Href), images[id].MediaType)
+	}
+	opf.WriteString("</manifest>\n<spine>\n")
+	for i := range chapters {
+		fmt.Fprintf(&opf, "<itemref idref=\"chapter-%d\"/>\n", i+1)
+	}
+	opf.WriteString("</spine>\n</package>\n")
+
+	files = append([]formatFile{
+		{Name: "META-INF/container.xml", Data: []byte(xmlDeclaration +
+			`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">` +
+			`<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>` + "\n")},
+		{Name: "OEBPS/content.opf", Data: []byte(opf.String())},
+	}, files...)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// The mimetype comes first, stored without compression or extra fields,
+	// so readers can identify the file by its first bytes.
+	mimetype := []byte("application/epub+zip")
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name: "mimetype", Method: zip.Store, CRC32: crc32.ChecksumIEEE(mimetype),
+		CompressedSize64: uint64(len(mimetype)), UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.Write(mimetype)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: src.Time})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// epubChapters splits blocks into chapters at the top-level headings, the
+// highest level of heading the document uses. Blocks before the first
+// heading form a chapter without a title.
+func epubChapters(blocks []mdBlock) []epubChapter {
+	top := 0
+	for _, b := range blocks {
+		if b.Kind == blockHeading && (top == 0 || b.Level < top) {
+			top = b.Level
+		}
+	}
+
+	var chapters []epubChapter
+	for _, b := range blocks {
+		if b.Kind == blockHeading && b.Level == top {
+			chapters = append(chapters, epubChapter{Title: inlineText(b.Text), Level: top})
+		} else if len(chapters) == 0 {
+			chapters = append(chapters, epubChapter{Level: top})
+		}
+		last := &chapters[len(chapters)-1]
+		last.Blocks = append(last.Blocks, b)
+	}
+	return chapters
+}
+
+// documentMetadata returns the title, author, and language from a document
+// annotation that has such fields. Several authors are joined with commas.
+func documentMetadata(annotation any) (title, author, language string) {
+	fields, _ := decodeAnnotation(annotation).(map[string]any)
+	str := func(keys ...string) string {
+		for _, key := range keys {
+			switch v := fields[key].(type) {
+			case string:
+				return strings.TrimSpace(v)
+			case []any:
+				var parts []string
+				for _, item := range v {
+					if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+						parts = append(parts, strings.TrimSpace(s))
+					}
+				}
+				return strings.Join(parts, ", ")
+			}
+		}
+		return ""
+	}
+	return str("title"), str("author", "authors"), str("language")
+}
+
+// epubPage returns an XHTML document for the book.
+func epubPage(title, language, body string) string {
+	lang := html.EscapeString(language)
+	return xmlDeclaration + "<!DOCTYPE html>\n" +
+		`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + lang + `" lang="` + lang + `">` + "\n" +
+		"<head>\n<title>" + html.EscapeString(title) + "</title>\n" +
+		`<link rel="stylesheet" type="text/css" href="style.css"/>` + "\n</head>\n<body>\n" + body + "</body>\n</html>\n"
+}
+
+// writeBlockHTML writes a block as XHTML. A heading that is a section of its
+// chapter is given the id heading-<section>, for the table of contents.
+func writeBlockHTML(b *strings.Builder, block mdBlock, images map[string]epubImage, section int) {
+	switch block.Kind {
+	case blockHeading:
+		id := ""
+		if section > 0 {
+			id = fmt.Sprintf(` id="heading-%d"`, section)
+		}
+		fmt.Fprintf(b, "<h%d%s>%s</h%d>\n", block.Level, id, spansHTML(inlineSpans(block.Text)), block.Level)
+	case blockParagraph:
+		fmt.Fprintf(b, "<p>%s</p>\n", spansHTML(inlineSpans(block.Text)))
+	case blockList:
+		writeListHTML(b, block.Items)
+	case blockTable:
+		writeTableHTML(b, block.Table)
+	case blockCode:
+		fmt.Fprintf(b, "<pre><code>%s</code></pre>\n", html.EscapeString(strings.Join(block.Lines, "\n")))
+	case blockMath:
+		// EPUB readers can't show LaTeX, so it is kept as text.
+		fmt.Fprintf(b, "<pre class=\"math\">%s</pre>\n", html.EscapeString(strings.Join(block.Lines, "\n")))
+	case blockImage:
+		img, ok := images[block.ImageID]
+		if !ok {
+			return
+		}
+		alt := block.Alt
+		if alt == block.ImageID {
+			alt = img.Description
+		}
+		fmt.Fprintf(b, "<figure>\n<img src=\"%s\" alt=\"%s\"/>\n", html.EscapeString(img.Href), html.EscapeString(alt))
+		if img.Description != "" {
+			fmt.Fprintf(b, "<figcaption>%s</figcaption>\n", html.EscapeString(img.Description))
+		}
+		b.WriteString("</figure>\n")
+	}
+}
+
+// writeListHTML writes list items as nested ul and ol elements.
+func writeListHTML(b *strings.Builder, items []listItem) {
+	var open []string
+	for _, item := range items {
+		tag := "ul"
+		if item.Ordered {
+			tag = "ol"
+		}
+		// A list can't skip a level of nesting.
+		depth := min(item.Depth, len(open))
+		for len(open) > depth+1 {
+			b.WriteString("</li></" + open[len(open)-1] + ">\n")
+			open = open[:len(open)-1]
+		}
+		if len(open) == depth+1 {
+			if open[depth] == tag {
+				b.WriteString("</li>\n")
+			} else {
+				b.WriteString("</li></" + open[depth] + ">\n")
+				open = open[:depth]
+			}
+		}
+		if len(open) == depth {
+			b.WriteString("<" + tag + ">\n")
+			open = append(open, tag)
+		}
+		b.WriteString("<li>" + spansHTML(inlineSpans(item.Text)))
+	}
+	for len(open) > 0 {
+		b.WriteString("</li></" + open[len(open)-1] + ">\n")
+		open = open[:len(open)-1]
+	}
+}
+
+// writeTableHTML writes a table, with its header row in thead.
+func writeTableHTML(b *strings.Builder, t Table) {
+	b.WriteString("<table>\n")
+	if t.HeaderRows > 0 {
+		b.WriteString("<thead><tr>")
+		for _, cell := range t.Header {
+			fmt.Fprintf(b, "<th>%s</th>", html.EscapeString(cell))
+		}
+		b.WriteString("</tr></thead>\n")
+	}
+	b.WriteString("<tbody>\n")
+	for _, row := range t.Rows {
+		b.WriteString("<tr>")
+		for _, cell := range row {
+			fmt.Fprintf(b, "<td>%s</td>", html.EscapeString(cell))
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</tbody>\n</table>\n")
+}
+
+// spansHTML returns inline spans as XHTML.
+func spansHTML(spans []inlineSpan) string {
+	var b strings.Builder
+	for _, span := range spans {
+		s := html.EscapeString(span.Text)
+		if span.Code {
+			s = "<code>" + s + "</code>"
+		}
+		if span.Italic {
+			s = "<em>" + s + "</em>"
+		}
+		if span.Bold {
+			s = "<strong>" + s + "</strong>"
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// epubStyle is the style sheet of the book.
+const epubStyle = `body { font-family: serif; line-height: 1.4; }
+h1, h2, h3, h4, h5, h6 { font-family: sans-serif; page-break-after: avoid; }
+figure { margin: 1em 0; text-align: center; }
+figure img { max-width: 100%; }
+figcaption { font-size: 0.9em; font-style: italic; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #888; padding: 0.2em 0.4em; }
+pre { white-space: pre-wrap; }
+`
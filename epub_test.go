@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteEPUB(t *testing.T) {
+	figure := testImage(t, 4, 2, encodePNG)
+	resp := &OCRResponse{
+		DocumentAnnotation: `{"title": "An Old Book", "authors": ["A. Writer", "B. Author"]}`,
+		Pages: []Page{
+			{Index: 0, Markdown: "Printed in 1920.\n\n# Chapter One\n\nIt was *dark*.\n\n## The Storm\n\n![img-0.png](img-0.png)\n\n### Aftermath\n\nRain."},
+			{Index: 1, Markdown: "# Chapter Two & More\n\n- one\n  1. nested\n- two\n\n| A | B |\n|---|---|\n| 1 | 2 |"},
+		},
+	}
+	resp.Pages[0].Images = []Image{{
+		ID: "img-0.png", ImageBase64: "data:image/png;base64," + base64.StdEncoding.EncodeToString(figure),
+		ImageAnnotation: map[string]any{"description": "A ship at sea"},
+	}}
+	text, _ := extractText(resp, false)
+
+	data, err := writeEPUB(formatSource{Path: "scans/book.pdf", Data: []byte("scan"), Resp: resp, Markdown: text})
+	if err != nil {
+		t.Fatalf("writeEPUB failed: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store || len(first.Extra) != 0 ||
+		!bytes.Equal(data[30:58], []byte("mimetypeapplication/epub+zip")) {
+		t.Errorf("expected an uncompressed mimetype first, got %s (method %d)", first.Name, first.Method)
+	}
+
+	_, files := readZip(t, data)
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/style.css",
+		"OEBPS/chapter_1.xhtml", "OEBPS/chapter_2.xhtml", "OEBPS/chapter_3.xhtml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+	if files["OEBPS/images/page_0_img_0.png"] != string(figure) {
+		t.Error("expected the image as extractImages names it")
+	}
+
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{
+		`<dc:title>An Old Book</dc:title>`,
+		`<dc:creator>A. Writer, B. Author</dc:creator>`,
+		`<dc:language>en</dc:language>`,
+		`<meta property="dcterms:modified">`,
+		`properties="nav"`,
+		`<item id="image-1" href="images/page_0_img_0.png" media-type="image/png"/>`,
+		"<spine>\n<itemref idref=\"chapter-1\"/>\n<itemref idref=\"chapter-2\"/>\n<itemref idref=\"chapter-3\"/>\n</spine>",
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("expected %q in content.opf:\n%s", want, opf)
+		}
+	}
+
+	nav := files["OEBPS/nav.xhtml"]
+	for _, want := range []string{
+		`<li><a href="chapter_1.xhtml">An Old Book</a></li>`,
+		`<li><a href="chapter_2.xhtml">Chapter One</a>` + "\n<ol>\n" + `<li><a href="chapter_2.xhtml#heading-1">The Storm</a></li>`,
+		`<li><a href="chapter_3.xhtml">Chapter Two &amp; More</a></li>`,
+	} {
+		if !strings.Contains(nav, want) {
+			t.Errorf("expected %q in nav.xhtml:\n%s", want, nav)
+		}
+	}
+	if strings.Contains(nav, "Aftermath") {
+		t.Error("expected only one level of sections in the table of contents")
+	}
+
+	chapter := files["OEBPS/chapter_2.xhtml"]
+	for _, want := range []string{
+		"<h1>Chapter One</h1>", "<p>It was <em>dark</em>.</p>", `<h2 id="heading-1">The Storm</h2>`, "<h3>Aftermath</h3>",
+		`<img src="images/page_0_img_0.png" alt="A ship at sea"/>`, "<figcaption>A ship at sea</figcaption>",
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("expected %q in chapter_2.xhtml:\n%s", want, chapter)
+		}
+	}
+	chapter = files["OEBPS/chapter_3.xhtml"]
+	for _, want := range []string{
+		"<ul>\n<li>one<ol>\n<li>nested</li></ol>\n</li>\n<li>two</li></ul>",
+		"<thead><tr><th>A</th><th>B</th></tr></thead>",
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("expected %q in chapter_3.xhtml:\n%s", want, chapter)
+		}
+	}
+
+	// Flags take precedence over the annotation.
+	data, err = writeEPUB(formatSource{Path: "book.pdf", Resp: resp, Markdown: text, Title: "Given Title", Author: "Given Author"})
+	if err != nil {
+		t.Fatalf("writeEPUB failed: %v", err)
+	}
+	_, files = readZip(t, data)
+	if opf := files["OEBPS/content.opf"]; !strings.Contains(opf, "<dc:title>Given Title</dc:title>") || !strings.Contains(opf, "<dc:creator>Given Author</dc:creator>") {
+		t.Errorf("expected the title and author flags:\n%s", opf)
+	}
+}
+
+func TestEPUBChapters_NoTopLevelHeading(t *testing.T) {
+	chapters := epubChapters(markdownBlocks("Intro.\n\n## First\n\nText.\n\n### Detail\n\n## Second"))
+	if len(chapters) != 3 || chapters[0].Title != "" || chapters[1].Title != "First" || chapters[2].Title != "Second" {
+		t.Errorf("expected chapters split on the highest heading level, got %+v", chapters)
+	}
+}
+
+func TestProcessFile_EPUBTableLinks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OCRResponse{Pages: []Page{{Index: 0, Markdown: "# Totals\n\n| A | B |\n|---|---|\n| 1 | 2 |"}}})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: filepath.Join(dir, "scan.pdf"), Data: buildTestPDF(1, false), Dir: dir}
+	ro := runOptions{Formats: []string{"markdown", "epub"}, Tables: true, TableLinks: true}
+	if _, err := processFile(context.Background(), client, doc, ro, NewReporter(io.Discard, true, false)); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	md, err := os.ReadFile(filepath.Join(dir, "scan.md"))
+	if err != nil || !strings.Contains(string(md), "](tables/") {
+		t.Errorf("expected the table linked in the Markdown, got %q (%v)", md, err)
+	}
+
+	// The CSV files aren't in the EPUB, so its chapters keep the tables.
+	data, err := os.ReadFile(filepath.Join(dir, "scan.ocr.epub"))
+	if err != nil {
+		t.Fatalf("expected an EPUB: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chapters strings.Builder
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".xhtml") && !strings.HasSuffix(f.Name, "nav.xhtml") {
+			rc, _ := f.Open()
+			io.Copy(&chapters, rc)
+			rc.Close()
+		}
+	}
+	if !strings.Contains(chapters.String(), "<table>") || strings.Contains(chapters.String(), "tables/") {
+		t.Errorf("expected the table in the chapters without links, got:\n%s", chapters.String())
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxExpandDepth limits how deeply archives within archives are
+	// expanded.
+	maxExpandDepth = 4
+	// maxExpandedSize limits the total size of the members expanded from a
+	// container, nested containers included, as they are held in memory.
+	maxExpandedSize int64 = 2 << 30
+)
+
+var errExpandedSize = fmt.Errorf("members expand to more than %d GiB in total", maxExpandedSize>>30)
+
+// member is a file inside an archive or an email attachment. Name is a
+// cleaned, slash-separated path relative to the container.
+type member struct {
+	Name string
+	Data []byte
+}
+
+// isContainer reports whether a file with the given name and leading bytes
+// is an archive or email whose members are processed individually. Zip
+// based document formats such as DOCX are detected later, by
+// expandContainer.
+func isContainer(name string, head []byte) bool {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		return true
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return true
+	}
+	return strings.EqualFold(filepath.Ext(name), ".eml")
+}
+
+// isContainerFile reports whether the file at path is an archive or email,
+// reading only its first bytes.
+func isContainerFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return isContainer(path, head[:n])
+}
+
+// expandContainer returns the supported documents in the archive or email at
+// containerPath, with their paths inside it mirrored below a directory named
+// after it. Archives within it are expanded too. It also returns the members
+// that were skipped because they are not supported documents.
+func expandContainer(containerPath string, data []byte) ([]document, []string, error) {
+	base := filepath.Base(containerPath)
+	root := document{
+		Path: containerPath,
+		Dir:  filepath.Dir(containerPath),
+	}
+
+	var docs []document
+	var skipped []string
+	budget := maxExpandedSize
+	if err := expandMembers(root, containerStem(base), base, data, 0, &docs, &skipped, &budget); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", containerPath, err)
+	}
+	return docs, skipped, nil
+}
+
+// expandMembers appends the documents in the container to docs. subdir is
+// the output subdirectory for its members. The size of the members read is
+// taken from budget.
+func expandMembers(root document, subdir, name string, data []byte, depth int, docs *[]document, skipped *[]string, budget *int64) error {
+	members, ok, err := containerMembers(name, data, budget)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Not a container after all, e.g. a DOCX file: a single document.
+		*docs = append(*docs, document{Path: root.Path, Data: data, Dir: root.Dir, Subdir: path.Dir(subdir)})
+		return nil
+	}
+	uniqueMemberNames(members)
+
+	for _, m := range members {
+		memberPath := root.Path + "/" + m.Name
+
+		if depth+1 < maxExpandDepth && isContainer(m.Name, m.Data) {
+			nested := document{Path: memberPath, Dir: root.Dir}
+			nestedDir := path.Join(subdir, path.Dir(m.Name), containerStem(m.Name))
+			if err := expandMembers(nested, nestedDir, m.Name, m.Data, depth+1, docs, skipped, budget); err != nil {
+				return fmt.Errorf("%s: %w", m.Name, err)
+			}
+			continue
+		}
+
+		if detectMIMEType(m.Data) == "" {
+			*skipped = append(*skipped, memberPath)
+			continue
+		}
+
+		*docs = append(*docs, document{
+			Path:   memberPath,
+			Data:   m.Data,
+			Dir:    root.Dir,
+			Subdir: path.Join(subdir, path.Dir(m.Name)),
+		})
+	}
+	return nil
+}
+
+// uniqueMemberNames renames members whose outputs would collide: members in
+// the same directory with the same name up to the extension, such as two
+// attachments named scan.pdf. The second gets the suffix -2, and so on.
+func uniqueMemberNames(members []member) {
+	seen := make(map[string]bool)
+	for i, m := range members {
+		dir, stem := path.Dir(m.Name), containerStem(m.Name)
+		ext := strings.TrimPrefix(path.Base(m.Name), stem)
+		name := m.Name
+		for n := 2; seen[path.Join(dir, containerStem(name))]; n++ {
+			name = path.Join(dir, fmt.Sprintf("%s-%d%s", stem, n, ext))
+		}
+		seen[path.Join(dir, containerStem(name))] = true
+		members[i].Name = name
+	}
+}
+
+// containerStem returns the name of a container without its extension,
+// including both parts of ".tar.gz".
+func containerStem(name string) string {
+	name = path.Base(name)
+	if lower := strings.ToLower(name); strings.HasSuffix(lower, ".tar.gz") {
+		return name[:len(name)-len(".tar.gz")]
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// containerMembers returns the files in a zip, tar, or gzip-compressed
+// archive, or the attachments of an email. It reports false for zip based
+// document formats, which are not expanded.
+func containerMembers(name string, data []byte, budget *int64) ([]member, bool, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return zipMembers(data, budget)
+
+	case bytes.HasPrefix(data, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("reading gzip: %w", err)
+		}
+		inner, err := readMember(gz, budget)
+		if err != nil {
+			return nil, false, fmt.Errorf("reading gzip: %w", err)
+		}
+		if isTar(inner) {
+			members, err := tarMembers(inner, budget)
+			return members, true, err
+		}
+		// A single compressed file, such as scan.pdf.gz.
+		stem := strings.TrimSuffix(path.Base(name), path.Ext(name))
+		return []member{{Name: stem, Data: inner}}, true, nil
+
+	case isTar(data):
+		members, err := tarMembers(data, budget)
+		return members, true, err
+	}
+
+	members, err := emailAttachments(data, budget)
+	return members, true, err
+}
+
+func isTar(data []byte) bool {
+	return len(data) >= 262 && string(data[257:262]) == "ustar"
+}
+
+// readMember reads an archive member, up to one byte over the document size
+// limit so that oversized members fail validation instead of exhausting
+// memory, and takes its size from budget. It fails once the budget is
+// exceeded.
+func readMember(r io.Reader, budget *int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, min(maxDocumentSize+1, *budget+1)))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > *budget {
+		return nil, errExpandedSize
+	}
+	*budget -= int64(len(data))
+	return data, nil
+}
+
+// safeMemberName cleans the path of an archive member and rejects paths
+// that would escape the output directory ("zip slip").
+func safeMemberName(name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if !filepath.IsLocal(filepath.FromSlash(clean)) {
+		return "", fmt.Errorf("unsafe path %q in archive", name)
+	}
+	return clean, nil
+}
+
+func zipMembers(data []byte, budget *int64) ([]member, bool, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, false, fmt.Errorf("reading zip: %w", err)
+	}
+
+	// Office documents and EPUB are zip files too, but are documents in
+	// their own right.
+	if zipDocumentType(data) != "" {
+		return nil, false, nil
+	}
+
+	var members []member
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := safeMemberName(f.Name)
+		if err != nil {
+			return nil, false, err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, false, fmt.Errorf("reading %s: %w", f.Name, err)
+		}
+		content, err := readMember(rc, budget)
+		rc.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("reading %s: %w", f.Name, err)
+		}
+		members = append(members, member{Name: name, Data: content})
+	}
+	return members, true, nil
+}
+
+func tarMembers(data []byte, budget *int64) ([]member, error) {
+	var members []member
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar: %w", err)
+		}
+		// Links and devices are skipped, so they can't point outside the
+		// output directory either.
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := safeMemberName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := readMember(tr, budget)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", hdr.Name, err)
+		}
+		members = append(members, member{Name: name, Data: content})
+	}
+}
+
+// emailAttachments returns the attachments of a MIME email message.
+// Attached messages are searched for attachments too.
+func emailAttachments(data []byte, budget *int64) ([]member, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading email: %w", err)
+	}
+
+	var members []member
+	if err := collectAttachments(msg.Header, msg.Body, &members, budget); err != nil {
+		return nil, fmt.Errorf("reading email: %w", err)
+	}
+	return members, nil
+}
+
+// mimeHeader is a message or part header.
+type mimeHeader interface {
+	Get(key string) string
+}
+
+func collectAttachments(header mimeHeader, body io.Reader, members *[]member, budget *int64) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := collectAttachments(part.Header, part, members, budget); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := readMember(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body), budget)
+	if err != nil {
+		return err
+	}
+
+	if mediaType == "message/rfc822" {
+		attached, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(content)))
+		if err != nil {
+			return err
+		}
+		return collectAttachments(attached.Header, attached.Body, members, budget)
+	}
+
+	name := attachmentName(header, params)
+	if name == "" {
+		// Unnamed text is the message itself; other parts, such as a PDF
+		// sent without a file name, are named after their position.
+		if strings.HasPrefix(mediaType, "text/") {
+			return nil
+		}
+		ext, ok := mimeExtensions[detectMIMEType(content)]
+		if !ok {
+			ext = mimeExtensions[mediaType]
+		}
+		name = fmt.Sprintf("attachment-%d%s", len(*members)+1, ext)
+	}
+	*members = append(*members, member{Name: name, Data: content})
+	return nil
+}
+
+// attachmentName returns the file name of an attachment, without any
+// directory, or "" for message text.
+func attachmentName(header mimeHeader, contentParams map[string]string) string {
+	name := contentParams["name"]
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func buildTestZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildTestTarGz(t *testing.T, files map[string][]byte, symlink string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		tw.Write(data)
+	}
+	if symlink != "" {
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: symlink, Linkname: "/etc/passwd"})
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// documentsByPath returns the subdirectory of each expanded document by
+// path.
+func documentsByPath(docs []document) map[string]string {
+	m := make(map[string]string)
+	for _, doc := range docs {
+		m[doc.Path] = doc.Subdir
+	}
+	return m
+}
+
+func TestExpandContainer_Archives(t *testing.T) {
+	pdf := buildTestPDF(1, false)
+	png := []byte("\x89PNG\r\n\x1a\n")
+
+	nested := buildTestTarGz(t, map[string][]byte{"c.pdf": pdf}, "link.pdf")
+	docx := buildTestZip(t, map[string][]byte{"[Content_Types].xml": []byte("<Types/>"), "word/document.xml": nil})
+
+	data := buildTestZip(t, map[string][]byte{
+		"a.pdf":             pdf,
+		"2024/jan/b.png":    png,
+		"2024/notes.txt":    []byte("not a document"),
+		"more/old.tar.gz":   nested,
+		"letters/memo.docx": docx,
+	})
+
+	docs, skipped, err := expandContainer("in/scans.zip", data)
+	if err != nil {
+		t.Fatalf("expandContainer failed: %v", err)
+	}
+
+	want := map[string]string{
+		"in/scans.zip/a.pdf":                 "scans",
+		"in/scans.zip/2024/jan/b.png":        "scans/2024/jan",
+		"in/scans.zip/more/old.tar.gz/c.pdf": "scans/more/old",
+		"in/scans.zip/letters/memo.docx":     "scans/letters",
+	}
+	got := documentsByPath(docs)
+	if len(got) != len(want) {
+		t.Errorf("expected %d documents, got %v", len(want), got)
+	}
+	for path, subdir := range want {
+		if got[path] != subdir {
+			t.Errorf("expected %s in %q, got %q (all: %v)", path, subdir, got[path], got)
+		}
+	}
+
+	for _, doc := range docs {
+		if doc.Dir != "in" || doc.Data == nil {
+			t.Errorf("expected %s to be read into memory with output directory in, got %q", doc.Path, doc.Dir)
+		}
+	}
+
+	if len(skipped) != 1 || skipped[0] != "in/scans.zip/2024/notes.txt" {
+		t.Errorf("expected notes.txt to be skipped, got %v", skipped)
+	}
+}
+
+func TestExpandContainer_ZipSlip(t *testing.T) {
+	pdf := buildTestPDF(1, false)
+
+	for _, name := range []string{"../evil.pdf", "a/../../evil.pdf", "/etc/evil.pdf", `..\evil.pdf`} {
+		data := buildTestZip(t, map[string][]byte{name: pdf})
+		if _, _, err := expandContainer("scans.zip", data); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+			t.Errorf("expected unsafe path error for %q, got: %v", name, err)
+		}
+
+		data = buildTestTarGz(t, map[string][]byte{name: pdf}, "")
+		if _, _, err := expandContainer("scans.tar.gz", data); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+			t.Errorf("expected unsafe path error for %q in tar, got: %v", name, err)
+		}
+	}
+}
+
+func TestExpandMembers_TotalSize(t *testing.T) {
+	pdf := buildTestPDF(1, false)
+	inner := buildTestTarGz(t, map[string][]byte{"b.pdf": pdf, "c.pdf": pdf}, "")
+	data := buildTestZip(t, map[string][]byte{"a.pdf": pdf, "inner.tar.gz": inner})
+
+	expand := func(budget int64) ([]document, error) {
+		var docs []document
+		var skipped []string
+		err := expandMembers(document{Path: "scans.zip"}, "scans", "scans.zip", data, 0, &docs, &skipped, &budget)
+		return docs, err
+	}
+
+	if docs, err := expand(1 << 20); err != nil || len(docs) != 3 {
+		t.Fatalf("expected 3 documents within the budget, got %d (%v)", len(docs), err)
+	}
+	// The nested archive and its uncompressed tar count too, besides the
+	// documents.
+	if _, err := expand(int64(3*len(pdf) + len(inner))); !errors.Is(err, errExpandedSize) {
+		t.Errorf("expected an error once the members exceed the budget, got %v", err)
+	}
+}
+
+func TestExpandContainer_Email(t *testing.T) {
+	pdf := buildTestPDF(1, false)
+	encoded := base64.StdEncoding.EncodeToString(pdf)
+
+	var lines []string
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+
+	eml := strings.ReplaceAll(`From: sender@example.com
+To: ocr@example.com
+Subject: Invoices
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain; charset=utf-8
+
+Please find the invoice attached.
+--outer
+Content-Type: application/pdf; name="invoice.pdf"
+Content-Disposition: attachment; filename="=?utf-8?q?invoice_m=C3=A4rz.pdf?="
+Content-Transfer-Encoding: base64
+
+`+strings.Join(lines, "\n")+`
+--outer
+Content-Type: message/rfc822
+
+From: someone@example.com
+Subject: Fwd
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="inner"
+
+--inner
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="../../forwarded.pdf"
+Content-Transfer-Encoding: base64
+
+`+strings.Join(lines, "\n")+`
+--inner--
+--outer--
+`, "\n", "\r\n")
+
+	docs, _, err := expandContainer("mail/invoices.eml", []byte(eml))
+	if err != nil {
+		t.Fatalf("expandContainer failed: %v", err)
+	}
+
+	want := map[string]string{
+		"mail/invoices.eml/invoice märz.pdf": "invoices",
+		"mail/invoices.eml/forwarded.pdf":    "invoices",
+	}
+	got := documentsByPath(docs)
+	if len(got) != len(want) {
+		t.Errorf("expected %d attachments, got %v", len(want), got)
+	}
+	for path, subdir := range want {
+		if got[path] != subdir {
+			t.Errorf("expected %s in %q, got %v", path, subdir, got)
+		}
+	}
+	for _, doc := range docs {
+		if !bytes.Equal(doc.Data, pdf) {
+			t.Errorf("attachment %s was not decoded", doc.Path)
+		}
+	}
+}
+
+func TestExpandContainer_EmailNames(t *testing.T) {
+	pdf := base64.StdEncoding.EncodeToString(buildTestPDF(1, false))
+	part := func(header string) string {
+		return "--b\n" + header + "Content-Transfer-Encoding: base64\n\n" + pdf + "\n"
+	}
+	eml := strings.ReplaceAll("MIME-Version: 1.0\nContent-Type: multipart/mixed; boundary=b\n\n"+
+		"--b\nContent-Type: text/plain\n\nTwo scans, one without a name.\n"+
+		part("Content-Type: application/pdf; name=scan.pdf\n")+
+		part("Content-Type: application/pdf\nContent-Disposition: attachment; filename=scan.pdf\n")+
+		part("Content-Type: application/pdf\n")+
+		"--b--\n", "\n", "\r\n")
+
+	docs, _, err := expandContainer("mail.eml", []byte(eml))
+	if err != nil {
+		t.Fatalf("expandContainer failed: %v", err)
+	}
+	got := documentsByPath(docs)
+	for _, path := range []string{"mail.eml/scan.pdf", "mail.eml/scan-2.pdf", "mail.eml/attachment-3.pdf"} {
+		if _, ok := got[path]; !ok {
+			t.Errorf("expected %s, got %v", path, got)
+		}
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 attachments, got %v", got)
+	}
+}
+
+func TestUniqueMemberNames(t *testing.T) {
+	members := []member{{Name: "a/scan.pdf"}, {Name: "a/scan.pdf"}, {Name: "a/scan.png"}, {Name: "b/scan.pdf"}, {Name: "a/scan.tar.gz"}}
+	uniqueMemberNames(members)
+
+	want := []string{"a/scan.pdf", "a/scan-2.pdf", "a/scan-3.png", "b/scan.pdf", "a/scan-4.tar.gz"}
+	for i, m := range members {
+		if m.Name != want[i] {
+			t.Errorf("member %d: expected %s, got %s", i, want[i], m.Name)
+		}
+	}
+}
+
+func TestIsContainer(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"scans.zip", []byte("PK\x03\x04"), true},
+		{"scans.tgz", []byte("\x1f\x8b\x08"), true},
+		{"mail.EML", []byte("From: a@example.com"), true},
+		{"scan.pdf", buildTestPDF(1, false), false},
+	}
+	for _, tt := range tests {
+		if got := isContainer(tt.name, tt.head); got != tt.want {
+			t.Errorf("isContainer(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
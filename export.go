@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// Export is the structured JSON export of a processed document, written to
+// <basename>.json with -j. Image files are referenced relative to the
+// output directory.
+type Export struct {
+	Source             string       `json:"source"`
+	Model              string       `json:"model,omitempty"`
+	UsageInfo          *UsageInfo   `json:"usage_info,omitempty"`
+	Pages              []ExportPage `json:"pages"`
+	DocumentAnnotation any          `json:"document_annotation,omitempty"`
+}
+
+// ExportPage is a page in the JSON export.
+type ExportPage struct {
+	Index      int             `json:"index"`
+	Dimensions *PageDimensions `json:"dimensions,omitempty"`
+	Header     string          `json:"header,omitempty"`
+	Footer     string          `json:"footer,omitempty"`
+	Markdown   string          `json:"markdown"`
+	Images     []ExportImage   `json:"images"`
+}
+
+// ExportImage is an extracted image in the JSON export, without its data.
+// The bounding box is given in page pixels and, when the page dimensions are
+// known, normalized to the page size.
+type ExportImage struct {
+	ID             string       `json:"id"`
+	File           string       `json:"file,omitempty"`
+	TopLeftX       int          `json:"top_left_x"`
+	TopLeftY       int          `json:"top_left_y"`
+	BottomRightX   int          `json:"bottom_right_x"`
+	BottomRightY   int          `json:"bottom_right_y"`
+	NormalizedBBox *BoundingBox `json:"normalized_bbox,omitempty"`
+	Annotation     any          `json:"annotation,omitempty"`
+}
+
+// newExport builds the JSON export for resp. files holds the names of the
+// image files in images/ as returned by extractImages; images that weren't
+// written have no file.
+func newExport(docPath string, resp *OCRResponse, files map[int]string) *Export {
+	export := &Export{
+		Source:             filepath.Base(docPath),
+		Model:              resp.Model,
+		UsageInfo:          resp.UsageInfo,
+		Pages:              make([]ExportPage, 0, len(resp.Pages)),
+		DocumentAnnotation: decodeAnnotation(resp.DocumentAnnotation),
+	}
+
+	imgIndex := 0
+	for _, page := range resp.Pages {
+		exportPage := ExportPage{
+			Index:      page.Index,
+			Dimensions: page.Dimensions,
+			Header:     page.Header,
+			Footer:     page.Footer,
+			Markdown:   page.Markdown,
+			Images:     make([]ExportImage, 0, len(page.Images)),
+		}
+
+		for _, img := range page.Images {
+			var file string
+			if name, ok := files[imgIndex]; ok {
+				file = path.Join("images", name)
+			}
+			exportPage.Images = append(exportPage.Images, ExportImage{
+				ID:             img.ID,
+				File:           file,
+				TopLeftX:       img.TopLeftX,
+				TopLeftY:       img.TopLeftY,
+				BottomRightX:   img.BottomRightX,
+				BottomRightY:   img.BottomRightY,
+				NormalizedBBox: img.PageNormalizedBBox(page),
+				Annotation:     decodeAnnotation(img.ImageAnnotation),
+			})
+			imgIndex++
+		}
+
+		export.Pages = append(export.Pages, exportPage)
+	}
+
+	return export
+}
+
+// encodeExport returns the indented JSON export.
+func encodeExport(export *Export) ([]byte, error) {
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling export: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestImage_NormalizedBBox(t *testing.T) {
+	img := Image{TopLeftX: 100, TopLeftY: 50, BottomRightX: 300, BottomRightY: 2500}
+
+	bbox, ok := img.NormalizedBBox(PageDimensions{DPI: 200, Width: 1000, Height: 2000})
+	if !ok {
+		t.Fatal("expected bounding box")
+	}
+
+	want := BoundingBox{X0: 0.1, Y0: 0.025, X1: 0.3, Y1: 1}
+	if bbox != want {
+		t.Errorf("expected %+v (clamped to the page), got %+v", want, bbox)
+	}
+
+	if _, ok := img.NormalizedBBox(PageDimensions{}); ok {
+		t.Error("expected no bounding box without page dimensions")
+	}
+}
+
+func TestNewExport(t *testing.T) {
+	var resp OCRResponse
+	body := `{
+		"model": "mistral-ocr-2505",
+		"usage_info": {"pages_processed": 2, "doc_size_bytes": 1024},
+		"document_annotation": "{\"title\": \"Report\"}",
+		"pages": [
+			{"index": 0, "markdown": "# Report", "images": [], "dimensions": {"dpi": 200, "height": 2200, "width": 1700}},
+			{"index": 1, "markdown": "![img-0.jpeg](img-0.jpeg)", "dimensions": {"dpi": 200, "height": 2200, "width": 1700},
+			 "images": [{"id": "img-0.jpeg", "top_left_x": 170, "top_left_y": 220, "bottom_right_x": 850, "bottom_right_y": 1100,
+			             "image_base64": "data:image/jpeg;base64,AAAA"},
+			            {"id": "img-1.jpeg", "image_base64": "data:image/jpeg;base64,!!!"}]}
+		]
+	}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	// The second image failed to decode, so it wasn't written.
+	export := newExport("/tmp/report.pdf", &resp, map[int]string{0: "page_1_img_0.jpg"})
+
+	if export.Source != "report.pdf" {
+		t.Errorf("expected source report.pdf, got %s", export.Source)
+	}
+	if export.Model != "mistral-ocr-2505" || export.UsageInfo.PagesProcessed != 2 {
+		t.Errorf("expected model and usage to be exported, got %q %+v", export.Model, export.UsageInfo)
+	}
+	if annotation, ok := export.DocumentAnnotation.(map[string]any); !ok || annotation["title"] != "Report" {
+		t.Errorf("expected decoded document annotation, got %v", export.DocumentAnnotation)
+	}
+
+	page := export.Pages[1]
+	if page.Dimensions == nil || page.Dimensions.Width != 1700 || page.Dimensions.DPI != 200 {
+		t.Errorf("expected page dimensions, got %+v", page.Dimensions)
+	}
+
+	img := page.Images[0]
+	if img.File != "images/page_1_img_0.jpg" {
+		t.Errorf("expected file images/page_1_img_0.jpg, got %s", img.File)
+	}
+	if file := page.Images[1].File; file != "" {
+		t.Errorf("expected no file for an image that wasn't written, got %s", file)
+	}
+	if img.NormalizedBBox == nil || *img.NormalizedBBox != (BoundingBox{X0: 0.1, Y0: 0.1, X1: 0.5, Y1: 0.5}) {
+		t.Errorf("unexpected normalized bbox: %+v", img.NormalizedBBox)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// signedURLExpiryHours is how long a signed URL for an uploaded file stays
+// valid. It only needs to outlive the OCR requests that reference it.
+const signedURLExpiryHours = 1
+
+// uploadFile uploads a document to the Mistral Files API for OCR and returns
+// the ID of the stored file.
+func (c *Client) uploadFile(ctx context.Context, name string, data []byte) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	if err := w.WriteField("purpose", "ocr"); err != nil {
+		return "", fmt.Errorf("creating upload: %w", err)
+	}
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return "", fmt.Errorf("creating upload: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", fmt.Errorf("creating upload: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("creating upload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/files", &body)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	var upload FileUpload
+	if err := c.do(req, &upload); err != nil {
+		return "", fmt.Errorf("uploading file: %w", err)
+	}
+
+	return upload.ID, nil
+}
+
+// signedURL returns a temporary URL the OCR endpoint can fetch an uploaded
+// file from.
+func (c *Client) signedURL(ctx context.Context, fileID string) (string, error) {
+	u := fmt.Sprintf("%s/files/%s/url?expiry=%d", c.baseURL, url.PathEscape(fileID), signedURLExpiryHours)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+
+	var signed SignedURL
+	if err := c.do(req, &signed); err != nil {
+		return "", fmt.Errorf("getting signed URL: %w", err)
+	}
+
+	return signed.URL, nil
+}
+
+// deleteFile removes an uploaded file.
+func (c *Client) deleteFile(ctx context.Context, fileID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.baseURL+"/files/"+url.PathEscape(fileID), nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	if err := c.do(req, nil); err != nil {
+		return fmt.Errorf("deleting file: %w", err)
+	}
+
+	return nil
+}
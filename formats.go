@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// formatMarkdown is the default output format, the Markdown file.
+const formatMarkdown = "markdown"
+
+// formatSource is what an output format is built from.
+type formatSource struct {
+	// Path is the path of the document.
+	Path string
+	// Data is the document itself.
+	Data []byte
+	Resp *OCRResponse
+	// Time is when the document was processed.
+	Time time.Time
+	// Markdown is the text of the document, as written to <basename>.md but
+	// with the tables kept when -table-links replaces them there. Resp has
+	// the pages it is joined from.
+	Markdown string
+	// Title and Author are given with -title and -author.
+	Title, Author string
+}
+
+// formatFile is a file written for an output format. Name is relative to the
+// output directory.
+type formatFile struct {
+	Name string
+	Data []byte
+}
+
+// outputFormat writes the OCR result of a document in a format other than
+// Markdown, as files named after baseName.
+type outputFormat func(src formatSource, baseName string) ([]formatFile, error)
+
+// outputFormats are the formats -format accepts besides markdown.
+var outputFormats = map[string]outputFormat{
+	// The extension keeps the input PDF from being overwritten.
+	"searchable-pdf": singleFile(".ocr.pdf", writeSearchablePDF),
+	"docx":           singleFile(".ocr.docx", writeDOCX),
+	"epub":           singleFile(".ocr.epub", writeEPUB),
+	"hocr":           pageFiles("hocr", ".hocr", writeHOCRPage),
+	"alto":           pageFiles("alto", ".xml", writeALTOPage),
+}
+
+// singleFile returns a format that writes the whole document to
+// <basename><ext>.
+func singleFile(ext string, write func(formatSource) ([]byte, error)) outputFormat {
+	return func(src formatSource, baseName string) ([]formatFile, error) {
+		data, err := write(src)
+		if err != nil {
+			return nil, err
+		}
+		return []formatFile{{Name: baseName + ext, Data: data}}, nil
+	}
+}
+
+// pageFiles returns a format that writes each page to its own file,
+// dir/page_<n><ext>, like the extracted images.
+func pageFiles(dir, ext string, write func(formatSource, Page) ([]byte, error)) outputFormat {
+	return func(src formatSource, baseName string) ([]formatFile, error) {
+		var files []formatFile
+		for _, page := range src.Resp.Pages {
+			data, err := write(src, page)
+			if err != nil {
+				return nil, fmt.Errorf("page %d: %w", page.Index, err)
+			}
+			files = append(files, formatFile{Name: fmt.Sprintf("%s/page_%d%s", dir, page.Index, ext), Data: data})
+		}
+		return files, nil
+	}
+}
+
+// parseFormats parses a comma-separated list of output formats.
+func parseFormats(s string) ([]string, error) {
+	var formats []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || slices.Contains(formats, f) {
+			continue
+		}
+		if outputFormats[f] == nil && f != formatMarkdown {
+			return nil, fmt.Errorf("unknown output format %q (expected %s)", f, strings.Join(formatNames(), ", "))
+		}
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no output format given")
+	}
+	return formats, nil
+}
+
+// formatNames returns the names of the output formats, markdown first.
+func formatNames() []string {
+	names := []string{formatMarkdown}
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	slices.Sort(names[1:])
+	return names
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frontMatterKeys are the keys frontMatter writes itself. Annotation fields
+// with these names are left out.
+var frontMatterKeys = []string{"source", "sha256", "pages", "model", "options", "processed", "tool_version"}
+
+// frontMatter returns YAML front matter recording where the Markdown of a
+// document came from, followed by the fields of its document annotation.
+// Nested fields are flattened into keys joined with underscores, such as
+// address_city.
+func frontMatter(docPath string, data []byte, resp *OCRResponse, opts OCROptions, processed time.Time) string {
+	var b strings.Builder
+	field := func(indent, key string, v any) {
+		fmt.Fprintf(&b, "%s%s: %s\n", indent, formatYAMLString(key), formatYAMLScalar(v))
+	}
+
+	sum := sha256.Sum256(data)
+	b.WriteString("---\n")
+	field("", "source", filepath.Base(docPath))
+	field("", "sha256", hex.EncodeToString(sum[:]))
+	field("", "pages", len(resp.Pages))
+	if resp.Model != "" {
+		field("", "model", resp.Model)
+	}
+	b.WriteString("options:\n")
+	field("  ", "image_metadata", opts.ExtractImageMetadata)
+	if opts.DocumentSchema != nil {
+		field("  ", "schema", opts.DocumentSchema.Name)
+	}
+	field("", "processed", processed.UTC().Format(time.RFC3339))
+	field("", "tool_version", version)
+
+	fields := map[string]any{}
+	annotation := decodeAnnotation(resp.DocumentAnnotation)
+	if _, ok := annotation.(map[string]any); ok {
+		flattenFields("", annotation, fields)
+	} else if annotation != nil {
+		flattenFields("annotation", annotation, fields)
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if !slices.Contains(frontMatterKeys, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		field("", key, fields[key])
+	}
+	b.WriteString("---\n\n")
+	return b.String()
+}
+
+// flattenFields adds the scalars in v to out, under keys made from their
+// path joined with underscores. Lists of scalars are kept as lists; other
+// lists are flattened by index.
+func flattenFields(prefix string, v any, out map[string]any) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "_" + key
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for key, item := range v {
+			flattenFields(join(key), item, out)
+		}
+	case []any:
+		scalars := true
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]any, []any:
+				scalars = false
+			}
+		}
+		if scalars {
+			out[prefix] = v
+			return
+		}
+		for i, item := range v {
+			flattenFields(join(strconv.Itoa(i)), item, out)
+		}
+	default:
+		out[prefix] = v
+	}
+}
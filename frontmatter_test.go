@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// splitFrontMatter returns the parsed front matter of Markdown and the text
+// after it.
+func splitFrontMatter(t *testing.T, md string) (map[string]any, string) {
+	t.Helper()
+
+	rest, ok := strings.CutPrefix(md, "---\n")
+	end := strings.Index(rest, "\n---\n")
+	if !ok || end == -1 {
+		t.Fatalf("expected front matter, got:\n%s", md)
+	}
+	fields, err := parseYAML([]byte(rest[:end+1]))
+	if err != nil {
+		t.Fatalf("invalid front matter: %v\n%s", err, rest[:end+1])
+	}
+	return fields, strings.TrimPrefix(rest[end+5:], "\n")
+}
+
+func TestFrontMatter(t *testing.T) {
+	resp := &OCRResponse{
+		Model: "mistral-ocr-latest",
+		Pages: []Page{{Index: 0}, {Index: 1}},
+		DocumentAnnotation: `{"title": "Invoice: March", "paid": true, "total": 12.5, "tags": ["a", "b, c"],` +
+			` "vendor": {"name": "ACME", "address": {"city": "Paris"}}, "lines": [{"item": "Tea"}], "pages": 99, "note": "yes"}`,
+	}
+	opts := OCROptions{ExtractImageMetadata: true, DocumentSchema: &JSONSchema{Name: "invoice"}}
+	processed := time.Date(2025, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	fields, _ := splitFrontMatter(t, frontMatter("in/scan.pdf", []byte("abc"), resp, opts, processed))
+
+	want := map[string]any{
+		"source":              "scan.pdf",
+		"sha256":              "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"pages":               2,
+		"model":               "mistral-ocr-latest",
+		"options":             map[string]any{"image_metadata": true, "schema": "invoice"},
+		"processed":           "2025-03-01T11:30:00Z",
+		"tool_version":        version,
+		"title":               "Invoice: March",
+		"paid":                true,
+		"total":               12.5,
+		"tags":                []any{"a", "b, c"},
+		"vendor_name":         "ACME",
+		"vendor_address_city": "Paris",
+		"lines_0_item":        "Tea",
+		"note":                "yes",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("unexpected front matter:\n got %v\nwant %v", fields, want)
+	}
+}
+
+func TestFormatYAMLScalar(t *testing.T) {
+	for _, s := range []string{"plain text", "", " padded", "a: b", "#tag", "- item", "true", "No", "12", "1.5", "line\nbreak", "tab\there", `quote "x"`, "x #y", "end:"} {
+		formatted := formatYAMLScalar(s)
+		if got, err := parseYAMLScalar(formatted); err != nil || got != s {
+			t.Errorf("%q formatted as %s reads back as %#v (%v)", s, formatted, got, err)
+		}
+	}
+	if got := formatYAMLScalar("plain text"); got != "plain text" {
+		t.Errorf("expected plain text unquoted, got %s", got)
+	}
+}
+
+func TestProcessFile_FrontMatter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OCRResponse{Pages: []Page{{Index: 0, Markdown: "# Text"}}})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: filepath.Join(dir, "scan.pdf"), Data: buildTestPDF(1, false), Dir: dir}
+	ro := runOptions{FrontMatter: true}
+
+	if _, err := processFile(context.Background(), client, doc, ro, NewReporter(io.Discard, true, false)); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+	md, err := os.ReadFile(filepath.Join(dir, "scan.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, text := splitFrontMatter(t, string(md))
+	if fields["source"] != "scan.pdf" || fields["pages"] != 1 {
+		t.Errorf("unexpected front matter: %v", fields)
+	}
+	if !strings.HasPrefix(text, "# Text") {
+		t.Errorf("expected the text after the front matter, got %q", text)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+const (
+	// edgeLines is how many non-empty lines at the top and bottom of a page
+	// are considered as headers and footers.
+	edgeLines = 3
+
+	// A line is a running header or footer if it repeats, apart from
+	// numbers, on at least minRepeatedPages pages and minRepeatedFraction
+	// of all pages.
+	minRepeatedPages    = 3
+	minRepeatedFraction = 0.4
+)
+
+var digitsRe = regexp.MustCompile(`\d+`)
+
+// edgeLine is the normalized text of a line near the top or bottom of a page.
+type edgeLine struct {
+	top  bool
+	text string
+}
+
+// stripRepeatedLines removes running headers, footers, and page numbers from
+// the Markdown of pages: lines at the top or bottom of a page that repeat on
+// many pages, ignoring differences in numbers. Only lines between the edge
+// of the page and the first line that doesn't repeat are removed. The
+// removed lines are added to the pages' Header and Footer.
+func stripRepeatedLines(pages []Page) {
+	if len(pages) < minRepeatedPages {
+		return
+	}
+
+	counts := make(map[edgeLine]int)
+	for _, page := range pages {
+		lines := strings.Split(page.Markdown, "\n")
+		top, bottom := pageEdges(lines)
+		seen := make(map[edgeLine]bool)
+		count := func(indexes []int, top bool) {
+			for _, i := range indexes {
+				key := edgeLine{top: top, text: normalizeEdgeLine(lines[i])}
+				if key.text != "" && !seen[key] {
+					seen[key] = true
+					counts[key]++
+				}
+			}
+		}
+		count(top, true)
+		count(bottom, false)
+	}
+
+	threshold := max(minRepeatedPages, int(math.Ceil(minRepeatedFraction*float64(len(pages)))))
+	repeated := func(top bool, line string) bool {
+		return counts[edgeLine{top: top, text: normalizeEdgeLine(line)}] >= threshold
+	}
+
+	for i := range pages {
+		page := &pages[i]
+		lines := strings.Split(page.Markdown, "\n")
+		top, bottom := pageEdges(lines)
+
+		start, end := 0, len(lines)
+		var header, footer []string
+		for _, j := range top {
+			if !repeated(true, lines[j]) {
+				break
+			}
+			header = append(header, strings.TrimSpace(lines[j]))
+			start = j + 1
+		}
+		for _, j := range bottom {
+			if !repeated(false, lines[j]) {
+				break
+			}
+			footer = append([]string{strings.TrimSpace(lines[j])}, footer...)
+			end = j
+		}
+
+		if len(header) == 0 && len(footer) == 0 {
+			continue
+		}
+		page.Markdown = strings.Trim(strings.Join(lines[start:end], "\n"), "\n")
+		page.Header = joinNonEmpty(page.Header, strings.Join(header, "\n"))
+		page.Footer = joinNonEmpty(page.Footer, strings.Join(footer, "\n"))
+	}
+}
+
+// pageEdges returns the indexes of the first and the last non-empty lines,
+// up to edgeLines each, in order from the edge of the page inwards. Short
+// pages are split between the two.
+func pageEdges(lines []string) (top, bottom []int) {
+	var nonEmpty []int
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			nonEmpty = append(nonEmpty, i)
+		}
+	}
+
+	nTop := min(edgeLines, (len(nonEmpty)+1)/2)
+	nBottom := min(edgeLines, len(nonEmpty)/2)
+	top = nonEmpty[:nTop]
+	for i := len(nonEmpty) - 1; i >= len(nonEmpty)-nBottom; i-- {
+		bottom = append(bottom, nonEmpty[i])
+	}
+	return top, bottom
+}
+
+// normalizeEdgeLine returns the text used to compare a line across pages:
+// lowercased, without Markdown decoration, with numbers replaced by "#".
+// Headings, images, and table rows are never headers, as chapters start
+// with headings such as "# Chapter 1"; they normalize to "".
+func normalizeEdgeLine(line string) string {
+	s := strings.TrimSpace(line)
+	if headingRe.MatchString(s) || strings.HasPrefix(s, "![") || strings.HasPrefix(s, "|") {
+		return ""
+	}
+
+	s = strings.Trim(strings.ToLower(s), "#*_>- \t")
+	s = digitsRe.ReplaceAllString(s, "#")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// joinNonEmpty joins the non-empty strings with newlines.
+func joinNonEmpty(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	return a + "\n" + b
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStripRepeatedLines(t *testing.T) {
+	topics := []string{"Revenue grew.", "Costs fell.", "Staff doubled.", "Offices moved.", "Outlook is good."}
+	var pages []Page
+	for i, topic := range topics {
+		pages = append(pages, Page{
+			Index: i,
+			Markdown: fmt.Sprintf("ACME Corp — Annual Report 2024\n\n![img-%d.jpeg](img-%d.jpeg)\n\n"+
+				"%s\n\n- %d -", i, i, topic, i+1),
+		})
+	}
+
+	stripRepeatedLines(pages)
+
+	for i, page := range pages {
+		want := fmt.Sprintf("![img-%d.jpeg](img-%d.jpeg)\n\n%s", i, i, topics[i])
+		if page.Markdown != want {
+			t.Errorf("page %d: expected Markdown %q, got %q", i, want, page.Markdown)
+		}
+		if page.Header != "ACME Corp — Annual Report 2024" {
+			t.Errorf("page %d: expected header, got %q", i, page.Header)
+		}
+		if page.Footer != fmt.Sprintf("- %d -", i+1) {
+			t.Errorf("page %d: expected page number footer, got %q", i, page.Footer)
+		}
+	}
+}
+
+func TestStripRepeatedLines_KeepsUnrepeated(t *testing.T) {
+	pages := []Page{
+		{Markdown: "# Introduction\n\nFirst page.\n\nPage 1 of 4"},
+		{Markdown: "# Methods\n\nSecond page.\n\nPage 2 of 4"},
+		{Markdown: "# Results\n\nThird page.\n\nPage 3 of 4"},
+		{Markdown: "# Discussion\n\nFourth page.", Footer: "Page 4 of 4"},
+	}
+
+	stripRepeatedLines(pages)
+
+	for i, page := range pages {
+		if !strings.HasPrefix(page.Markdown, "# ") {
+			t.Errorf("page %d: expected heading to be kept, got %q", i, page.Markdown)
+		}
+		if strings.Contains(page.Markdown, "Page ") {
+			t.Errorf("page %d: expected page number to be stripped, got %q", i, page.Markdown)
+		}
+		if want := fmt.Sprintf("Page %d of 4", i+1); page.Footer != want {
+			t.Errorf("page %d: expected footer %q, got %q", i, want, page.Footer)
+		}
+	}
+
+	short := []Page{{Markdown: "Header\n\nA"}, {Markdown: "Header\n\nB"}}
+	stripRepeatedLines(short)
+	if short[0].Markdown != "Header\n\nA" {
+		t.Errorf("expected documents with fewer than %d pages to be left alone, got %q", minRepeatedPages, short[0].Markdown)
+	}
+}
+
+func TestStripRepeatedLines_KeepsHeadings(t *testing.T) {
+	var pages []Page
+	for i := range 4 {
+		pages = append(pages, Page{Markdown: fmt.Sprintf("# Chapter %d\n\nText of chapter %d.\n\n%d", i+1, i+1, i+1)})
+	}
+
+	stripRepeatedLines(pages)
+
+	for i, page := range pages {
+		if want := fmt.Sprintf("# Chapter %d\n\nText of chapter %d.", i+1, i+1); page.Markdown != want || page.Header != "" {
+			t.Errorf("page %d: expected the heading kept and the page number stripped, got %q (header %q)", i, page.Markdown, page.Header)
+		}
+	}
+}
+
+func TestNewOCRRequest_ExtractHeaders(t *testing.T) {
+	req := NewClient("").newOCRRequest(DocumentURL{}, nil, OCROptions{ExtractHeaders: true})
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal request: %v", err)
+	}
+	if !strings.Contains(string(body), `"extract_header":true`) || !strings.Contains(string(body), `"extract_footer":true`) {
+		t.Errorf("expected header and footer extraction in request, got %s", body)
+	}
+
+	body, _ = json.Marshal(NewClient("").newOCRRequest(DocumentURL{}, nil, OCROptions{}))
+	if strings.Contains(string(body), "extract_header") {
+		t.Errorf("expected no header extraction by default, got %s", body)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// layoutTestResponse is a page with a heading, text, an image, a list, and
+// a table.
+func layoutTestResponse() *OCRResponse {
+	return &OCRResponse{Model: "mistral-ocr-latest", Pages: []Page{{
+		Index: 2,
+		Markdown: "# Annual Report\n\nSales *grew* by 5% & more.\n\n![img-0.jpeg](img-0.jpeg)\n\n" +
+			"- North\n- South\n\n| Region | Total |\n|---|---|\n| North | 10 |",
+		Dimensions: &PageDimensions{DPI: 200, Width: 1700, Height: 2200},
+		Images:     []Image{{ID: "img-0.jpeg", TopLeftX: 100, TopLeftY: 600, BottomRightX: 900, BottomRightY: 1000}},
+	}}}
+}
+
+// hocrTestElement is an element of an hOCR document with an ocr class.
+type hocrTestElement struct {
+	Tag, Class, ID, Title, Text string
+}
+
+// parseHOCR parses an hOCR document as XML and returns its ocr elements in
+// document order.
+func parseHOCR(t *testing.T, data []byte) []hocrTestElement {
+	t.Helper()
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var elements []hocrTestElement
+	var open []int // indexes into elements, -1 for other elements
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid XML: %v", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			e := hocrTestElement{Tag: tok.Name.Local}
+			for _, attr := range tok.Attr {
+				switch attr.Name.Local {
+				case "class":
+					e.Class = attr.Value
+				case "id":
+					e.ID = attr.Value
+				case "title":
+					e.Title = attr.Value
+				}
+			}
+			if strings.HasPrefix(e.Class, "ocr") {
+				elements = append(elements, e)
+				open = append(open, len(elements)-1)
+			} else {
+				open = append(open, -1)
+			}
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		case xml.CharData:
+			if len(open) > 0 && open[len(open)-1] >= 0 {
+				elements[open[len(open)-1]].Text += string(tok)
+			}
+		}
+	}
+	return elements
+}
+
+func TestWriteHOCRPage(t *testing.T) {
+	resp := layoutTestResponse()
+	files, err := outputFormats["hocr"](formatSource{Path: "in/report.pdf", Resp: resp}, "report")
+	if err != nil {
+		t.Fatalf("hocr failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "hocr/page_2.hocr" {
+		t.Fatalf("expected one file per page, got %+v", files)
+	}
+	data := files[0].Data
+	if !bytes.Contains(data, []byte(`<meta name="ocr-system" content="ocr (mistral-ocr-latest)" />`)) {
+		t.Errorf("expected ocr-system meta, got:\n%s", data)
+	}
+
+	var text []string
+	classes := map[string][]hocrTestElement{}
+	for _, e := range parseHOCR(t, data) {
+		classes[e.Class] = append(classes[e.Class], e)
+		if e.Class == "ocr_par" || e.Class == "ocr_table" {
+			text = append(text, strings.Join(strings.Fields(e.Text), " "))
+		}
+	}
+
+	if pages := classes["ocr_page"]; len(pages) != 1 || pages[0].ID != "page_3" ||
+		pages[0].Title != `bbox 0 0 1700 2200; ppageno 2` {
+		t.Errorf("unexpected page: %+v", pages)
+	}
+	if images := classes["ocr_image"]; len(images) != 1 || images[0].Title != "bbox 100 600 900 1000" {
+		t.Errorf("expected the image at its bounding box, got %+v", images)
+	}
+	if len(classes["ocr_table"]) != 1 || len(classes["ocr_carea"]) != 3 {
+		t.Errorf("expected 1 table and 3 text areas, got %d and %d", len(classes["ocr_table"]), len(classes["ocr_carea"]))
+	}
+	if pars := classes["ocr_par"]; len(pars) == 0 || pars[0].Tag != "h1" {
+		t.Errorf("expected the heading as h1, got %+v", pars)
+	}
+	if got := strings.Join(text, " | "); got != "Annual Report | Sales grew by 5% & more. | North South | Region Total North 10" {
+		t.Errorf("unexpected text %q", got)
+	}
+
+	// The API gives no positions for text, so none are made up.
+	for _, class := range []string{"ocr_carea", "ocr_par", "ocr_table"} {
+		for _, e := range classes[class] {
+			if e.Title != "" {
+				t.Errorf("expected no bbox for %s, got %q", e.ID, e.Title)
+			}
+		}
+	}
+	if len(classes["ocr_line"]) != 0 || len(classes["ocrx_word"]) != 0 {
+		t.Errorf("expected no lines or words, got %d and %d", len(classes["ocr_line"]), len(classes["ocrx_word"]))
+	}
+	if !bytes.Contains(data, []byte("<br />South")) || bytes.Contains(data, []byte("</br>")) || bytes.Contains(data, []byte("</meta>")) {
+		t.Errorf("expected the lines of a block separated by line breaks, got:\n%s", data)
+	}
+}
+
+func TestProcessFile_HOCRAndALTO(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OCRResponse{Pages: []Page{{Index: 0, Markdown: "One."}, {Index: 1, Markdown: "Two."}}})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: filepath.Join(dir, "scan.pdf"), Data: buildTestPDF(2, false), Dir: dir}
+	ro := runOptions{Formats: []string{"markdown", "hocr", "alto"}}
+
+	start := time.Now()
+	if _, err := processFile(context.Background(), client, doc, ro, NewReporter(io.Discard, true, false)); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	for _, name := range []string{"scan.md", "hocr/page_0.hocr", "hocr/page_1.hocr", "alto/page_0.xml", "alto/page_1.xml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "alto", "page_1.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alto altoTestDocument
+	if err := xml.Unmarshal(data, &alto); err != nil {
+		t.Fatalf("invalid ALTO: %v", err)
+	}
+	if processed, err := time.Parse(time.RFC3339, alto.Processing); err != nil || processed.Before(start.Truncate(time.Second)) {
+		t.Errorf("expected the processing time, got %q", alto.Processing)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// runInspect implements "ocr inspect": it prints what the pre-flight checks
+// find out about a document, and what processing it would cost, without
+// calling the API. It takes the options of the main command, from flags and
+// config files alike, and returns the validation error, if any.
+func (a *App) runInspect(args []string) error {
+	fs := a.flagSet("inspect")
+	defineSettingFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(a.Stderr, `Usage: %s inspect [options] <document>
+
+Checks a document locally and prints its type, size, page count, encryption,
+and image dimensions, along with the estimated request size and cost.
+Does not call the API. Exits with an error if the document would be rejected.
+Options are the same as for processing documents; -m, -a, -max-pages,
+-model, and -price-table change the estimates.
+
+Options:
+`, a.name())
+		fs.PrintDefaults()
+	}
+
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, err := a.loadConfig(fs)
+	if err != nil {
+		return err
+	}
+
+	docPath := fs.Arg(0)
+	data, err := os.ReadFile(a.path(docPath))
+	if err != nil {
+		return fmt.Errorf("reading document: %w", err)
+	}
+
+	opts := OCROptions{
+		ExtractImageMetadata: cfg.Bool("image_metadata"),
+		MaxPagesPerRequest:   cfg.Int("max_pages"),
+	}
+
+	if schemaPath := cfg.SchemaPath(); schemaPath != "" {
+		schema, err := loadDocumentSchema(schemaPath)
+		if err != nil {
+			return fmt.Errorf("loading schema file: %w", err)
+		}
+		opts.DocumentSchema = schema
+	}
+
+	prices := defaultPriceTable
+	if path := cfg.Path("price_table"); path != "" {
+		prices, err = loadPriceTable(path)
+		if err != nil {
+			return fmt.Errorf("loading price table: %w", err)
+		}
+	}
+
+	info := inspectDocument(filepath.Base(docPath), data)
+	printDocumentInfo(a.Stdout, info, opts, cfg.String("model"), prices)
+
+	return info.Validate()
+}
+
+// printDocumentInfo writes a human-readable summary of info to w, with the
+// cost of processing it with model.
+func printDocumentInfo(w io.Writer, info *DocumentInfo, opts OCROptions, model string, prices PriceTable) {
+	row := func(label, format string, args ...any) {
+		fmt.Fprintf(w, "%-15s %s\n", label+":", fmt.Sprintf(format, args...))
+	}
+
+	row("File", "%s", info.Name)
+
+	switch {
+	case info.MIMEType == "":
+		row("Type", "unknown")
+	case info.PDFVersion != "":
+		row("Type", "%s (PDF %s)", info.MIMEType, info.PDFVersion)
+	default:
+		row("Type", "%s", info.MIMEType)
+	}
+
+	row("Size", "%s (%d bytes)", formatBytes(info.Size), info.Size)
+
+	switch info.MIMEType {
+	case "application/pdf":
+		row("Pages", "%d", info.Pages)
+		row("Encrypted", "%s", yesNo(info.Encrypted))
+	case mimeDOCX, mimePPTX, mimeODT:
+		if info.Pages > 0 {
+			row("Pages", "%d", info.Pages)
+		}
+	}
+
+	if info.Width > 0 || info.Height > 0 {
+		row("Dimensions", "%dx%d px", info.Width, info.Height)
+	}
+
+	requests, size := estimateRequests(info, opts)
+	if info.Size > maxInlineDocumentSize {
+		row("Request size", "%s per request, %d request(s), document uploaded via Files API", formatBytes(size), requests)
+	} else {
+		row("Request size", "%s per request, %d request(s)", formatBytes(size), requests)
+	}
+
+	if info.Pages > 0 {
+		row("Estimated cost", "$%.3f (%d pages)", prices.Cost(model, info.Pages, opts.annotated()), info.Pages)
+	} else {
+		row("Estimated cost", "unknown (page count not available)")
+	}
+
+	if info.Validate() != nil {
+		row("Status", "FAIL")
+	} else {
+		row("Status", "OK")
+	}
+}
+
+// estimateRequests returns the number of OCR requests a document needs and
+// the body size of the largest one.
+func estimateRequests(info *DocumentInfo, opts OCROptions) (int, int) {
+	chunks := documentRanges(info, opts)
+
+	// Size the request without the document, then add the encoded document
+	// on top. Uploaded documents are referenced by a short signed URL.
+	body, _ := json.Marshal(NewClient("").newOCRRequest(newDocumentURL(info.MIMEType, ""), chunks[0], opts))
+	urlSize := len("data:"+info.MIMEType+";base64,") + base64.StdEncoding.EncodedLen(info.Size)
+	if info.Size > maxInlineDocumentSize {
+		urlSize = 512
+	}
+
+	return len(chunks), len(body) + urlSize
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// pageBox is a rectangle on a page, in pixels of the page image.
+type pageBox struct {
+	X0, Y0, X1, Y1 int
+}
+
+// layoutBlock is a Markdown block placed on its page.
+type layoutBlock struct {
+	mdBlock
+	Box   pageBox
+	Lines []layoutLine
+	// Image is the image of an image block, placed at its bounding box.
+	Image *Image
+}
+
+// layoutLine is a line of text placed on its page.
+type layoutLine struct {
+	Text  string
+	Box   pageBox
+	Words []layoutWord
+}
+
+// layoutWord is a word placed on its page.
+type layoutWord struct {
+	Text string
+	Box  pageBox
+}
+
+// pageSizePixels returns the size of a page's image, or of an A4 page at 72
+// dpi when the response has no dimensions.
+func pageSizePixels(page Page) (int, int) {
+	if d := page.Dimensions; d != nil && d.Width > 0 && d.Height > 0 {
+		return d.Width, d.Height
+	}
+	return defaultPageWidth, defaultPageHeight
+}
+
+// layoutPage places the blocks of a page's Markdown on the page. Images are
+// placed at their bounding boxes. The API doesn't return the position of
+// text, so text blocks are estimated: they are stacked in reading order in
+// the space between the images, wrapped to the page width.
+func layoutPage(page Page) (width, height int, blocks []layoutBlock) {
+	width, height = pageSizePixels(page)
+	margin := min(width, height) / 20
+	textWidth := width - 2*margin
+	lineHeight := max(float64(height)/60, 1)
+
+	images := make(map[string]*Image, len(page.Images))
+	for i := range page.Images {
+		images[page.Images[i].ID] = &page.Images[i]
+	}
+	place := func(b mdBlock, img *Image) {
+		box := clampBox(pageBox{img.TopLeftX, img.TopLeftY, img.BottomRightX, img.BottomRightY}, width, height)
+		if box.X1 > box.X0 && box.Y1 > box.Y0 {
+			blocks = append(blocks, layoutBlock{mdBlock: b, Box: box, Image: img})
+		}
+		delete(images, img.ID)
+	}
+
+	for _, b := range markdownBlocks(page.Markdown) {
+		if b.Kind == blockImage {
+			if img := images[b.ImageID]; img != nil {
+				place(b, img)
+			}
+			continue
+		}
+		block := layoutBlock{mdBlock: b}
+		for _, line := range wrapLines(b.plainLines(), float64(textWidth), lineHeight) {
+			block.Lines = append(block.Lines, layoutLine{Text: line})
+		}
+		if len(block.Lines) > 0 {
+			blocks = append(blocks, block)
+		}
+	}
+	// Images the Markdown doesn't link to still belong on the page.
+	for i := range page.Images {
+		if img := &page.Images[i]; images[img.ID] == img {
+			place(mdBlock{Kind: blockImage, ImageID: img.ID}, img)
+		}
+	}
+
+	top := float64(margin)
+	for i := 0; i < len(blocks); {
+		if blocks[i].Image != nil {
+			top = max(top, float64(blocks[i].Box.Y1))
+			i++
+			continue
+		}
+
+		// A run of text blocks fills the space down to the next image, with
+		// half a line between blocks. Lines shrink if they don't fit.
+		end, lines := i, 0
+		for end < len(blocks) && blocks[end].Image == nil {
+			lines += len(blocks[end].Lines)
+			end++
+		}
+		units := float64(lines) + 0.5*float64(end-i-1)
+		bottom := float64(height - margin)
+		if end < len(blocks) && float64(blocks[end].Box.Y0)-top >= units {
+			bottom = float64(blocks[end].Box.Y0)
+		}
+		lh := max(min(lineHeight, (bottom-top)/units), 1)
+
+		for ; i < end; i++ {
+			block := &blocks[i]
+			y0 := top
+			x1 := margin
+			for j := range block.Lines {
+				line := &block.Lines[j]
+				placeLine(line, margin, width-margin, top, top+lh)
+				line.Box = clampBox(line.Box, width, height)
+				x1 = max(x1, line.Box.X1)
+				top += lh
+			}
+			block.Box = clampBox(pageBox{margin, int(y0 + 0.5), x1, int(top + 0.5)}, width, height)
+			top += lh / 2
+		}
+	}
+	return width, height, blocks
+}
+
+// placeLine places a line and its words between x0 and x1, giving each
+// character a width of half the line height.
+func placeLine(line *layoutLine, x0, x1 int, y0, y1 float64) {
+	charWidth := (y1 - y0) / 2
+	top, bottom := int(y0+0.5), int(y1+0.5)
+	x := float64(x0)
+	for _, word := range strings.Fields(line.Text) {
+		end := min(x+float64(utf8.RuneCountInString(word))*charWidth, float64(x1))
+		line.Words = append(line.Words, layoutWord{Text: word, Box: pageBox{int(x + 0.5), top, int(end + 0.5), bottom}})
+		x = min(end+charWidth, float64(x1))
+	}
+	line.Box = pageBox{x0, top, x0, bottom}
+	if n := len(line.Words); n > 0 {
+		line.Box.X1 = line.Words[n-1].Box.X1
+	}
+}
+
+// clampBox limits a box to the page.
+func clampBox(b pageBox, width, height int) pageBox {
+	return pageBox{
+		X0: min(max(b.X0, 0), width), Y0: min(max(b.Y0, 0), height),
+		X1: min(max(b.X1, 0), width), Y1: min(max(b.Y1, 0), height),
+	}
+}
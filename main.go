@@ -2,37 +2,49 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 // version is set via ldflags at build time
 var version = "dev"
 
 func main() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
+	os.Exit(newApp().Run())
 }
 
-func run() error {
-	outputDir := flag.String("o", "", "Output directory (default: same directory as input)")
-	extractMetadata := flag.Bool("m", false, "Extract image metadata (description, type, structured data)")
-	annotationSchema := flag.String("a", "", "Extract document data using JSON schema file")
-	quiet := flag.Bool("q", false, "Quiet mode (suppress progress output)")
-	verbose := flag.Bool("v", false, "Verbose mode (extra details to stderr)")
-	showVersion := flag.Bool("version", false, "Print version and exit")
+func (a *App) run() error {
+	if len(a.Args) > 1 && a.Args[1] == "inspect" {
+		return a.runInspect(a.Args[2:])
+	}
+
+	if len(a.Args) > 1 && a.Args[1] == "config" {
+		return a.runConfig(a.Args[2:])
+	}
+
+	flags := a.flagSet(a.name())
+	defineSettingFlags(flags)
+	showVersion := flags.Bool("version", false, "Print version and exit")
+	stdinNameFlag := flags.String("name", "", "File name for a document read from stdin (-), used for the output files (default: stdin.<ext>)")
+	toStdout := flags.Bool("stdout", false, "Write the Markdown to stdout instead of a file; other outputs are skipped unless -tar is given")
+	tarPath := flags.String("tar", "", "With -stdout, write images, metadata, annotation, and JSON export to this tar file")
+	archivePath := flags.String("archive", "", "Write all outputs and a manifest to this .zip, .tar, .tar.gz, or .tgz archive instead of the output directory")
 
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, `ocr - Extract Markdown, images, and image metadata from documents using LLMs
+	flags.Usage = func() {
+		name := a.name()
+		fmt.Fprintf(a.Stderr, `ocr - Extract Markdown, images, and image metadata from documents using LLMs
 
-Usage: %s [options] <document>
+Usage: %s [options] <document>...
+       %s inspect [options] <document>
+       %s config show [options]
 
 Description:
   Uses large language models to extract content from documents:
@@ -42,21 +54,96 @@ Description:
     extracted from charts, graphs, tables, and diagrams
   - Optional document-level structured data extraction via JSON schema
 
-  Supported formats: PDF, images (PNG, JPEG, GIF, WebP)
-
-  Uses Mistral OCR with built-in annotation support for structured extraction.
-
-  Prints the path to the output Markdown file on stdout.
+  Supported formats: PDF, Word (DOCX), PowerPoint (PPTX), OpenDocument
+  text (ODT), EPUB, and images (PNG, JPEG, GIF, WebP). The type is detected
+  from the contents, not the file extension. Spreadsheets (XLSX) are not
+  supported.
+
+  Archives (zip, tar, tar.gz) and emails (.eml) are expanded in memory, and
+  every supported document in them, or attached to them, is processed. The
+  outputs mirror the paths inside the archive, below a directory named
+  after it. Members with paths that would escape it are rejected.
+
+  Uses Mistral OCR with built-in annotation support for structured extraction,
+  or with -provider openai, a vision model behind any OpenAI-compatible chat
+  completions endpoint, transcribing each page with a JSON-schema-constrained
+  prompt. The openai provider sends page images, so it supports images and
+  scanned PDFs only; born-digital PDFs are rejected.
+  PDFs that exceed the API's page limit are split into page ranges,
+  processed concurrently, and merged into a single result; documents over
+  the size limit are uploaded first.
+
+  Documents are checked locally before upload: unsupported types, truncated
+  or encrypted PDFs, and files over the size limit are rejected up front.
+  Run "inspect" to print these checks and the estimated request size and
+  cost without calling the API.
+
+  Several documents can be processed in one run. A summary of the pages
+  processed and their estimated cost is printed at the end; -budget-pages
+  refuses to start, or stops scheduling documents, once the budget would
+  be exceeded.
+
+  Prints the path to each output Markdown file, and to each file written
+  in another -format, on stdout.
   Progress messages are written to stderr.
 
+  Use "-" to read a document from stdin; its type is detected from its
+  contents and -name sets the name of the output files. With -stdout the
+  Markdown is written to stdout instead of a file, and the other outputs
+  are skipped, or written to a tar file with -tar.
+
+  With -archive, all outputs are written to a single .zip, .tar, .tar.gz,
+  or .tgz archive instead of the output directory, in the same layout, with
+  a manifest.json listing each document and its files. When several
+  documents are archived, each gets its own directory unless
+  -output-template is set.
+
+  Running headers, footers, and page numbers can be kept out of the
+  Markdown: -extract-headers asks the API to return them separately (newer
+  models only), and -strip-headers removes lines repeated at the top or
+  bottom of many pages. Either way, they are kept in the JSON export.
+  With -reflow, paragraphs and tables split across pages and words
+  hyphenated at line ends are joined in the Markdown file.
+
+  -front-matter starts the Markdown with YAML front matter: the source file
+  name and SHA-256, page count, model, -m and schema name, processing time,
+  tool version, and the document annotation's fields, flattened.
+
+  With -tables, each Markdown or HTML table is written as CSV to tables/,
+  with merged header rows flattened into one, and listed with its page and
+  lines in <basename>.tables.json. -table-links replaces the tables in the
+  Markdown with links to their CSV files.
+
+  -format selects the output formats, as a comma-separated list. Besides
+  markdown, searchable-pdf writes <basename>.ocr.pdf: the page images with
+  an invisible text layer, so scans can be searched and copied from. docx
+  writes <basename>.ocr.docx, a Word document with a page break between
+  pages and image descriptions from -m as captions. epub writes
+  <basename>.ocr.epub, a book with a chapter per top-level heading; -title
+  and -author override the title and author of the document annotation.
+  hocr and alto write an hOCR or ALTO XML file per page to hocr/ or alto/,
+  with text blocks, tables, and images as regions of the page; only images
+  have a position in hOCR, while ALTO's text positions are estimated.
+
 Options:
-`, os.Args[0])
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, `
+`, name, name, name)
+		flags.PrintDefaults()
+		fmt.Fprintf(a.Stderr, `
 Output Structure:
   <output-dir>/
   ├── <basename>.md              # Extracted text in Markdown format
   ├── <basename>.annotation.json # Document annotation (with -a flag)
+  ├── <basename>.json            # JSON export (with -j flag)
+  ├── <basename>.ocr.pdf         # Searchable PDF (with -format searchable-pdf)
+  ├── <basename>.ocr.docx        # Word document (with -format docx)
+  ├── <basename>.ocr.epub        # EPUB book (with -format epub)
+  ├── <basename>.tables.json     # Table index (with -tables flag)
+  ├── tables/
+  │   └── page_0_table_0.csv     # Extracted tables (with -tables flag)
+  ├── hocr/
+  │   └── page_0.hocr            # hOCR page (with -format hocr)
+  ├── alto/
+  │   └── page_0.xml             # ALTO page (with -format alto)
   └── images/
       ├── page_0_img_0.png       # Extracted images
       ├── page_0_img_0.json      # Image metadata (with -m flag)
@@ -75,8 +162,58 @@ Document Schema File Format (for -a flag):
     "schema": { <JSON Schema object> }
   }
 
+Price Table File Format (for -price-table flag):
+  {
+    "default": {"ocr": 1.0, "annotation": 3.0},
+    "<model>": {"ocr": <USD per 1000 pages>, "annotation": <USD per 1000 pages>}
+  }
+
+Configuration:
+  Every option can also be set in a config file or the environment. Values
+  are taken from flags, then the environment, then the selected profile,
+  then the project config (.ocr.yaml in the working directory or a parent),
+  then the user config ($XDG_CONFIG_HOME/ocr/config.toml, by default
+  ~/.config/ocr/config.toml), then the built-in defaults. Relative paths in
+  config files are resolved against the file's directory. Run "config show"
+  to print the effective configuration and the source of each value.
+
+  User config (config.toml):
+    retries = 5
+    profile = "gateway"                  # profile used when none is given
+
+    [schemas]
+    invoice = "schemas/invoice.json"     # select with -a invoice
+
+    [profiles.gateway]
+    base_url = "https://gateway.example.com/mistral/v1"
+    model = "mistral-ocr-2505"
+
+  Project config (.ocr.yaml):
+    output_dir: out
+    output_template: "{name}/{name}"
+    image_metadata: true
+    schema: invoice
+
+API Key:
+  The API key is read from the first of:
+  - the file given with -api-key-file or MISTRAL_API_KEY_FILE, e.g. a
+    Docker or Kubernetes secret
+  - the output of api_key_command (-api-key-command), run with the shell,
+    e.g. "pass show mistral"
+  - MISTRAL_API_KEY
+  A key file or command from a config file does not override
+  MISTRAL_API_KEY. The key is redacted from progress output and errors.
+
 Environment:
-  MISTRAL_API_KEY   Required. API key for Mistral AI.
+  MISTRAL_API_KEY       API key for Mistral AI.
+  MISTRAL_API_KEY_FILE  File containing the API key (config key api_key_file).
+  MISTRAL_BASE_URL      API base URL (config key base_url).
+  OPENAI_API_KEY        API key for -provider openai, which also works
+                        without one. A key file or command is only used
+                        for it if set by a flag, or in the config file or
+                        profile that selects the provider.
+  OCR_<KEY>             Any other config key, e.g. OCR_MODEL, OCR_PROFILE,
+                        OCR_CONCURRENCY, OCR_OUTPUT_DIR.
 
 Examples:
   %s document.pdf
@@ -93,94 +230,607 @@ Examples:
 
   %s -m -a schema.json document.pdf
       Extract with both image and document annotations
-`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+
+  %s -no-images -image-min-size 100 -j catalogue.pdf
+      Extract text only, listing images of at least 100 pixels in the export
+
+  %s -front-matter -a invoice -o notes/ invoice.pdf
+      Write Markdown with YAML front matter holding the invoice fields
+
+  %s -provider openai -base-url http://localhost:8000/v1 -model qwen2.5-vl scan.pdf
+      Use a vision model on a local OpenAI-compatible server instead of Mistral
+
+  %s -record bug-123/ scan.pdf
+      Save the API requests and responses, to run again with -replay bug-123/
+
+  %s -max-pages 100 -concurrency 8 book.pdf
+      Process a long document in 100-page requests, 8 at a time
+
+  %s -budget-pages 500 scans/*.pdf
+      Process a batch, stopping before it exceeds 500 pages
+
+  %s -profile gateway -model mistral-ocr-2505 document.pdf
+      Use the gateway profile from the config file with a pinned model
+
+  cat scan.pdf | %s -stdout - | grep -i invoice
+      Read a document from stdin and search its Markdown
+
+  %s -stdout -tar images.tar document.pdf > document.md
+      Write the Markdown to stdout and the images to a tar file
+
+  %s -o out scans.zip invoices.eml
+      Process the documents in a zip file and the attachments of an email
+
+  %s -archive results.zip scans/*.pdf
+      Write the results of a batch to a zip archive
+
+  %s -api-key-file /run/secrets/mistral_api_key document.pdf
+      Read the API key from a secret file
+
+  %s -strip-headers -j report.pdf
+      Remove running headers and page numbers, keeping them in report.json
+
+  %s -strip-headers -reflow book.pdf
+      Write the Markdown as continuous text without page breaks
+
+  %s -tables -table-links -table-format html statement.pdf
+      Write each table to a CSV file and link to it from the Markdown
+
+  %s -format markdown,searchable-pdf scan.pdf
+      Write the Markdown and a searchable copy of the scan, scan.ocr.pdf
+
+  %s -format docx -m contract.pdf
+      Write an editable Word document, contract.ocr.docx, with image captions
+
+  %s -format epub -strip-headers -reflow -author "A. Writer" book.pdf
+      Write an e-book, book.ocr.epub, with a chapter per top-level heading
+
+  %s -format hocr,alto -o archive/ scan.pdf
+      Write an hOCR and an ALTO file for each page of the scan
+
+  %s inspect -m scan.pdf
+      Check a document and estimate its cost without calling the API
+
+  %s config show -profile gateway
+      Print the effective configuration with the gateway profile
+`, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name, name)
 	}
 
-	flag.Parse()
+	if err := parse(flags, a.Args[1:]); err != nil {
+		return err
+	}
 
 	if *showVersion {
-		fmt.Println(version)
+		fmt.Fprintln(a.Stdout, version)
 		return nil
 	}
 
-	if flag.NArg() != 1 {
-		flag.Usage()
-		os.Exit(1)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return errUsage
 	}
 
-	docPath := flag.Arg(0)
+	if *tarPath != "" && !*toStdout {
+		return fmt.Errorf("-tar requires -stdout")
+	}
+	if *tarPath == "-" {
+		return fmt.Errorf("-tar cannot write to stdout, which receives the Markdown")
+	}
+	if *archivePath != "" && *toStdout {
+		return fmt.Errorf("-archive cannot be combined with -stdout")
+	}
 
-	if _, err := os.Stat(docPath); os.IsNotExist(err) {
-		return fmt.Errorf("file not found: %s", docPath)
+	stdinCount := 0
+	for _, arg := range flags.Args() {
+		if arg == "-" {
+			stdinCount++
+		}
+	}
+	if stdinCount > 1 {
+		return fmt.Errorf("stdin (-) can only be read once")
+	}
+	if *stdinNameFlag != "" && stdinCount == 0 {
+		return fmt.Errorf("-name only applies to stdin (-)")
 	}
 
-	apiKey := os.Getenv("MISTRAL_API_KEY")
-	if apiKey == "" {
-		return fmt.Errorf("MISTRAL_API_KEY environment variable is required")
+	var docs []document
+	for _, arg := range flags.Args() {
+		if arg == "-" {
+			data, err := io.ReadAll(a.Stdin)
+			if err != nil {
+				return fmt.Errorf("reading stdin: %w", err)
+			}
+			name := stdinName(*stdinNameFlag, data)
+			docs = append(docs, document{Path: name, Data: data, Dir: a.path(filepath.Dir(name))})
+			continue
+		}
+		if _, err := os.Stat(a.path(arg)); os.IsNotExist(err) {
+			return fmt.Errorf("file not found: %s", arg)
+		}
+		docs = append(docs, document{Path: a.path(arg), Dir: filepath.Dir(a.path(arg))})
 	}
 
-	outDir := *outputDir
-	if outDir == "" {
-		outDir = filepath.Dir(docPath)
+	// Expand archives and emails into the documents they contain.
+	var skipped []string
+	for i := 0; i < len(docs); i++ {
+		doc := docs[i]
+		if doc.Data == nil && !isContainerFile(doc.Path) || doc.Data != nil && !isContainer(doc.Path, doc.Data) {
+			continue
+		}
+		data, err := doc.read()
+		if err != nil {
+			return fmt.Errorf("reading document: %w", err)
+		}
+		members, skippedMembers, err := expandContainer(doc.Path, data)
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("%s: no supported documents found", doc.Path)
+		}
+		docs = slices.Replace(docs, i, i+1, members...)
+		i += len(members) - 1
+		skipped = append(skipped, skippedMembers...)
 	}
 
-	if err := os.MkdirAll(outDir, 0755); err != nil {
-		return fmt.Errorf("creating output directory: %w", err)
+	cfg, err := a.loadConfig(flags)
+	if err != nil {
+		return fmt.Errorf("loading configuration: %w", err)
 	}
 
-	report := NewReporter(os.Stderr, *quiet, *verbose)
-	baseName := strings.TrimSuffix(filepath.Base(docPath), filepath.Ext(docPath))
+	report := NewReporter(a.Stderr, cfg.Bool("quiet"), cfg.Bool("verbose"))
+
+	keyEnv, baseURL := "MISTRAL_API_KEY", cfg.String("base_url")
+	if cfg.String("provider") == providerOpenAI {
+		keyEnv = "OPENAI_API_KEY"
+		if cfg.Layer("base_url") == layerDefault {
+			baseURL = defaultOpenAIBaseURL
+		}
+	}
+	apiKey, keySource, err := a.resolveAPIKey(context.Background(), cfg, keyEnv)
+	if errors.Is(err, errNoAPIKey) && (cfg.String("provider") == providerOpenAI || cfg.Path("replay") != "") {
+		// Locally hosted model servers usually don't need a key, and
+		// replaying a recording doesn't call the API.
+		keySource = "none"
+	} else if err != nil {
+		return err
+	}
+	report.Redact(apiKey)
+
+	report.Verbose("Endpoint: %s (provider %s, model %s)\n", baseURL, cfg.String("provider"), cfg.String("model"))
+	report.Verbose("API key: %s\n", keySource)
+
+	factory := a.NewProvider
+	if factory == nil {
+		factory = newProvider
+	}
+	provider, err := factory(cfg, apiKey)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range skipped {
+		report.Verbose("Skipping unsupported file: %s\n", name)
+	}
 
 	// Build OCR options
 	opts := OCROptions{
-		ExtractImageMetadata: *extractMetadata,
+		ExtractImageMetadata: cfg.Bool("image_metadata"),
+		MaxPagesPerRequest:   cfg.Int("max_pages"),
+		Concurrency:          cfg.Int("concurrency"),
+		ExtractHeaders:       cfg.Bool("extract_headers"),
+		TableFormat:          cfg.String("table_format"),
+		ImageLimit:           cfg.Int("image_limit"),
+		ImageMinSize:         cfg.Int("image_min_size"),
+	}
+	if f := opts.TableFormat; f != "" && f != "markdown" && f != "html" {
+		return fmt.Errorf("invalid table format %q (expected markdown or html)", f)
+	}
+	if opts.ImageLimit < 0 || opts.ImageMinSize < 0 {
+		return fmt.Errorf("invalid image limit or minimum size (expected 0 or more)")
+	}
+	if cfg.Bool("no_images") {
+		include := false
+		opts.IncludeImages = &include
 	}
 
 	// Load document schema if specified
-	if *annotationSchema != "" {
-		schema, err := loadDocumentSchema(*annotationSchema)
+	if schemaPath := cfg.SchemaPath(); schemaPath != "" {
+		schema, err := loadDocumentSchema(schemaPath)
 		if err != nil {
 			return fmt.Errorf("loading schema file: %w", err)
 		}
 		opts.DocumentSchema = schema
 	}
 
-	report.Progress("Processing: %s\n", docPath)
+	prices := defaultPriceTable
+	if path := cfg.Path("price_table"); path != "" {
+		prices, err = loadPriceTable(path)
+		if err != nil {
+			return fmt.Errorf("loading price table: %w", err)
+		}
+	}
+
+	// Refuse to start if the local page counts already exceed the budget.
+	budgetPages := cfg.Int("budget_pages")
+	pageCounts := make([]int, len(docs))
+	if budgetPages > 0 {
+		total := 0
+		for i, doc := range docs {
+			data, err := doc.read()
+			if err != nil {
+				return fmt.Errorf("reading document: %w", err)
+			}
+			pageCounts[i] = inspectDocument(doc.Path, data).Pages
+			total += pageCounts[i]
+		}
+		if err := checkBudget(budgetPages, 0, total); err != nil {
+			return err
+		}
+	}
 
-	client := NewClient(apiKey)
-	resp, err := client.ProcessDocument(context.Background(), docPath, opts)
+	formats, err := parseFormats(cfg.String("format"))
 	if err != nil {
 		return err
 	}
+	if *toStdout && !slices.Contains(formats, formatMarkdown) {
+		return fmt.Errorf("-stdout writes Markdown, but -format doesn't include markdown")
+	}
+
+	ro := runOptions{
+		Formats:        formats,
+		OutputDir:      cfg.Path("output_dir"),
+		OutputTemplate: cfg.String("output_template"),
+		OCR:            opts,
+		JSONExport:     cfg.Bool("json"),
+		FrontMatter:    cfg.Bool("front_matter"),
+		StripHeaders:   cfg.Bool("strip_headers"),
+		Reflow:         cfg.Bool("reflow"),
+		Tables:         cfg.Bool("tables"),
+		TableLinks:     cfg.Bool("table_links"),
+		Title:          cfg.String("title"),
+		Author:         cfg.String("author"),
+		Out:            a.Stdout,
+	}
+
+	if *toStdout {
+		// Keep stdout for the Markdown alone.
+		ro.Stdout, ro.Out = a.Stdout, a.Stderr
+	}
+	sinkPath := *tarPath
+	if *tarPath != "" {
+		f, err := os.Create(a.path(*tarPath))
+		if err != nil {
+			return fmt.Errorf("creating tar file: %w", err)
+		}
+		sink := newTarSink(*tarPath, f)
+		defer sink.Close()
+		ro.Sink = sink
+	}
+
+	var archive *manifestSink
+	if *archivePath != "" {
+		archive, err = newArchiveSink(a.path(*archivePath))
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		ro.Sink, sinkPath = archive, *archivePath
+
+		// Documents can't share an images directory in an archive, so give
+		// each its own unless the template was configured.
+		if len(docs) > 1 && cfg.Layer("output_template") == layerDefault {
+			ro.OutputTemplate = "{name}/{name}"
+		}
+	}
+
+	var usage Usage
+	var failed int
+	var budgetErr error
+	for i, doc := range docs {
+		// Billed pages can exceed the estimate, so check before each document.
+		if err := checkBudget(budgetPages, usage.Pages, pageCounts[i]); err != nil {
+			budgetErr = fmt.Errorf("stopped before %s: %w", doc.Path, err)
+			break
+		}
+
+		if archive != nil {
+			archive.StartDocument(doc.Path)
+		}
+		resp, err := processFile(context.Background(), provider, doc, ro, report)
+		if archive != nil {
+			archive.FinishDocument(resp, err)
+		}
+		if err != nil {
+			if len(docs) == 1 {
+				return err
+			}
+			report.Error("%s: %v\n", doc.Path, err)
+			failed++
+			continue
+		}
+
+		usage.Add(resp, opts.annotated(), prices)
+	}
+
+	if ro.Sink != nil {
+		if err := ro.Sink.Close(); err != nil {
+			return fmt.Errorf("writing %s: %w", sinkPath, err)
+		}
+	}
+
+	report.Progress("%s\n", usage)
+
+	if budgetErr != nil {
+		return budgetErr
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents failed", failed, len(docs))
+	}
+	return nil
+}
+
+// runOptions holds the settings shared by every document in a run.
+type runOptions struct {
+	// Formats are the output formats to write; nil means markdown.
+	Formats        []string
+	OutputDir      string
+	OutputTemplate string
+	OCR            OCROptions
+	JSONExport     bool
+	FrontMatter    bool
+	StripHeaders   bool
+	Reflow         bool
+	Tables         bool
+	TableLinks     bool
+	// Title and Author are the metadata of EPUB output.
+	Title, Author string
+
+	// Stdout, if set, receives the Markdown instead of a file.
+	Stdout io.Writer
+	// Out, if set, receives the path of each file written, one per line.
+	Out io.Writer
+	// Sink, if set, receives all output files instead of the output
+	// directory. With Stdout and no Sink, only the Markdown is written.
+	Sink outputSink
+}
+
+// document is an input document: a file, data read from stdin, or a member
+// of an archive or email.
+type document struct {
+	// Path is the file path, the name given to stdin with -name, or the
+	// container's path followed by the member's path inside it.
+	Path string
+	// Data holds the contents of stdin and of members; files are read when
+	// processed.
+	Data []byte
+	// Dir is the default output directory.
+	Dir string
+	// Subdir is the directory below the output directory that the outputs
+	// are written to, mirroring the member's path inside its container.
+	Subdir string
+}
+
+func (d document) read() ([]byte, error) {
+	if d.Data != nil {
+		return d.Data, nil
+	}
+	return os.ReadFile(d.Path)
+}
+
+// stdinName returns the name of a document read from stdin. Without an
+// extension in name, one is added for the detected document type.
+func stdinName(name string, data []byte) string {
+	if name == "" {
+		name = "stdin"
+	}
+	if filepath.Ext(name) == "" {
+		name += mimeExtensions[detectMIMEType(data)]
+	}
+	return name
+}
+
+// processFile runs OCR on a single document and writes its outputs next to
+// it, or to ro.OutputDir. It prints the paths of the files written to
+// ro.Out.
+func processFile(ctx context.Context, provider Provider, doc document, ro runOptions, report *Reporter) (*OCRResponse, error) {
+	sink := ro.Sink
+	if sink == nil && ro.Stdout == nil {
+		dir := ro.OutputDir
+		if dir == "" {
+			dir = doc.Dir
+		}
+		sink = dirSink{dir: dir}
+	}
+
+	// The template may place the outputs in a subdirectory.
+	outName := filepath.ToSlash(expandOutputTemplate(ro.OutputTemplate, doc.Path, time.Now()))
+	outDir, baseName := path.Split(path.Join(doc.Subdir, outName))
+
+	// Fail before calling the API if the output directory can't be created.
+	if ds, ok := sink.(dirSink); ok {
+		if err := os.MkdirAll(filepath.Join(ds.dir, filepath.FromSlash(outDir)), 0755); err != nil {
+			return nil, fmt.Errorf("creating output directory: %w", err)
+		}
+	}
+
+	report.Progress("Processing: %s\n", doc.Path)
+
+	data, err := doc.read()
+	if err != nil {
+		return nil, fmt.Errorf("reading document: %w", err)
+	}
+
+	// Image data is decoded to a temporary directory as the response
+	// arrives, rather than held in memory, and copied to the outputs one
+	// image at a time.
+	imageDir, err := os.MkdirTemp("", "ocr-images-")
+	if err != nil {
+		return nil, fmt.Errorf("creating image directory: %w", err)
+	}
+	defer os.RemoveAll(imageDir)
+
+	opts := ro.OCR
+	opts.ImageDir = imageDir
+	var pages []Page
+	resp, err := provider.ProcessBytesStream(ctx, filepath.Base(doc.Path), data, opts, func(page Page) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.Pages = pages
 
 	report.Progress("Extracted %d pages\n", len(resp.Pages))
 
-	text, imageCount := extractText(resp)
+	if resp.Model != "" {
+		report.Verbose("Model: %s\n", resp.Model)
+	}
+	if resp.UsageInfo != nil {
+		report.Verbose("Usage: %d pages processed, %s document\n",
+			resp.UsageInfo.PagesProcessed, formatBytes(resp.UsageInfo.DocSizeBytes))
+	}
+
+	dropMissingImages(resp.Pages)
+
+	if ro.StripHeaders {
+		stripRepeatedLines(resp.Pages)
+	}
+
+	inlineTables(resp.Pages)
+
+	// Tables are found before the Markdown is joined, so that they can be
+	// located on their pages and replaced with links. Only the Markdown
+	// links to them; other formats keep the tables.
+	var tables []Table
+	formatResp := resp
+	if ro.Tables && sink != nil {
+		tables = findTables(resp.Pages)
+		if ro.TableLinks {
+			unlinked := *resp
+			unlinked.Pages = slices.Clone(resp.Pages)
+			formatResp = &unlinked
+			linkTables(resp.Pages, tables)
+		}
+	}
 
-	textPath := filepath.Join(outDir, baseName+".md")
-	if err := os.WriteFile(textPath, []byte(text), 0644); err != nil {
-		return fmt.Errorf("writing text file: %w", err)
+	text, imageCount := extractText(resp, ro.Reflow)
+	formatText, _ := extractText(formatResp, ro.Reflow)
+	processed := time.Now()
+
+	markdown := text
+	if ro.FrontMatter {
+		markdown = frontMatter(doc.Path, data, resp, ro.OCR, processed) + text
+	}
+
+	var textPath string
+	switch {
+	case !ro.wantFormat(formatMarkdown):
+	case ro.Stdout != nil:
+		if _, err := io.WriteString(ro.Stdout, markdown); err != nil {
+			return nil, fmt.Errorf("writing text: %w", err)
+		}
+	default:
+		textPath, err = sink.WriteFile(path.Join(outDir, baseName+".md"), []byte(markdown))
+		if err != nil {
+			return nil, fmt.Errorf("writing text file: %w", err)
+		}
+		report.Verbose("Wrote text to: %s\n", textPath)
 	}
 
-	report.Verbose("Wrote text to: %s\n", textPath)
+	if sink == nil {
+		report.Verbose("Skipped %d images and other outputs (use -tar to keep them)\n", imageCount)
+		return resp, nil
+	}
 
 	// Write document annotation if present
 	if resp.DocumentAnnotation != nil {
-		annotationPath := filepath.Join(outDir, baseName+".annotation.json")
-		if err := saveAnnotation(resp.DocumentAnnotation, annotationPath); err != nil {
-			return fmt.Errorf("writing document annotation: %w", err)
+		annotationPath, err := saveAnnotation(resp.DocumentAnnotation, sink, path.Join(outDir, baseName+".annotation.json"))
+		if err != nil {
+			return nil, fmt.Errorf("writing document annotation: %w", err)
 		}
 		report.Verbose("Wrote document annotation to: %s\n", annotationPath)
 	}
 
+	var imageFiles map[int]string
 	if imageCount > 0 {
-		if err := extractImages(resp, outDir, *extractMetadata, report); err != nil {
-			return err
+		imageFiles, err = extractImages(resp, sink, path.Join(outDir, "images"), ro.OCR.ExtractImageMetadata, report)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	fmt.Println(textPath)
-	return nil
+	if len(tables) > 0 {
+		if err := writeTables(doc.Path, tables, sink, outDir, baseName, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if ro.JSONExport {
+		data, err := encodeExport(newExport(doc.Path, resp, imageFiles))
+		if err != nil {
+			return nil, err
+		}
+		exportPath, err := sink.WriteFile(path.Join(outDir, baseName+".json"), data)
+		if err != nil {
+			return nil, fmt.Errorf("writing JSON export: %w", err)
+		}
+		report.Verbose("Wrote JSON export to: %s\n", exportPath)
+	}
+
+	outputs := []string{textPath}
+	src := formatSource{
+		Path: doc.Path, Data: data, Resp: formatResp, Time: processed,
+		Markdown: formatText, Title: ro.Title, Author: ro.Author,
+	}
+	for _, name := range ro.Formats {
+		format := outputFormats[name]
+		if format == nil {
+			continue
+		}
+		files, err := format(src, baseName)
+		if err != nil {
+			return nil, fmt.Errorf("writing %s: %w", name, err)
+		}
+		for _, file := range files {
+			outPath, err := sink.WriteFile(path.Join(outDir, file.Name), file.Data)
+			if err != nil {
+				return nil, fmt.Errorf("writing %s: %w", name, err)
+			}
+			report.Verbose("Wrote %s to: %s\n", name, outPath)
+			outputs = append(outputs, outPath)
+		}
+	}
+
+	for _, output := range outputs {
+		if output != "" && ro.Out != nil {
+			fmt.Fprintln(ro.Out, output)
+		}
+	}
+	return resp, nil
+}
+
+// wantFormat reports whether the output format was requested.
+func (ro runOptions) wantFormat(name string) bool {
+	if ro.Formats == nil {
+		return name == formatMarkdown
+	}
+	return slices.Contains(ro.Formats, name)
+}
+
+// expandOutputTemplate returns the output file name, without extension,
+// for docPath. An empty template means "{name}".
+func expandOutputTemplate(tmpl, docPath string, now time.Time) string {
+	if tmpl == "" {
+		tmpl = "{name}"
+	}
+	ext := filepath.Ext(docPath)
+	return filepath.FromSlash(strings.NewReplacer(
+		"{name}", strings.TrimSuffix(filepath.Base(docPath), ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{date}", now.Format("2006-01-02"),
+	).Replace(tmpl))
 }
 
 // loadDocumentSchema reads and parses a JSON schema file.
@@ -198,44 +848,63 @@ func loadDocumentSchema(path string) (*JSONSchema, error) {
 	return &schema, nil
 }
 
-func extractText(resp *OCRResponse) (string, int) {
-	var b strings.Builder
-	var imageCount int
+func extractText(resp *OCRResponse, reflow bool) (string, int) {
+	imageCount := countImages(resp)
+	if reflow {
+		return reflowPages(resp.Pages), imageCount
+	}
 
+	var b strings.Builder
 	for _, page := range resp.Pages {
 		b.WriteString(page.Markdown)
 		b.WriteString("\n\n")
-		imageCount += len(page.Images)
 	}
 
 	return b.String(), imageCount
 }
 
-func extractImages(resp *OCRResponse, outDir string, extractMetadata bool, report *Reporter) error {
-	imagesDir := filepath.Join(outDir, "images")
-	if err := os.MkdirAll(imagesDir, 0755); err != nil {
-		return fmt.Errorf("creating images directory: %w", err)
+// extractImages writes the images of resp, and their metadata if
+// requested, to imagesDir in sink. It returns the names of the image files
+// written, by the index of the image counted across pages.
+func extractImages(resp *OCRResponse, sink outputSink, imagesDir string, extractMetadata bool, report *Reporter) (map[int]string, error) {
+	imageCount := 0
+	for _, page := range resp.Pages {
+		for _, img := range page.Images {
+			if img.HasData() {
+				imageCount++
+			}
+		}
+	}
+	if imageCount == 0 {
+		return nil, nil
 	}
-
-	imageCount := countImages(resp)
 	report.Progress("Extracting %d images\n", imageCount)
 
+	files := make(map[int]string)
 	imgIndex := 0
 	for _, page := range resp.Pages {
 		for _, img := range page.Images {
-			imgPath, err := saveImage(img, page.Index, imgIndex, imagesDir)
+			if !img.HasData() {
+				imgIndex++
+				continue
+			}
+			fileName := imageFileName(img, page.Index, imgIndex)
+			imgName := path.Join(imagesDir, fileName)
+			imgPath, err := saveImage(img, sink, imgName)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				report.Error("%v\n", err)
 				imgIndex++
 				continue
 			}
+			files[imgIndex] = fileName
 
 			report.Verbose("Wrote image: %s\n", imgPath)
 
 			// Save annotation metadata if present (from bbox_annotation_format)
 			if extractMetadata && img.ImageAnnotation != nil {
-				if err := saveAnnotationMetadata(img.ImageAnnotation, imgPath); err != nil {
-					fmt.Fprintf(os.Stderr, "Error saving metadata for %s: %v\n", imgPath, err)
+				metadataName := strings.TrimSuffix(imgName, path.Ext(imgName)) + ".json"
+				if _, err := saveAnnotation(img.ImageAnnotation, sink, metadataName); err != nil {
+					report.Error("saving metadata for %s: %v\n", imgPath, err)
 				}
 			}
 
@@ -243,7 +912,7 @@ func extractImages(resp *OCRResponse, outDir string, extractMetadata bool, repor
 		}
 	}
 
-	return nil
+	return files, nil
 }
 
 func countImages(resp *OCRResponse) int {
@@ -254,21 +923,62 @@ func countImages(resp *OCRResponse) int {
 	return count
 }
 
-func saveImage(img Image, pageIndex, imgIndex int, imagesDir string) (string, error) {
-	b64Data := img.ImageBase64
-	if idx := strings.Index(b64Data, ","); idx != -1 {
-		b64Data = b64Data[idx+1:]
-	}
+// dropMissingImages removes the Markdown links to images without data, such
+// as when images weren't requested, as they would point at nothing. Lines
+// left empty are removed.
+func dropMissingImages(pages []Page) {
+	for i := range pages {
+		page := &pages[i]
+		withData := make(map[string]bool, len(page.Images))
+		for _, img := range page.Images {
+			withData[img.ID] = img.HasData()
+		}
 
-	imgData, err := base64.StdEncoding.DecodeString(b64Data)
-	if err != nil {
-		return "", fmt.Errorf("decoding image: %w", err)
+		lines := strings.Split(page.Markdown, "\n")
+		out := lines[:0]
+		changed := false
+		for _, line := range lines {
+			dropped := imageRe.ReplaceAllStringFunc(line, func(m string) string {
+				if withData[imageRe.FindStringSubmatch(m)[2]] {
+					return m
+				}
+				return ""
+			})
+			if dropped == line {
+				out = append(out, line)
+				continue
+			}
+			changed = true
+			if strings.TrimSpace(dropped) != "" {
+				out = append(out, dropped)
+			} else if n := len(out); n > 0 && strings.TrimSpace(out[n-1]) == "" {
+				// Keep a single blank line where the image was.
+				out = out[:n-1]
+			}
+		}
+		if changed {
+			page.Markdown = strings.Join(out, "\n")
+		}
 	}
+}
 
+// imageFileName returns the name an extracted image is saved under.
+func imageFileName(img Image, pageIndex, imgIndex int) string {
 	ext := imageExtension(img.ImageBase64)
-	imgPath := filepath.Join(imagesDir, fmt.Sprintf("page_%d_img_%d%s", pageIndex, imgIndex, ext))
+	if img.File != "" {
+		ext = filepath.Ext(img.File)
+	}
+	return fmt.Sprintf("page_%d_img_%d%s", pageIndex, imgIndex, ext)
+}
+
+func saveImage(img Image, sink outputSink, name string) (string, error) {
+	imgData, err := img.Data()
+	if err != nil {
+		return "", err
+	}
 
-	if err := os.WriteFile(imgPath, imgData, 0644); err != nil {
+	imgPath, err := sink.WriteFile(name, imgData)
+	if err != nil {
 		return "", fmt.Errorf("writing image: %w", err)
 	}
 
@@ -288,8 +998,9 @@ func imageExtension(dataURL string) string {
 	}
 }
 
-// saveAnnotation writes an annotation to a file, handling string-encoded JSON.
-func saveAnnotation(annotation any, path string) error {
+// saveAnnotation writes an annotation to name in sink, handling
+// string-encoded JSON.
+func saveAnnotation(annotation any, sink outputSink, name string) (string, error) {
 	var data []byte
 	var err error
 
@@ -297,7 +1008,7 @@ func saveAnnotation(annotation any, path string) error {
 	if str, ok := annotation.(string); ok {
 		var parsed any
 		if err := json.Unmarshal([]byte(str), &parsed); err != nil {
-			return fmt.Errorf("parsing annotation JSON string: %w", err)
+			return "", fmt.Errorf("parsing annotation JSON string: %w", err)
 		}
 		data, err = json.MarshalIndent(parsed, "", "  ")
 	} else {
@@ -305,17 +1016,13 @@ func saveAnnotation(annotation any, path string) error {
 	}
 
 	if err != nil {
-		return fmt.Errorf("marshaling annotation: %w", err)
+		return "", fmt.Errorf("marshaling annotation: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("writing annotation: %w", err)
+	annotationPath, err := sink.WriteFile(name, data)
+	if err != nil {
+		return "", fmt.Errorf("writing annotation: %w", err)
 	}
 
-	return nil
-}
-
-func saveAnnotationMetadata(annotation any, imgPath string) error {
-	metadataPath := strings.TrimSuffix(imgPath, filepath.Ext(imgPath)) + ".json"
-	return saveAnnotation(annotation, metadataPath)
+	return annotationPath, nil
 }
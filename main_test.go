@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"ocr/ocrtest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// runApp runs the program in dir against server and returns its exit code,
+// stdout, and stderr.
+func runApp(t *testing.T, dir string, server *ocrtest.Server, args []string, stdin []byte, env map[string]string) (int, string, string) {
+	t.Helper()
+
+	vars := map[string]string{
+		"MISTRAL_API_KEY":  "test-api-key",
+		"MISTRAL_BASE_URL": server.URL,
+		"XDG_CONFIG_HOME":  t.TempDir(),
+	}
+	for key, value := range env {
+		vars[key] = value
+	}
+
+	var stdout, stderr bytes.Buffer
+	app := &App{
+		Args:   append([]string{"ocr"}, args...),
+		Getenv: func(key string) string { return vars[key] },
+		Stdin:  bytes.NewReader(stdin),
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Dir:    dir,
+		// Config files outside dir are left out.
+		StopDir: dir,
+		NewProvider: func(cfg *Config, apiKey string) (Provider, error) {
+			provider, err := newProvider(cfg, apiKey)
+			if client, ok := provider.(*Client); ok {
+				client.retryDelay = time.Millisecond
+			}
+			return provider, err
+		},
+	}
+	code := app.Run()
+	return code, stdout.String(), stderr.String()
+}
+
+// goldenOutput describes a run: its exit code, stdout, stderr, and the files
+// it wrote to dir, with dir left out of paths.
+func goldenOutput(t *testing.T, dir string, inputs map[string][]byte, code int, stdout, stderr string) string {
+	t.Helper()
+
+	normalize := func(s string) string {
+		s = strings.ReplaceAll(s, dir+string(filepath.Separator), "")
+		return strings.ReplaceAll(s, dir, ".")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- exit --\n%d\n", code)
+	fmt.Fprintf(&b, "-- stdout --\n%s", normalize(stdout))
+	fmt.Fprintf(&b, "-- stderr --\n%s", normalize(stderr))
+
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, _ := filepath.Rel(dir, p)
+		if _, ok := inputs[filepath.ToSlash(name)]; !ok {
+			names = append(names, filepath.ToSlash(name))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "-- %s --\n", name)
+		if utf8.Valid(data) && !bytes.ContainsRune(data, 0) {
+			b.WriteString(normalize(string(data)))
+			if !bytes.HasSuffix(data, []byte("\n")) {
+				b.WriteString("\n")
+			}
+		} else {
+			fmt.Fprintf(&b, "(%d bytes)\n", len(data))
+		}
+	}
+	return b.String()
+}
+
+func TestApp_Golden(t *testing.T) {
+	pdf := buildTestPDF(1, false)
+
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		stdin  []byte
+		env    map[string]string
+		script []ocrtest.Response
+	}{
+		{name: "markdown", args: []string{"scan.pdf"}},
+		{name: "output_dir", args: []string{"-o", "out", "-j", "-m", "-q", "scan.pdf"}},
+		{name: "stdout", args: []string{"-stdout", "-name", "invoice.pdf", "-"}, stdin: pdf},
+		{name: "batch_failure", args: []string{"-o", "out", "a.pdf", "scan.pdf"},
+			script: []ocrtest.Response{ocrtest.Fail(http.StatusBadRequest)}},
+		{name: "api_error", args: []string{"-retries", "1", "scan.pdf"},
+			script: []ocrtest.Response{ocrtest.Fail(http.StatusBadGateway), ocrtest.Fail(http.StatusBadGateway)}},
+		{name: "file_not_found", args: []string{"missing.pdf"}},
+		{name: "no_api_key", args: []string{"scan.pdf"}, env: map[string]string{"MISTRAL_API_KEY": ""}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := ocrtest.New(t)
+			server.RespondFixture("testdata/invoice.json")
+			server.Script(tt.script...)
+
+			dir := t.TempDir()
+			inputs := map[string][]byte{"scan.pdf": pdf, "a.pdf": pdf}
+			for name, data := range inputs {
+				if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			code, stdout, stderr := runApp(t, dir, server, tt.args, tt.stdin, tt.env)
+			got := goldenOutput(t, dir, inputs, code, stdout, stderr)
+
+			golden := filepath.Join("testdata", "golden", tt.name+".txt")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("reading golden file (run with -update to create it): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept it):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestApp_StdoutFormats(t *testing.T) {
+	server := ocrtest.New(t)
+	server.RespondFixture("testdata/invoice.json")
+
+	args := []string{"-stdout", "-tar", "out.tar", "-format", "markdown,docx", "-name", "invoice.pdf", "-"}
+	code, stdout, stderr := runApp(t, t.TempDir(), server, args, buildTestPDF(1, false), nil)
+	if code != 0 {
+		t.Fatalf("expected exit 0, got %d:\n%s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "# Invoice 2024-001") || strings.Contains(stdout, "invoice.ocr.docx") {
+		t.Errorf("expected only the Markdown on stdout, got:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "out.tar:invoice.ocr.docx") {
+		t.Errorf("expected the DOCX path on stderr, got:\n%s", stderr)
+	}
+}
+
+func TestApp_Inspect(t *testing.T) {
+	server := ocrtest.New(t)
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"scan.pdf":        string(buildTestPDF(2, false)),
+		"notes.txt":       "plain text",
+		"prices.json":     `{"custom-ocr": {"ocr": 10, "annotation": 20}}`,
+		projectConfigName: "model: custom-ocr\nprice_table: prices.json\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The model and price table come from the project config.
+	code, stdout, stderr := runApp(t, dir, server, []string{"inspect", "-m", "scan.pdf"}, nil, nil)
+	if code != 0 || !strings.Contains(stdout, "Estimated cost: $0.060 (2 pages)") {
+		t.Errorf("expected the configured prices, got %d:\n%s%s", code, stdout, stderr)
+	}
+	code, stdout, _ = runApp(t, dir, server, []string{"inspect", "-model", "other", "scan.pdf"}, nil, nil)
+	if code != 0 || !strings.Contains(stdout, "Estimated cost: $0.002 (2 pages)") {
+		t.Errorf("expected the default price for another model, got %d:\n%s", code, stdout)
+	}
+	code, stdout, _ = runApp(t, dir, server, []string{"inspect", "notes.txt"}, nil, nil)
+	if code != 1 || !strings.Contains(stdout, "Estimated cost: unknown") {
+		t.Errorf("expected an unknown cost for an unknown type, got %d:\n%s", code, stdout)
+	}
+}
+
+func TestApp_ExitCodes(t *testing.T) {
+	server := ocrtest.New(t)
+	for _, tt := range []struct {
+		args       []string
+		code       int
+		wantStdout string
+		wantStderr string
+	}{
+		{args: nil, code: 1, wantStderr: "Usage: ocr [options] <document>"},
+		{args: []string{"-bogus"}, code: 2, wantStderr: "flag provided but not defined: -bogus"},
+		{args: []string{"-h"}, code: 0, wantStderr: "Usage: ocr [options] <document>"},
+		{args: []string{"-version"}, code: 0, wantStdout: version},
+		{args: []string{"-tar", "out.tar", "scan.pdf"}, code: 1, wantStderr: "Error: -tar requires -stdout"},
+		{args: []string{"inspect"}, code: 1, wantStderr: "Usage: ocr inspect"},
+		{args: []string{"inspect", "missing.pdf"}, code: 1, wantStderr: "Error: reading document"},
+		{args: []string{"config", "show", "-model", "pinned"}, code: 0, wantStdout: "# flag -model"},
+		{args: []string{"config"}, code: 1, wantStderr: "Error: usage: ocr config show"},
+	} {
+		code, stdout, stderr := runApp(t, t.TempDir(), server, tt.args, nil, nil)
+		if code != tt.code || !strings.Contains(stdout, tt.wantStdout) || !strings.Contains(stderr, tt.wantStderr) {
+			t.Errorf("%v: expected exit %d with %q on stdout and %q on stderr, got %d:\n%s\n%s",
+				tt.args, tt.code, tt.wantStdout, tt.wantStderr, code, stdout, stderr)
+		}
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("expected no API calls, got %d", len(server.Requests()))
+	}
+}
+
+func TestExtractImages(t *testing.T) {
+	png := testImage(t, 2, 2, encodePNG)
+	resp := &OCRResponse{Pages: []Page{
+		{Index: 0, Images: []Image{
+			{ID: "img-0.jpeg"},
+			{ID: "img-1.png", ImageBase64: dataURL("image/png", png), ImageAnnotation: `{"type": "chart"}`},
+		}},
+		{Index: 1, Images: []Image{
+			{ID: "img-2.png", ImageBase64: "data:image/png;base64,!!!"},
+			{ID: "img-3.png", ImageBase64: dataURL("image/png", png), ImageAnnotation: "not json"},
+		}},
+	}}
+
+	dir := t.TempDir()
+	var stderr bytes.Buffer
+	files, err := extractImages(resp, dirSink{dir: dir}, "images", true, NewReporter(&stderr, true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1] != "page_0_img_1.png" || files[3] != "page_1_img_3.png" {
+		t.Errorf("expected the images written returned by index, got %v", files)
+	}
+
+	for name, want := range map[string]string{
+		"page_0_img_1.png":  string(png),
+		"page_0_img_1.json": "{\n  \"type\": \"chart\"\n}",
+		"page_1_img_3.png":  string(png),
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, "images", name))
+		if err != nil || string(data) != want {
+			t.Errorf("expected %s written, got %q (%v)", name, data, err)
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "images"))
+	if len(entries) != 3 {
+		t.Errorf("expected images without data or that fail to decode skipped, got %v", entries)
+	}
+
+	// Errors are reported even in quiet mode, and don't stop the others.
+	if msg := stderr.String(); !strings.Contains(msg, "Error: decoding image") || !strings.Contains(msg, "Error: saving metadata for") {
+		t.Errorf("expected the failures reported, got %q", msg)
+	}
+}
+
+func TestSaveImage(t *testing.T) {
+	dir := t.TempDir()
+	sink := dirSink{dir: dir}
+
+	path, err := saveImage(Image{ImageBase64: dataURL("image/jpeg", []byte("jpeg data"))}, sink, "images/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "jpeg data" {
+		t.Errorf("expected the decoded image in %s, got %q (%v)", path, data, err)
+	}
+
+	file := filepath.Join(dir, "streamed.png")
+	os.WriteFile(file, []byte("png data"), 0644)
+	path, err = saveImage(Image{File: file}, sink, "images/b.png")
+	if data, _ := os.ReadFile(path); err != nil || string(data) != "png data" {
+		t.Errorf("expected the streamed image copied, got %q (%v)", data, err)
+	}
+
+	if _, err := saveImage(Image{ImageBase64: "data:image/png;base64,%%%"}, sink, "images/c.png"); err == nil {
+		t.Error("expected an error for invalid base64")
+	}
+}
+
+func TestSaveAnnotation(t *testing.T) {
+	dir := t.TempDir()
+	sink := dirSink{dir: dir}
+
+	for _, tt := range []struct {
+		annotation any
+		want       string
+	}{
+		{annotation: `{"total":12.5,"items":["tea"]}`, want: "{\n  \"items\": [\n    \"tea\"\n  ],\n  \"total\": 12.5\n}"},
+		{annotation: map[string]any{"vendor": "ACME"}, want: "{\n  \"vendor\": \"ACME\"\n}"},
+	} {
+		path, err := saveAnnotation(tt.annotation, sink, "annotation.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != tt.want {
+			t.Errorf("expected %q, got %q (%v)", tt.want, data, err)
+		}
+	}
+
+	if _, err := saveAnnotation("not json", sink, "bad.json"); err == nil || !strings.Contains(err.Error(), "parsing annotation JSON string") {
+		t.Errorf("expected an error for an invalid JSON string, got %v", err)
+	}
+}
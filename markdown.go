@@ -0,0 +1,309 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// blockKind is the kind of a Markdown block.
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockList
+	blockTable
+	blockImage
+	blockCode
+	blockMath
+)
+
+// mdBlock is a block of a page's Markdown, as the output formats see it.
+type mdBlock struct {
+	Kind blockKind
+	// Lines are the block's lines as they appear in the Markdown.
+	Lines []string
+	// Text is the text of a paragraph or heading, with inline markup.
+	Text string
+	// Level is the level of a heading, from 1.
+	Level int
+	// Items are the items of a list.
+	Items []listItem
+	// Table is the parsed table of a table block.
+	Table Table
+	// ImageID and Alt identify the image of an image block.
+	ImageID, Alt string
+}
+
+// listItem is an item of a Markdown list, with inline markup.
+type listItem struct {
+	Text    string
+	Ordered bool
+	// Depth is the nesting depth, from 0.
+	Depth int
+}
+
+var (
+	headingRe   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	listItemRe  = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	imageRe     = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]*)[^)]*\)`)
+	imageOnlyRe = regexp.MustCompile(`^\s*!\[([^\]]*)\]\(([^)\s]*)[^)]*\)\s*$`)
+	linkRe      = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	strongRe    = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	emphRe      = regexp.MustCompile(`(^|[^\w*])[*_](\S(?:[^*_]*?\S)?)[*_]($|[^\w*])`)
+	codeSpanRe  = regexp.MustCompile("`([^`]*)`")
+	escapeRe    = regexp.MustCompile(`\\([\\` + "`" + `*_{}\[\]()#+\-.!|])`)
+)
+
+// escapedBase is the start of the private use characters that stand in for
+// escaped ASCII characters while markup is removed.
+const escapedBase = 0xE000
+
+// markdownBlocks splits Markdown into blocks: headings, paragraphs, lists,
+// tables, images on a line of their own, code blocks, and display math.
+func markdownBlocks(md string) []mdBlock {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var blocks []mdBlock
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case isCodeFence(trimmed):
+			end := i + 1
+			for end < len(lines) && !isCodeFence(lines[end]) {
+				end++
+			}
+			blocks = append(blocks, mdBlock{Kind: blockCode, Lines: lines[i+1 : min(end, len(lines))]})
+			i = end + 1
+
+		case strings.HasPrefix(trimmed, "$$"):
+			end := i
+			if trimmed == "$$" || !strings.HasSuffix(trimmed[2:], "$$") {
+				end = i + 1
+				for end < len(lines) && !strings.HasSuffix(strings.TrimSpace(lines[end]), "$$") {
+					end++
+				}
+			}
+			end = min(end, len(lines)-1)
+			blocks = append(blocks, mdBlock{Kind: blockMath, Lines: lines[i : end+1]})
+			i = end + 1
+
+		case headingRe.MatchString(trimmed):
+			m := headingRe.FindStringSubmatch(trimmed)
+			blocks = append(blocks, mdBlock{Kind: blockHeading, Lines: lines[i : i+1], Text: m[2], Level: len(m[1])})
+			i++
+
+		case imageOnlyRe.MatchString(line):
+			m := imageOnlyRe.FindStringSubmatch(line)
+			blocks = append(blocks, mdBlock{Kind: blockImage, Lines: lines[i : i+1], Alt: m[1], ImageID: m[2]})
+			i++
+
+		case isTableRow(trimmed):
+			end := i
+			for end < len(lines) && isTableRow(lines[end]) {
+				end++
+			}
+			if t, ok := parsePipeTable(lines[i:end]); ok {
+				blocks = append(blocks, mdBlock{Kind: blockTable, Lines: lines[i:end], Table: t})
+			} else {
+				blocks = append(blocks, mdBlock{Kind: blockParagraph, Lines: lines[i:end], Text: strings.Join(lines[i:end], " ")})
+			}
+			i = end
+
+		case strings.HasPrefix(strings.ToLower(trimmed), "<table"):
+			end := i
+			for end < len(lines)-1 && !strings.Contains(strings.ToLower(lines[end]), "</table>") {
+				end++
+			}
+			raw := strings.Join(lines[i:end+1], "\n")
+			blocks = append(blocks, mdBlock{Kind: blockTable, Lines: lines[i : end+1], Table: parseHTMLTable(raw)})
+			i = end + 1
+
+		case listItemRe.MatchString(line):
+			block := mdBlock{Kind: blockList}
+			start := i
+			for i < len(lines) {
+				if m := listItemRe.FindStringSubmatch(lines[i]); m != nil {
+					block.Items = append(block.Items, listItem{
+						Text:    m[3],
+						Ordered: m[2] != "-" && m[2] != "*" && m[2] != "+",
+						Depth:   len(strings.ReplaceAll(m[1], "\t", "  ")) / 2,
+					})
+				} else if s := strings.TrimSpace(lines[i]); s != "" && strings.HasPrefix(lines[i], " ") {
+					// An indented line continues the previous item.
+					last := &block.Items[len(block.Items)-1]
+					last.Text += " " + s
+				} else {
+					break
+				}
+				i++
+			}
+			block.Lines = lines[start:i]
+			blocks = append(blocks, block)
+
+		default:
+			start := i
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && (i == start || !startsBlock(lines[i])) {
+				i++
+			}
+			var parts []string
+			for _, l := range lines[start:i] {
+				parts = append(parts, strings.TrimSpace(l))
+			}
+			blocks = append(blocks, mdBlock{Kind: blockParagraph, Lines: lines[start:i], Text: strings.Join(parts, " ")})
+		}
+	}
+	return blocks
+}
+
+// startsBlock reports whether a line starts a block other than a paragraph.
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return isCodeFence(trimmed) || strings.HasPrefix(trimmed, "$$") || headingRe.MatchString(trimmed) ||
+		imageOnlyRe.MatchString(line) || isTableRow(trimmed) || listItemRe.MatchString(line) ||
+		strings.HasPrefix(strings.ToLower(trimmed), "<table")
+}
+
+// inlineText returns Markdown inline text without markup: images are
+// dropped, links replaced by their text, and emphasis, code spans, HTML
+// tags, and escapes removed.
+func inlineText(s string) string {
+	// Escaped characters are set aside, so they aren't taken for markup.
+	s = escapeRe.ReplaceAllStringFunc(s, func(m string) string {
+		return string(rune(escapedBase + int(m[1])))
+	})
+	s = imageRe.ReplaceAllString(s, "")
+	s = linkRe.ReplaceAllString(s, "$1")
+	s = codeSpanRe.ReplaceAllString(s, "$1")
+	s = strongRe.ReplaceAllString(s, "$2")
+	s = emphRe.ReplaceAllString(s, "$1$2$3")
+	s = htmlBreakRe.ReplaceAllString(s, " ")
+	s = htmlTagRe.ReplaceAllString(s, "")
+	s = strings.Map(func(r rune) rune {
+		if r >= escapedBase && r < escapedBase+0x80 {
+			return r - escapedBase
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// plainLines returns the text of a block as plain text lines: one for a
+// paragraph or heading, one per list item or table row, and the lines of
+// code and math as they are. Images have none.
+func (b mdBlock) plainLines() []string {
+	var lines []string
+	switch b.Kind {
+	case blockParagraph, blockHeading:
+		lines = append(lines, inlineText(b.Text))
+	case blockList:
+		for _, item := range b.Items {
+			lines = append(lines, inlineText(item.Text))
+		}
+	case blockTable:
+		if b.Table.HeaderRows > 0 {
+			lines = append(lines, strings.Join(b.Table.Header, "  "))
+		}
+		for _, row := range b.Table.Rows {
+			lines = append(lines, strings.Join(row, "  "))
+		}
+	case blockCode, blockMath:
+		lines = append(lines, b.Lines...)
+	}
+	return lines
+}
+
+// inlineSpan is a run of inline text with the same emphasis.
+type inlineSpan struct {
+	Text               string
+	Bold, Italic, Code bool
+}
+
+// inlineSpans splits Markdown inline text into spans by emphasis and code.
+// Images are dropped, links replaced by their text, and HTML tags removed.
+// A delimiter without a matching one later in the text is kept as text.
+func inlineSpans(s string) []inlineSpan {
+	s = escapeRe.ReplaceAllStringFunc(s, func(m string) string {
+		return string(rune(escapedBase + int(m[1])))
+	})
+	s = imageRe.ReplaceAllString(s, "")
+	s = linkRe.ReplaceAllString(s, "$1")
+	s = htmlBreakRe.ReplaceAllString(s, " ")
+
+	var spans []inlineSpan
+	var cur inlineSpan
+	var text strings.Builder
+	add := func(span inlineSpan, s string) {
+		if !span.Code {
+			s = html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+		}
+		s = strings.Map(func(r rune) rune {
+			if r >= escapedBase && r < escapedBase+0x80 {
+				return r - escapedBase
+			}
+			return r
+		}, s)
+		if s == "" {
+			return
+		}
+		span.Text = s
+		if n := len(spans); n > 0 && spans[n-1].Bold == span.Bold && spans[n-1].Italic == span.Italic && spans[n-1].Code == span.Code {
+			spans[n-1].Text += s
+			return
+		}
+		spans = append(spans, span)
+	}
+	flush := func() {
+		add(cur, text.String())
+		text.Reset()
+	}
+	// opens reports whether the delimiter at i opens a span closed later in
+	// the text, and closes whether it ends the open one.
+	opens := func(i int, delim string) bool {
+		rest := s[i+len(delim):]
+		return rest != "" && rest[0] != ' ' && strings.Contains(rest[1:], delim)
+	}
+	closes := func(i int) bool {
+		return i > 0 && s[i-1] != ' '
+	}
+	isWord := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= 0x80
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end >= 0 {
+				flush()
+				add(inlineSpan{Bold: cur.Bold, Italic: cur.Italic, Code: true}, s[i+1:i+1+end])
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(s[i:], "**") || strings.HasPrefix(s[i:], "__"):
+			if cur.Bold && closes(i) || !cur.Bold && opens(i, s[i:i+2]) {
+				flush()
+				cur.Bold = !cur.Bold
+				i += 2
+				continue
+			}
+		case s[i] == '*' || s[i] == '_':
+			intraword := s[i] == '_' && i > 0 && i+1 < len(s) && isWord(s[i-1]) && isWord(s[i+1])
+			if !intraword && (cur.Italic && closes(i) || !cur.Italic && opens(i, s[i:i+1])) {
+				flush()
+				cur.Italic = !cur.Italic
+				i++
+				continue
+			}
+		}
+		text.WriteByte(s[i])
+		i++
+	}
+	flush()
+	return spans
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMarkdownBlocks(t *testing.T) {
+	md := "# Annual *Report*\n\nFirst line\nsecond line with [a link](https://example.com).\n\n" +
+		"![img-0.jpeg](img-0.jpeg)\n\n- one\n- **two**\n  continued\n  1. nested\n\n" +
+		"| A | B |\n|---|---|\n| 1 | 2 |\n\n```go\nx := 1\n```\n\n$$\nE = mc^2\n$$\n\nLast."
+
+	blocks := markdownBlocks(md)
+
+	var kinds []blockKind
+	for _, b := range blocks {
+		kinds = append(kinds, b.Kind)
+	}
+	want := []blockKind{blockHeading, blockParagraph, blockImage, blockList, blockTable, blockCode, blockMath, blockParagraph}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("expected block kinds %v, got %v", want, kinds)
+	}
+
+	if b := blocks[0]; b.Level != 1 || inlineText(b.Text) != "Annual Report" {
+		t.Errorf("unexpected heading: %+v", b)
+	}
+	if got := inlineText(blocks[1].Text); got != "First line second line with a link." {
+		t.Errorf("unexpected paragraph text %q", got)
+	}
+	if b := blocks[2]; b.ImageID != "img-0.jpeg" {
+		t.Errorf("unexpected image block: %+v", b)
+	}
+
+	items := blocks[3].Items
+	if len(items) != 3 || inlineText(items[1].Text) != "two continued" || !items[2].Ordered || items[2].Depth != 1 {
+		t.Errorf("unexpected list items: %+v", items)
+	}
+	if rows := blocks[4].Table.Rows; len(rows) != 1 || !slices.Equal(rows[0], []string{"1", "2"}) {
+		t.Errorf("unexpected table rows: %q", rows)
+	}
+	if lines := blocks[5].Lines; !slices.Equal(lines, []string{"x := 1"}) {
+		t.Errorf("unexpected code lines: %q", lines)
+	}
+	if lines := blocks[6].plainLines(); !slices.Equal(lines, []string{"$$", "E = mc^2", "$$"}) {
+		t.Errorf("unexpected math lines: %q", lines)
+	}
+}
+
+func TestInlineText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"**bold** and _italic_ and *more*", "bold and italic and more"},
+		{"snake_case_name stays", "snake_case_name stays"},
+		{"`code` &amp; <b>html</b><br>next", "code & html next"},
+		{`escaped \*star\*`, "escaped *star*"},
+		{"see ![img](img-1.png) the [docs](x.md)", "see the docs"},
+	}
+	for _, tt := range tests {
+		if got := inlineText(tt.in); got != tt.want {
+			t.Errorf("inlineText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInlineSpans(t *testing.T) {
+	spans := inlineSpans("Plain **bold _both_** and *it* `a*b` snake_case 5 * 3 \\*x\\* [link](u) &amp;")
+	want := []inlineSpan{
+		{Text: "Plain "},
+		{Text: "bold ", Bold: true},
+		{Text: "both", Bold: true, Italic: true},
+		{Text: " and "},
+		{Text: "it", Italic: true},
+		{Text: " "},
+		{Text: "a*b", Code: true},
+		{Text: " snake_case 5 * 3 *x* link &"},
+	}
+	if !slices.Equal(spans, want) {
+		t.Errorf("unexpected spans:\n got %+v\nwant %+v", spans, want)
+	}
+}
@@ -0,0 +1,493 @@
+// Package ocrtest provides a fake Mistral OCR API server for tests, so that
+// OCR clients can be tested without network access or an API key.
+//
+// The server answers OCR requests with a canned response, or one generated
+// from the request, and supports the Files API used for large documents.
+// Responses can be scripted to fail, be slow, or be cut off, and every
+// request is recorded for assertions:
+//
+//	server := ocrtest.New(t)
+//	server.RespondFixture("testdata/invoice.json")
+//	server.Script(ocrtest.RateLimited("1"), ocrtest.Fail(http.StatusInternalServerError))
+//	server.ExpectModel("mistral-ocr-latest")
+//	server.ExpectMIMEType("application/pdf")
+//
+//	// Point the client at server.URL, then inspect server.Requests().
+package ocrtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// OCRRequest is the body of an OCR request, as the server received it.
+type OCRRequest struct {
+	Model                    string            `json:"model"`
+	Document                 Document          `json:"document"`
+	Pages                    []int             `json:"pages,omitempty"`
+	IncludeImageBase64       bool              `json:"include_image_base64"`
+	ImageLimit               int               `json:"image_limit,omitempty"`
+	ImageMinSize             int               `json:"image_min_size,omitempty"`
+	ExtractHeader            bool              `json:"extract_header,omitempty"`
+	ExtractFooter            bool              `json:"extract_footer,omitempty"`
+	TableFormat              string            `json:"table_format,omitempty"`
+	BBoxAnnotationFormat     *AnnotationFormat `json:"bbox_annotation_format,omitempty"`
+	DocumentAnnotationFormat *AnnotationFormat `json:"document_annotation_format,omitempty"`
+}
+
+// Document is the document of an OCR request: a data URL or a signed URL,
+// as a document_url or image_url chunk.
+type Document struct {
+	Type        string `json:"type"`
+	DocumentURL string `json:"document_url,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// URL returns the URL of the document, whichever kind of chunk it is.
+func (d Document) URL() string {
+	if d.Type == "image_url" {
+		return d.ImageURL
+	}
+	return d.DocumentURL
+}
+
+// AnnotationFormat is the JSON schema requested for an annotation.
+type AnnotationFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+	} `json:"json_schema"`
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+
+	// OCR is the decoded body of requests to /ocr.
+	OCR *OCRRequest
+
+	mimeType string
+}
+
+// MIMEType returns the type of the document of an OCR request: the type of
+// a data URL, or the detected type of a file uploaded to the server. It is
+// empty for other requests.
+func (r Request) MIMEType() string {
+	return r.mimeType
+}
+
+// Response is a scripted response to an OCR request.
+type Response struct {
+	// Status is the HTTP status, 200 if zero.
+	Status int
+	Header http.Header
+
+	// Body is the response body. Nil means the server's canned or
+	// generated response.
+	Body []byte
+
+	// Delay is how long to wait before responding, or until the request
+	// is cancelled.
+	Delay time.Duration
+
+	// TruncateAt, if positive, cuts the body off after this many bytes and
+	// closes the connection.
+	TruncateAt int
+}
+
+// RateLimited returns a 429 response with the given Retry-After header,
+// such as "1" or an HTTP date.
+func RateLimited(retryAfter string) Response {
+	return Response{
+		Status: http.StatusTooManyRequests,
+		Header: http.Header{"Retry-After": {retryAfter}},
+		Body:   []byte(`{"message": "Rate limit exceeded"}`),
+	}
+}
+
+// Fail returns an error response with the given status, such as 500.
+func Fail(status int) Response {
+	return Response{Status: status, Body: fmt.Appendf(nil, `{"message": %q}`, http.StatusText(status))}
+}
+
+// Slow returns the normal response after a delay.
+func Slow(delay time.Duration) Response {
+	return Response{Delay: delay}
+}
+
+// Truncated returns the normal response cut off after n bytes.
+func Truncated(n int) Response {
+	return Response{TruncateAt: n}
+}
+
+// Server is a fake Mistral OCR API server.
+type Server struct {
+	// URL is the base URL of the API, to use in place of
+	// https://api.mistral.ai/v1.
+	URL string
+
+	t      testing.TB
+	server *httptest.Server
+
+	mu       sync.Mutex
+	body     []byte
+	script   []Response
+	expect   []func(Request) error
+	requests []Request
+	files    map[string][]byte
+	uploads  int
+}
+
+// New starts a server, which is closed when the test finishes. Failed
+// expectations are reported to t.
+func New(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{t: t, files: map[string][]byte{}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// Close shuts the server down. It is also closed when the test finishes.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Respond sets the body of successful OCR responses. Without one, the
+// server generates a page for each requested page.
+func (s *Server) Respond(body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body = body
+}
+
+// RespondJSON sets the response to v encoded as JSON.
+func (s *Server) RespondJSON(v any) {
+	s.t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		s.t.Fatalf("ocrtest: encoding response: %v", err)
+	}
+	s.Respond(body)
+}
+
+// RespondFixture sets the response to the contents of a JSON file, such as
+// one in testdata recorded from the API.
+func (s *Server) RespondFixture(path string) {
+	s.t.Helper()
+	body, err := os.ReadFile(path)
+	if err != nil {
+		s.t.Fatalf("ocrtest: reading fixture: %v", err)
+	}
+	if !json.Valid(body) {
+		s.t.Fatalf("ocrtest: fixture %s is not valid JSON", path)
+	}
+	s.Respond(body)
+}
+
+// Script queues responses for the next OCR requests, in order. Once they
+// are used up, requests get the normal response.
+func (s *Server) Script(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, responses...)
+}
+
+// Expect adds a check of every OCR request. Requests that fail it are
+// reported to the test and answered with 400 Bad Request.
+func (s *Server) Expect(check func(Request) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expect = append(s.expect, check)
+}
+
+// ExpectModel checks that OCR requests are for the given model.
+func (s *Server) ExpectModel(model string) {
+	s.Expect(func(r Request) error {
+		if r.OCR.Model != model {
+			return fmt.Errorf("expected model %q, got %q", model, r.OCR.Model)
+		}
+		return nil
+	})
+}
+
+// ExpectMIMEType checks that OCR requests send a document of the given type
+// as a data URL.
+func (s *Server) ExpectMIMEType(mimeType string) {
+	s.Expect(func(r Request) error {
+		if got := r.MIMEType(); got != mimeType {
+			return fmt.Errorf("expected a %s document, got %q", mimeType, got)
+		}
+		return nil
+	})
+}
+
+// ExpectBBoxAnnotation checks that OCR requests ask for image annotations
+// with the named schema, or none if name is empty.
+func (s *Server) ExpectBBoxAnnotation(name string) {
+	s.Expect(func(r Request) error {
+		return checkAnnotation("bbox_annotation_format", r.OCR.BBoxAnnotationFormat, name)
+	})
+}
+
+// ExpectDocumentAnnotation checks that OCR requests ask for a document
+// annotation with the named schema, or none if name is empty.
+func (s *Server) ExpectDocumentAnnotation(name string) {
+	s.Expect(func(r Request) error {
+		return checkAnnotation("document_annotation_format", r.OCR.DocumentAnnotationFormat, name)
+	})
+}
+
+func checkAnnotation(field string, format *AnnotationFormat, name string) error {
+	switch {
+	case name == "" && format != nil:
+		return fmt.Errorf("expected no %s, got schema %q", field, format.JSONSchema.Name)
+	case name != "" && format == nil:
+		return fmt.Errorf("expected %s with schema %q, got none", field, name)
+	case name != "" && (format.Type != "json_schema" || format.JSONSchema.Name != name):
+		return fmt.Errorf("expected %s with schema %q, got %s %q", field, name, format.Type, format.JSONSchema.Name)
+	}
+	return nil
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// OCRRequests returns the OCR requests received so far, in order.
+func (s *Server) OCRRequests() []Request {
+	var ocr []Request
+	for _, r := range s.Requests() {
+		if r.OCR != nil {
+			ocr = append(ocr, r)
+		}
+	}
+	return ocr
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/ocr":
+		var ocr OCRRequest
+		if err := json.Unmarshal(body, &ocr); err != nil {
+			s.record(req)
+			s.t.Errorf("ocrtest: invalid OCR request: %v", err)
+			http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		req.OCR = &ocr
+		req.mimeType = s.documentType(ocr.Document.URL())
+		s.record(req)
+		s.handleOCR(w, r, req)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/files":
+		s.record(req)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		s.handleUpload(w, r)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/files/"):
+		s.record(req)
+		id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/files/"), "/")
+		s.mu.Lock()
+		data, ok := s.files[id]
+		s.mu.Unlock()
+		switch {
+		case !ok:
+			http.Error(w, `{"message": "file not found"}`, http.StatusNotFound)
+		case rest == "url":
+			writeJSON(w, map[string]string{"url": s.URL + "/files/" + id + "/content"})
+		case rest == "content":
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/files/"):
+		s.record(req)
+		id := strings.TrimPrefix(r.URL.Path, "/files/")
+		s.mu.Lock()
+		delete(s.files, id)
+		s.mu.Unlock()
+		writeJSON(w, map[string]any{"id": id, "deleted": true})
+
+	default:
+		s.record(req)
+		http.NotFound(w, r)
+	}
+}
+
+// documentType returns the type of the document at url.
+func (s *Server) documentType(url string) string {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		mimeType, _, _ := strings.Cut(rest, ";")
+		return mimeType
+	}
+
+	id, ok := strings.CutPrefix(url, s.URL+"/files/")
+	if !ok {
+		return ""
+	}
+	s.mu.Lock()
+	data, ok := s.files[strings.TrimSuffix(id, "/content")]
+	s.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return mimeType
+}
+
+func (s *Server) record(req Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+}
+
+// handleOCR answers an OCR request with the next scripted response, or the
+// normal one.
+func (s *Server) handleOCR(w http.ResponseWriter, r *http.Request, req Request) {
+	s.mu.Lock()
+	expect := s.expect
+	var resp Response
+	if len(s.script) > 0 {
+		resp, s.script = s.script[0], s.script[1:]
+	}
+	canned := s.body
+	s.mu.Unlock()
+
+	for _, check := range expect {
+		if err := check(req); err != nil {
+			s.t.Errorf("ocrtest: %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, fmt.Sprintf(`{"message": %q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	body := resp.Body
+	if body == nil {
+		body = canned
+	}
+	if body == nil {
+		body = generateResponse(req.OCR)
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp.TruncateAt <= 0 || resp.TruncateAt >= len(body) {
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+
+	// Announce the whole body, send part of it, and drop the connection.
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body[:resp.TruncateAt])
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// handleUpload stores a file uploaded to the Files API.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, `{"message": "missing file"}`, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, `{"message": "reading file"}`, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.uploads++
+	id := fmt.Sprintf("file-%d", s.uploads)
+	s.files[id] = data
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]any{"id": id, "purpose": r.FormValue("purpose"), "bytes": len(data)})
+}
+
+// generateResponse returns a response with a page for each requested page,
+// or a single page for the whole document.
+func generateResponse(req *OCRRequest) []byte {
+	pages := req.Pages
+	if len(pages) == 0 {
+		pages = []int{0}
+	}
+
+	type page struct {
+		Index      int            `json:"index"`
+		Markdown   string         `json:"markdown"`
+		Images     []any          `json:"images"`
+		Dimensions map[string]int `json:"dimensions"`
+	}
+	resp := struct {
+		Pages     []page         `json:"pages"`
+		Model     string         `json:"model"`
+		UsageInfo map[string]int `json:"usage_info"`
+	}{
+		Model:     req.Model,
+		UsageInfo: map[string]int{"pages_processed": len(pages), "doc_size_bytes": len(req.Document.URL()) * 3 / 4},
+	}
+	for _, index := range pages {
+		resp.Pages = append(resp.Pages, page{
+			Index:      index,
+			Markdown:   fmt.Sprintf("# Page %d\n\nText of page %d.", index+1, index+1),
+			Images:     []any{},
+			Dimensions: map[string]int{"dpi": 200, "width": 1700, "height": 2200},
+		})
+	}
+
+	body, _ := json.Marshal(resp)
+	return body
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
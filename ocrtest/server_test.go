@@ -0,0 +1,182 @@
+package ocrtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingTB records the errors reported by the server instead of failing
+// the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func postOCR(t *testing.T, url string, req map[string]any) (*http.Response, []byte, error) {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url+"/ocr", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+func pdfRequest(pages ...int) map[string]any {
+	return map[string]any{
+		"model":    "mistral-ocr-latest",
+		"document": map[string]string{"type": "document_url", "document_url": "data:application/pdf;base64,JVBERi0="},
+		"pages":    pages,
+	}
+}
+
+func TestServer_GeneratedResponse(t *testing.T) {
+	server := New(t)
+
+	_, body, err := postOCR(t, server.URL, pdfRequest(3, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Pages []struct {
+			Index    int    `json:"index"`
+			Markdown string `json:"markdown"`
+		} `json:"pages"`
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Pages) != 2 || resp.Pages[0].Index != 3 || resp.Pages[1].Markdown != "# Page 5\n\nText of page 5." ||
+		resp.Model != "mistral-ocr-latest" {
+		t.Errorf("expected a page for each requested page, got %s", body)
+	}
+
+	requests := server.OCRRequests()
+	if len(requests) != 1 || requests[0].MIMEType() != "application/pdf" || requests[0].OCR.Pages[1] != 4 {
+		t.Errorf("unexpected recorded requests: %+v", requests)
+	}
+}
+
+func TestServer_Script(t *testing.T) {
+	server := New(t)
+	server.RespondFixture("../testdata/invoice.json")
+	server.Script(RateLimited("2"), Fail(http.StatusInternalServerError), Truncated(20))
+
+	resp, _, err := postOCR(t, server.URL, pdfRequest())
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("expected 429 with Retry-After, got %v (%v)", resp, err)
+	}
+	resp, _, err = postOCR(t, server.URL, pdfRequest())
+	if err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %v (%v)", resp, err)
+	}
+	if _, body, err := postOCR(t, server.URL, pdfRequest()); err == nil || len(body) != 20 {
+		t.Errorf("expected a body cut off after 20 bytes, got %d bytes (%v)", len(body), err)
+	}
+	if _, body, err := postOCR(t, server.URL, pdfRequest()); err != nil || !strings.Contains(string(body), "Invoice 2024-001") {
+		t.Errorf("expected the fixture once the script is used up, got %s (%v)", body, err)
+	}
+	if n := len(server.Requests()); n != 4 {
+		t.Errorf("expected 4 recorded requests, got %d", n)
+	}
+}
+
+func TestServer_Slow(t *testing.T) {
+	server := New(t)
+	server.Script(Slow(time.Minute))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/ocr", strings.NewReader(`{"model": "m"}`))
+	if _, err := http.DefaultClient.Do(req); err == nil || ctx.Err() == nil {
+		t.Errorf("expected the request to time out, got %v", err)
+	}
+}
+
+func TestServer_Expectations(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	server := New(tb)
+	server.ExpectModel("mistral-ocr-2505")
+	server.ExpectMIMEType("image/png")
+	server.ExpectBBoxAnnotation("image_metadata")
+	server.ExpectDocumentAnnotation("")
+
+	req := pdfRequest()
+	req["document_annotation_format"] = map[string]any{"type": "json_schema", "json_schema": map[string]any{"name": "invoice"}}
+	resp, _, err := postOCR(t, server.URL, req)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a request that fails expectations, got %v (%v)", resp, err)
+	}
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], `expected model "mistral-ocr-2505"`) {
+		t.Errorf("expected the first failed expectation reported, got %q", tb.errors)
+	}
+
+	req = map[string]any{
+		"model":                  "mistral-ocr-2505",
+		"document":               map[string]string{"type": "image_url", "image_url": "data:image/png;base64,iVBORw=="},
+		"bbox_annotation_format": map[string]any{"type": "json_schema", "json_schema": map[string]any{"name": "image_metadata"}},
+	}
+	tb.errors = nil
+	if resp, _, err := postOCR(t, server.URL, req); err != nil || resp.StatusCode != http.StatusOK || len(tb.errors) != 0 {
+		t.Errorf("expected a request that meets expectations to succeed, got %v (%v, %q)", resp, err, tb.errors)
+	}
+}
+
+func TestServer_Files(t *testing.T) {
+	server := New(t)
+	server.ExpectMIMEType("application/pdf")
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("purpose", "ocr")
+	part, _ := w.CreateFormFile("file", "big.pdf")
+	part.Write([]byte("%PDF-1.7\n..."))
+	w.Close()
+	resp, err := http.Post(server.URL+"/files", w.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upload struct{ ID string }
+	json.NewDecoder(resp.Body).Decode(&upload)
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/files/" + upload.ID + "/url?expiry=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed struct{ URL string }
+	json.NewDecoder(resp.Body).Decode(&signed)
+	resp.Body.Close()
+
+	req := map[string]any{"model": "m", "document": map[string]string{"type": "document_url", "document_url": signed.URL}}
+	if resp, _, err := postOCR(t, server.URL, req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the uploaded PDF to be accepted, got %v (%v)", resp, err)
+	}
+
+	del, _ := http.NewRequest(http.MethodDelete, server.URL+"/files/"+upload.ID, nil)
+	if resp, err := http.DefaultClient.Do(del); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the file to be deleted, got %v (%v)", resp, err)
+	}
+	if resp, err := http.Get(signed.URL); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the deleted file to be gone, got %v (%v)", resp, err)
+	}
+}
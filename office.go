@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MIME types of the zip based document formats.
+const (
+	mimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimePPTX = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	mimeODT  = "application/vnd.oasis.opendocument.text"
+	mimeEPUB = "application/epub+zip"
+)
+
+var (
+	docxPagesRe = regexp.MustCompile(`<Pages>(\d+)</Pages>`)
+	odtPagesRe  = regexp.MustCompile(`meta:page-count="(\d+)"`)
+	pptxSlideRe = regexp.MustCompile(`^ppt/slides/slide\d+\.xml$`)
+)
+
+// zipDocumentType returns the MIME type of an Office Open XML, OpenDocument,
+// or EPUB file, or "" for other zip files.
+func zipDocumentType(data []byte) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "mimetype":
+			// OpenDocument and EPUB store their type in the first entry.
+			switch strings.TrimSpace(string(readZipEntry(f, 128))) {
+			case mimeODT:
+				return mimeODT
+			case mimeEPUB:
+				return mimeEPUB
+			}
+		case strings.HasPrefix(f.Name, "word/"):
+			return mimeDOCX
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return mimePPTX
+		case strings.HasPrefix(f.Name, "xl/"):
+			return mimeXLSX
+		}
+	}
+	return ""
+}
+
+// zipDocumentPages returns the page count recorded in a DOCX or ODT file's
+// metadata, or the number of slides of a PPTX file. It returns 0 if the
+// count is not known, as for EPUB.
+func zipDocumentPages(mimeType string, data []byte) int {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0
+	}
+
+	var metadata string
+	var re *regexp.Regexp
+	switch mimeType {
+	case mimePPTX:
+		slides := 0
+		for _, f := range zr.File {
+			if pptxSlideRe.MatchString(f.Name) {
+				slides++
+			}
+		}
+		return slides
+	case mimeDOCX:
+		metadata, re = "docProps/app.xml", docxPagesRe
+	case mimeODT:
+		metadata, re = "meta.xml", odtPagesRe
+	default:
+		return 0
+	}
+
+	for _, f := range zr.File {
+		if f.Name != metadata {
+			continue
+		}
+		if m := re.FindSubmatch(readZipEntry(f, 1<<20)); m != nil {
+			n, _ := strconv.Atoi(string(m[1]))
+			return n
+		}
+	}
+	return 0
+}
+
+// readZipEntry reads up to limit bytes of a zip entry.
+func readZipEntry(f *zip.File, limit int64) []byte {
+	rc, err := f.Open()
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(rc, limit))
+	return data
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultOpenAIBaseURL is the endpoint of the openai provider unless
+// -base-url is set.
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// openAIPagePrompt asks for the transcription of one page. It is followed by
+// the page number and the number of pages.
+const openAIPagePrompt = `You are an OCR engine. Transcribe the attached page, page %d of %d of a document.
+
+Write the text of the page as Markdown in reading order: headings as #
+headings, lists as - or 1. lists, tables as Markdown tables, and equations as
+LaTeX between $ signs. Don't summarize, translate, correct, or describe the
+page; leave out text that isn't on it.`
+
+// openAIImagesPrompt is added for page images that can be decoded, whose
+// figures are cropped from the image.
+const openAIImagesPrompt = `
+
+List the figures, photos, charts, and diagrams on the page in "images", with
+their bounding boxes in pixels of the %dx%d image, and put a Markdown image
+link ![img-N.png](img-N.png) where each appears in the text, numbering them
+from 0.`
+
+// openAIHeadersPrompt is added when running headers and footers are
+// requested separately.
+const openAIHeadersPrompt = `
+
+Put running headers, footers, and page numbers in "header" and "footer"
+instead of the Markdown.`
+
+// openAIDocumentPrompt asks for the document annotation.
+const openAIDocumentPrompt = `Extract the requested data from the attached pages of a document. Use
+only information in the document.`
+
+// OpenAIProvider runs OCR with a vision model behind an OpenAI-compatible
+// chat completions endpoint, such as a locally hosted model server. Each
+// page is transcribed by its own request, with the answer constrained by a
+// JSON schema, and the results are normalized to the pages and images of
+// the Mistral OCR API.
+//
+// Only images and scanned PDFs are supported: most servers accept images
+// but not PDFs, so each PDF page is sent as its scanned image. Figures are
+// cropped from the page images. Tables stay in the Markdown.
+type OpenAIProvider struct {
+	client *Client
+}
+
+// NewOpenAIProvider creates a provider for the OpenAI-compatible endpoint
+// set with WithBaseURL, by default the OpenAI API. A model must be set with
+// WithModel. The API key may be empty for servers that don't need one.
+func NewOpenAIProvider(apiKey string, opts ...ClientOption) *OpenAIProvider {
+	opts = append([]ClientOption{WithBaseURL(defaultOpenAIBaseURL), WithModel("")}, opts...)
+	return &OpenAIProvider{client: NewClient(apiKey, opts...)}
+}
+
+// chatRequest is the request body of the chat completions endpoint.
+type chatRequest struct {
+	Model          string            `json:"model"`
+	Messages       []chatMessage     `json:"messages"`
+	ResponseFormat *AnnotationFormat `json:"response_format,omitempty"`
+	Temperature    float64           `json:"temperature"`
+}
+
+type chatMessage struct {
+	Role    string     `json:"role"`
+	Content []chatPart `json:"content"`
+}
+
+// chatPart is a part of a message: text or an image.
+type chatPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+// imagePart returns a message part with a JPEG, PNG, GIF, or WebP image.
+func imagePart(data []byte) chatPart {
+	return chatPart{Type: "image_url", ImageURL: &chatImageURL{URL: dataURL(detectMIMEType(data), data)}}
+}
+
+// chatResponse is the response of the chat completions endpoint.
+type chatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// openAIPage is the answer for a page.
+type openAIPage struct {
+	Markdown string        `json:"markdown"`
+	Header   string        `json:"header"`
+	Footer   string        `json:"footer"`
+	Images   []openAIImage `json:"images"`
+}
+
+type openAIImage struct {
+	TopLeftX     int `json:"top_left_x"`
+	TopLeftY     int `json:"top_left_y"`
+	BottomRightX int `json:"bottom_right_x"`
+	BottomRightY int `json:"bottom_right_y"`
+	Annotation   any `json:"annotation"`
+}
+
+// ProcessBytesStream sends each page of a document to the endpoint,
+// opts.Concurrency at a time, and calls fn with the pages in order. PDF
+// pages are sent as their scanned images. The document annotation, if
+// requested, is extracted by one more request with all pages.
+func (p *OpenAIProvider) ProcessBytesStream(ctx context.Context, name string, docData []byte, opts OCROptions, fn func(Page) error) (*OCRResponse, error) {
+	if p.client.model == "" {
+		return nil, fmt.Errorf("no model set for the openai provider")
+	}
+
+	info := inspectDocument(name, docData)
+	if err := info.Validate(); err != nil {
+		return nil, err
+	}
+
+	// The images of the pages, each sent on its own, so that the model only
+	// sees the page it transcribes.
+	var images [][]byte
+	switch {
+	case info.MIMEType == "application/pdf":
+		var err error
+		if images, err = scanPageImages(docData); err != nil {
+			return nil, fmt.Errorf("%s: the openai provider only supports scanned PDFs: %w", info.Name, err)
+		}
+	case strings.HasPrefix(info.MIMEType, "image/"):
+		images = [][]byte{docData}
+	default:
+		return nil, fmt.Errorf("%s: the openai provider supports PDF and image documents, not %s", info.Name, info.MIMEType)
+	}
+	pageCount := len(images)
+
+	// Pages are requested concurrently, but passed to fn in order. On
+	// return, the remaining requests are cancelled and waited for.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		page Page
+		err  error
+		done chan struct{}
+	}
+	results := make([]result, pageCount)
+	sem := make(chan struct{}, opts.concurrency())
+	for i := range results {
+		results[i].done = make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(results[i].done)
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+			results[i].page, results[i].err = p.page(ctx, images[i], i, pageCount, opts)
+		}()
+	}
+
+	for i := range results {
+		<-results[i].done
+		if err := results[i].err; err != nil {
+			if pageCount > 1 {
+				err = fmt.Errorf("processing page %d: %w", i+1, err)
+			}
+			return nil, err
+		}
+		if err := fn(results[i].page); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &OCRResponse{
+		Model:     p.client.model,
+		UsageInfo: &UsageInfo{PagesProcessed: pageCount, DocSizeBytes: len(docData)},
+	}
+	if opts.DocumentSchema != nil {
+		parts := []chatPart{{Type: "text", Text: openAIDocumentPrompt}}
+		for _, data := range images {
+			parts = append(parts, imagePart(data))
+		}
+		annotation, err := p.chat(ctx, parts, *opts.DocumentSchema)
+		if err != nil {
+			return nil, fmt.Errorf("extracting document annotation: %w", err)
+		}
+		resp.DocumentAnnotation = annotation
+	}
+	return resp, nil
+}
+
+// page transcribes the page with the given index from its image. Figures
+// are cropped from the image if it can be decoded.
+func (p *OpenAIProvider) page(ctx context.Context, data []byte, index, pageCount int, opts OCROptions) (Page, error) {
+	img, _, _ := image.Decode(bytes.NewReader(data))
+	prompt := fmt.Sprintf(openAIPagePrompt, index+1, pageCount)
+	wantImages := img != nil && opts.includeImages()
+	if wantImages {
+		bounds := img.Bounds()
+		prompt += fmt.Sprintf(openAIImagesPrompt, bounds.Dx(), bounds.Dy())
+	}
+	if opts.ExtractHeaders {
+		prompt += openAIHeadersPrompt
+	}
+
+	content, err := p.chat(ctx, []chatPart{{Type: "text", Text: prompt}, imagePart(data)}, openAIPageSchema(wantImages, opts))
+	if err != nil {
+		return Page{}, err
+	}
+	var answer openAIPage
+	if err := json.Unmarshal([]byte(content), &answer); err != nil {
+		return Page{}, fmt.Errorf("decoding answer: %w", err)
+	}
+
+	page := Page{Index: index, Markdown: answer.Markdown, Header: answer.Header, Footer: answer.Footer, Images: []Image{}}
+	if img == nil {
+		return page, nil
+	}
+
+	bounds := img.Bounds()
+	page.Dimensions = &PageDimensions{Width: bounds.Dx(), Height: bounds.Dy()}
+	if !wantImages {
+		return page, nil
+	}
+	for i, region := range answer.Images {
+		width, height := region.BottomRightX-region.TopLeftX, region.BottomRightY-region.TopLeftY
+		if opts.ImageLimit > 0 && len(page.Images) >= opts.ImageLimit || width < opts.ImageMinSize || height < opts.ImageMinSize {
+			continue
+		}
+		extracted := Image{
+			ID:           fmt.Sprintf("img-%d.png", i),
+			TopLeftX:     region.TopLeftX,
+			TopLeftY:     region.TopLeftY,
+			BottomRightX: region.BottomRightX,
+			BottomRightY: region.BottomRightY,
+		}
+		if data, err := cropPNG(img, extracted); err == nil {
+			extracted.ImageBase64 = dataURL("image/png", data)
+		}
+		if region.Annotation != nil {
+			// The Mistral API returns annotations as JSON strings.
+			data, err := json.Marshal(region.Annotation)
+			if err != nil {
+				return Page{}, fmt.Errorf("encoding image annotation: %w", err)
+			}
+			extracted.ImageAnnotation = string(data)
+		}
+		page.Images = append(page.Images, extracted)
+	}
+	return page, nil
+}
+
+// openAIPageSchema returns the schema of the answer for a page.
+func openAIPageSchema(images bool, opts OCROptions) JSONSchema {
+	properties := map[string]any{
+		"markdown": map[string]any{"type": "string", "description": "The text of the page as Markdown"},
+	}
+	required := []string{"markdown"}
+	if opts.ExtractHeaders {
+		properties["header"] = map[string]any{"type": "string", "description": "Running header of the page"}
+		properties["footer"] = map[string]any{"type": "string", "description": "Running footer and page number of the page"}
+		required = append(required, "header", "footer")
+	}
+	if images {
+		imageProperties := map[string]any{
+			"top_left_x":     map[string]any{"type": "integer"},
+			"top_left_y":     map[string]any{"type": "integer"},
+			"bottom_right_x": map[string]any{"type": "integer"},
+			"bottom_right_y": map[string]any{"type": "integer"},
+		}
+		imageRequired := []string{"top_left_x", "top_left_y", "bottom_right_x", "bottom_right_y"}
+		if opts.ExtractImageMetadata {
+			imageProperties["annotation"] = ImageMetadataSchema.Schema
+			imageRequired = append(imageRequired, "annotation")
+		}
+		properties["images"] = map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":       "object",
+				"properties": imageProperties,
+				"required":   imageRequired,
+			},
+		}
+		required = append(required, "images")
+	}
+
+	return JSONSchema{
+		Name: "page",
+		Schema: map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		},
+	}
+}
+
+// chat sends a user message and returns the answer, which is constrained to
+// schema.
+func (p *OpenAIProvider) chat(ctx context.Context, content []chatPart, schema JSONSchema) (string, error) {
+	body, err := json.Marshal(chatRequest{
+		Model:          p.client.model,
+		Messages:       []chatMessage{{Role: "user", Content: content}},
+		ResponseFormat: &AnnotationFormat{Type: "json_schema", JSONSchema: schema},
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshaling request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.client.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp chatResponse
+	if err := p.client.do(req, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no answer in response")
+	}
+	if reason := resp.Choices[0].FinishReason; reason == "length" {
+		return "", fmt.Errorf("answer cut off at the token limit")
+	}
+
+	// Some servers wrap the JSON in a code fence despite the schema.
+	answer := strings.TrimSpace(resp.Choices[0].Message.Content)
+	if fenced, ok := strings.CutPrefix(answer, "```"); ok {
+		fenced = strings.TrimPrefix(fenced, "json")
+		answer = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(fenced), "```"))
+	}
+	return answer, nil
+}
+
+// cropPNG returns the region of img covered by the bounding box of an
+// extracted image, encoded as PNG.
+func cropPNG(img image.Image, region Image) ([]byte, error) {
+	r := image.Rect(region.TopLeftX, region.TopLeftY, region.BottomRightX, region.BottomRightY).
+		Add(img.Bounds().Min).Intersect(img.Bounds())
+	if r.Empty() {
+		return nil, fmt.Errorf("empty image region")
+	}
+
+	cropped := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, r.Min, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, cropped); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
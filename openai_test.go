@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// chatServer returns a fake chat completions endpoint that answers with the
+// result of answer, and records the requests it receives.
+func chatServer(t *testing.T, answer func(req chatRequest) string) (*httptest.Server, *[]chatRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []chatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		resp := map[string]any{"choices": []any{map[string]any{
+			"message":       map[string]any{"role": "assistant", "content": answer(req)},
+			"finish_reason": "stop",
+		}}}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestOpenAIProvider_PDF(t *testing.T) {
+	server, requests := chatServer(t, func(req chatRequest) string {
+		prompt := req.Messages[0].Content[0].Text
+		if req.ResponseFormat.JSONSchema.Name == "invoice" {
+			return "```json\n{\"total\": 12}\n```"
+		}
+		var page int
+		fmt.Sscanf(prompt[strings.Index(prompt, "page "):], "page %d", &page)
+		answer, _ := json.Marshal(map[string]string{"markdown": fmt.Sprintf("Page %d", page), "header": "Report", "footer": "1"})
+		return string(answer)
+	})
+
+	provider := NewOpenAIProvider("", WithBaseURL(server.URL), WithModel("vision-model"))
+	opts := OCROptions{Concurrency: 2, ExtractHeaders: true, DocumentSchema: &JSONSchema{Name: "invoice", Schema: map[string]any{"type": "object"}}}
+
+	var pages []Page
+	scan, _ := imageXObject(testImage(t, 8, 6, encodePNG))
+	resp, err := provider.ProcessBytesStream(context.Background(), "report.pdf", buildScanPDF(scan, scan, scan), opts, func(page Page) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ProcessBytesStream failed: %v", err)
+	}
+
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	for i, page := range pages {
+		if page.Index != i || page.Markdown != fmt.Sprintf("Page %d", i+1) || page.Header != "Report" {
+			t.Errorf("expected page %d in order, got %+v", i, page)
+		}
+	}
+	if resp.Model != "vision-model" || resp.UsageInfo.PagesProcessed != 3 || resp.DocumentAnnotation != `{"total": 12}` {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if len(*requests) != 4 {
+		t.Fatalf("expected a request per page and one for the annotation, got %d", len(*requests))
+	}
+	for _, req := range *requests {
+		parts := req.Messages[0].Content
+		if req.Model != "vision-model" || req.ResponseFormat.Type != "json_schema" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		// Each page is sent on its own as a PNG; the annotation request has
+		// all of them.
+		wantParts := 2
+		if req.ResponseFormat.JSONSchema.Name != "page" {
+			wantParts = 4
+		} else if !strings.Contains(parts[0].Text, `"header" and "footer"`) {
+			t.Errorf("expected headers requested in the prompt: %s", parts[0].Text)
+		}
+		if len(parts) != wantParts {
+			t.Fatalf("expected %d parts, got %+v", wantParts, parts)
+		}
+		for _, part := range parts[1:] {
+			if part.Type != "image_url" || !strings.HasPrefix(part.ImageURL.URL, "data:image/png;base64,") {
+				t.Errorf("expected a page image as an image_url part, got %+v", part)
+			}
+		}
+	}
+}
+
+func TestOpenAIProvider_BornDigitalPDF(t *testing.T) {
+	server, requests := chatServer(t, func(chatRequest) string { return `{"markdown": ""}` })
+	provider := NewOpenAIProvider("", WithBaseURL(server.URL), WithModel("vision-model"))
+
+	_, err := provider.ProcessBytesStream(context.Background(), "report.pdf", buildTestPDF(1, false), OCROptions{}, func(Page) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "only supports scanned PDFs: page 1 is not a scanned image") {
+		t.Errorf("expected born-digital PDFs rejected, got %v", err)
+	}
+	if len(*requests) != 0 {
+		t.Errorf("expected no requests, got %d", len(*requests))
+	}
+}
+
+func TestOpenAIProvider_Image(t *testing.T) {
+	server, requests := chatServer(t, func(chatRequest) string {
+		return `{"markdown": "A chart:\n\n![img-0.png](img-0.png)", "images": [
+			{"top_left_x": 2, "top_left_y": 1, "bottom_right_x": 6, "bottom_right_y": 4, "annotation": {"type": "chart"}},
+			{"top_left_x": 0, "top_left_y": 0, "bottom_right_x": 1, "bottom_right_y": 1, "annotation": {"type": "other"}}
+		]}`
+	})
+
+	provider := NewOpenAIProvider("test-key", WithBaseURL(server.URL), WithModel("vision-model"))
+	opts := OCROptions{ExtractImageMetadata: true, ImageMinSize: 2}
+
+	var pages []Page
+	_, err := provider.ProcessBytesStream(context.Background(), "chart.png", testImage(t, 8, 6, encodePNG), opts, func(page Page) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ProcessBytesStream failed: %v", err)
+	}
+
+	req := (*requests)[0]
+	if part := req.Messages[0].Content[1]; part.Type != "image_url" || !strings.HasPrefix(part.ImageURL.URL, "data:image/png;base64,") {
+		t.Errorf("expected the image as an image_url part, got %+v", part)
+	}
+	schema, _ := json.Marshal(req.ResponseFormat.JSONSchema.Schema)
+	if !strings.Contains(string(schema), `"annotation"`) || !strings.Contains(req.Messages[0].Content[0].Text, "8x6 image") {
+		t.Errorf("expected image regions with annotations requested, got %s", schema)
+	}
+
+	page := pages[0]
+	if page.Dimensions == nil || page.Dimensions.Width != 8 || len(page.Images) != 1 {
+		t.Fatalf("expected the small image skipped, got %+v", page)
+	}
+	img := page.Images[0]
+	if img.ID != "img-0.png" || img.ImageAnnotation != `{"type":"chart"}` {
+		t.Errorf("unexpected image: %+v", img)
+	}
+	data, err := img.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cropped, err := png.Decode(bytes.NewReader(data))
+	if err != nil || cropped.Bounds().Dx() != 4 || cropped.Bounds().Dy() != 3 {
+		t.Errorf("expected a 4x3 crop, got %v (%v)", cropped.Bounds(), err)
+	}
+}
+
+func TestOpenAIProvider_Errors(t *testing.T) {
+	server, _ := chatServer(t, func(chatRequest) string { return "not json" })
+
+	provider := NewOpenAIProvider("", WithBaseURL(server.URL))
+	if _, err := provider.ProcessBytesStream(context.Background(), "a.png", testImage(t, 2, 2, encodePNG), OCROptions{}, func(Page) error { return nil }); err == nil ||
+		!strings.Contains(err.Error(), "no model") {
+		t.Errorf("expected an error without a model, got %v", err)
+	}
+
+	provider = NewOpenAIProvider("", WithBaseURL(server.URL), WithModel("m"))
+	if _, err := provider.ProcessBytesStream(context.Background(), "a.png", testImage(t, 2, 2, encodePNG), OCROptions{}, func(Page) error { return nil }); err == nil ||
+		!strings.Contains(err.Error(), "decoding answer") {
+		t.Errorf("expected an error for an invalid answer, got %v", err)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{args: nil, want: "*main.Client"},
+		{args: []string{"-provider", "openai", "-model", "llava", "-base-url", "http://localhost:8000/v1"}, want: "*main.OpenAIProvider"},
+		{args: []string{"-provider", "openai"}, wantErr: "needs -model"},
+		{args: []string{"-provider", "other"}, wantErr: "unknown provider"},
+	} {
+		cfg, err := loadTestConfig(t, tt.args, nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provider, err := newProvider(cfg, "key")
+		if got := fmt.Sprintf("%T", provider); tt.wantErr == "" && (err != nil || got != tt.want) {
+			t.Errorf("%v: expected %s, got %s (%v)", tt.args, tt.want, got, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%v: expected error containing %q, got %v", tt.args, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// outputSink stores the files written for processed documents. Names are
+// slash-separated paths relative to the root of the sink.
+type outputSink interface {
+	// WriteFile stores data under name and returns where it was written,
+	// for progress messages.
+	WriteFile(name string, data []byte) (string, error)
+	Close() error
+}
+
+// dirSink writes files below a directory.
+type dirSink struct {
+	dir string
+}
+
+func (s dirSink) WriteFile(name string, data []byte) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("creating output directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s dirSink) Close() error {
+	return nil
+}
+
+// tarSink writes files as a tar stream.
+type tarSink struct {
+	name string
+	w    io.WriteCloser
+	tw   *tar.Writer
+	now  time.Time
+}
+
+// newTarSink returns a sink that writes a tar stream to w, which is closed
+// with the sink. name identifies the stream in progress messages.
+func newTarSink(name string, w io.WriteCloser) *tarSink {
+	return &tarSink{name: name, w: w, tw: tar.NewWriter(w), now: time.Now()}
+}
+
+func (s *tarSink) WriteFile(name string, data []byte) (string, error) {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  s.now,
+	}
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return "", err
+	}
+	if _, err := s.tw.Write(data); err != nil {
+		return "", err
+	}
+	return s.name + ":" + name, nil
+}
+
+func (s *tarSink) Close() error {
+	if err := s.tw.Close(); err != nil {
+		s.w.Close()
+		return err
+	}
+	return s.w.Close()
+}
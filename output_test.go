@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// nopWriteCloser adds a no-op Close to a writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// readTar returns the contents of the files in a tar stream by name.
+func readTar(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = string(content)
+	}
+}
+
+func TestStdinName(t *testing.T) {
+	pdf := buildTestPDF(1, false)
+	png := []byte("\x89PNG\r\n\x1a\n")
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"", pdf, "stdin.pdf"},
+		{"", png, "stdin.png"},
+		{"", []byte("unknown"), "stdin"},
+		{"scan", pdf, "scan.pdf"},
+		{"scan.PDF", pdf, "scan.PDF"},
+	}
+
+	for _, tt := range tests {
+		if got := stdinName(tt.name, tt.data); got != tt.want {
+			t.Errorf("stdinName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessFile_StdoutAndTar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OCRResponse{
+			Pages: []Page{{
+				Index:    0,
+				Markdown: "# Scan\n\n![img-0.jpeg](img-0.jpeg)",
+				Images: []Image{{
+					ID:              "img-0.jpeg",
+					ImageBase64:     "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("jpeg data")),
+					ImageAnnotation: `{"description": "A photo"}`,
+				}},
+			}},
+			DocumentAnnotation: `{"title": "Scan"}`,
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: "scan.pdf", Data: buildTestPDF(1, false)}
+	report := NewReporter(io.Discard, false, false)
+
+	t.Run("stdout only", func(t *testing.T) {
+		var stdout bytes.Buffer
+		ro := runOptions{Stdout: &stdout, OutputDir: t.TempDir()}
+
+		if _, err := processFile(context.Background(), client, doc, ro, report); err != nil {
+			t.Fatalf("processFile failed: %v", err)
+		}
+
+		if want := "# Scan\n\n![img-0.jpeg](img-0.jpeg)\n\n"; stdout.String() != want {
+			t.Errorf("expected Markdown on stdout %q, got %q", want, stdout.String())
+		}
+		if matches, _ := filepath.Glob(filepath.Join(ro.OutputDir, "*")); len(matches) != 0 {
+			t.Errorf("expected no files to be written, got %v", matches)
+		}
+	})
+
+	t.Run("stdout with tar", func(t *testing.T) {
+		var stdout, tarData bytes.Buffer
+		sink := newTarSink("out.tar", nopWriteCloser{&tarData})
+		ro := runOptions{
+			Stdout:         &stdout,
+			Sink:           sink,
+			OutputTemplate: "{name}/{name}",
+			OCR:            OCROptions{ExtractImageMetadata: true},
+			JSONExport:     true,
+		}
+
+		if _, err := processFile(context.Background(), client, doc, ro, report); err != nil {
+			t.Fatalf("processFile failed: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close tar: %v", err)
+		}
+
+		if stdout.Len() == 0 {
+			t.Error("expected Markdown on stdout")
+		}
+
+		files := readTar(t, tarData.Bytes())
+		for _, name := range []string{
+			"scan/scan.annotation.json",
+			"scan/scan.json",
+			"scan/images/page_0_img_0.jpg",
+			"scan/images/page_0_img_0.json",
+		} {
+			if _, ok := files[name]; !ok {
+				t.Errorf("expected %s in tar, got %v", name, files)
+			}
+		}
+		if _, ok := files["scan/scan.md"]; ok {
+			t.Error("expected Markdown only on stdout, not in the tar")
+		}
+		if got := files["scan/images/page_0_img_0.jpg"]; got != "jpeg data" {
+			t.Errorf("expected decoded image data, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"strconv"
+)
+
+// pdfRef is an indirect object reference such as "12 0 R".
+type pdfRef struct {
+	Num, Gen int
+}
+
+// pdfName is a PDF name object without its leading slash.
+type pdfName string
+
+// pdfDict is a PDF dictionary keyed by name (without the leading slash).
+type pdfDict map[string]any
+
+// pdfStream is a stream object: its dictionary and raw, still encoded, data.
+type pdfStream struct {
+	Dict pdfDict
+	Data []byte
+}
+
+// pdfFile is a minimal, read-only PDF reader. It knows just enough of the
+// file structure to find the trailer and walk the page tree. Objects are
+// located by scanning for "N G obj" markers instead of trusting the xref
+// table, which keeps it tolerant of the slightly broken files scanners
+// tend to produce.
+type pdfFile struct {
+	data    []byte
+	offsets map[int]int
+	objects map[int]any
+	trailer pdfDict
+
+	objStmLoaded bool
+}
+
+// maxDecodedStreamSize limits the size of a decoded stream, so that a small
+// compressed stream can't exhaust memory. It leaves room for the scanned
+// page images the openai provider decodes.
+const maxDecodedStreamSize = 256 << 20
+
+var (
+	errNotPDF = errors.New("not a PDF file")
+	// errStreamSize is returned for a stream that decodes to more than the
+	// limit.
+	errStreamSize = errors.New("pdf: decoded stream is too large")
+
+	pdfObjRe  = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfPageRe = regexp.MustCompile(`/Type\s*/Page\b`)
+)
+
+// parsePDF indexes the objects in data and locates the trailer.
+func parsePDF(data []byte) (*pdfFile, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, errNotPDF
+	}
+
+	f := &pdfFile{
+		data:    data,
+		offsets: make(map[int]int),
+		objects: make(map[int]any),
+	}
+
+	for _, m := range pdfObjRe.FindAllSubmatchIndex(data, -1) {
+		if m[0] > 0 && isPDFDigit(data[m[0]-1]) {
+			continue
+		}
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		// Later definitions win, which is what incremental updates expect.
+		f.offsets[num] = m[1]
+	}
+
+	f.trailer = f.findTrailer()
+	if f.trailer == nil {
+		return nil, errors.New("pdf: no trailer found")
+	}
+
+	return f, nil
+}
+
+// findTrailer returns the most recent trailer dictionary, falling back to the
+// dictionary of the last cross-reference stream for PDF 1.5+ files.
+func (f *pdfFile) findTrailer() pdfDict {
+	if idx := bytes.LastIndex(f.data, []byte("trailer")); idx != -1 {
+		p := &pdfParser{data: f.data, pos: idx + len("trailer")}
+		if obj, err := p.parseObject(0); err == nil {
+			if dict, ok := obj.(pdfDict); ok && dict["Root"] != nil {
+				return dict
+			}
+		}
+	}
+
+	var trailer pdfDict
+	lastOffset := -1
+	for num, offset := range f.offsets {
+		if offset < lastOffset {
+			continue
+		}
+		stream, ok := f.object(num).(*pdfStream)
+		if !ok || stream.Dict["Type"] != pdfName("XRef") || stream.Dict["Root"] == nil {
+			continue
+		}
+		trailer, lastOffset = stream.Dict, offset
+	}
+	return trailer
+}
+
+// object returns the parsed indirect object with the given number, or nil.
+func (f *pdfFile) object(num int) any {
+	if obj, ok := f.objects[num]; ok {
+		return obj
+	}
+
+	offset, ok := f.offsets[num]
+	if !ok {
+		f.loadObjectStreams()
+		return f.objects[num]
+	}
+
+	p := &pdfParser{data: f.data, pos: offset, file: f}
+	obj, err := p.parseIndirect()
+	if err != nil {
+		obj = nil
+	}
+	f.objects[num] = obj
+	return obj
+}
+
+// streamLength returns the value of an indirect stream /Length. The object
+// is parsed directly, bypassing the cache, as it is looked up while another
+// object is being parsed.
+func (f *pdfFile) streamLength(ref pdfRef) (int, bool) {
+	offset, ok := f.offsets[ref.Num]
+	if !ok {
+		return 0, false
+	}
+	p := &pdfParser{data: f.data, pos: offset}
+	obj, err := p.parseObject(0)
+	if err != nil {
+		return 0, false
+	}
+	length, ok := obj.(int)
+	return length, ok
+}
+
+// loadObjectStreams unpacks every compressed object stream into the object
+// cache. It runs at most once and only when an object is not found directly.
+func (f *pdfFile) loadObjectStreams() {
+	if f.objStmLoaded {
+		return
+	}
+	f.objStmLoaded = true
+
+	nums := make([]int, 0, len(f.offsets))
+	for num := range f.offsets {
+		nums = append(nums, num)
+	}
+
+	for _, num := range nums {
+		stream, ok := f.object(num).(*pdfStream)
+		if !ok || stream.Dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		data, err := stream.decode()
+		if err != nil {
+			continue
+		}
+		n, _ := pdfInt(stream.Dict["N"])
+		first, _ := pdfInt(stream.Dict["First"])
+
+		header := &pdfParser{data: data}
+		for range n {
+			objNum, err1 := header.parseObject(0)
+			objOff, err2 := header.parseObject(0)
+			if err1 != nil || err2 != nil {
+				break
+			}
+			on, ok1 := pdfInt(objNum)
+			oo, ok2 := pdfInt(objOff)
+			if !ok1 || !ok2 {
+				break
+			}
+			if _, exists := f.objects[on]; exists {
+				continue
+			}
+			p := &pdfParser{data: data, pos: first + oo}
+			if obj, err := p.parseObject(0); err == nil {
+				f.objects[on] = obj
+			}
+		}
+	}
+}
+
+// resolve follows indirect references until it reaches a direct object.
+func (f *pdfFile) resolve(v any) any {
+	for range 32 {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = f.object(ref.Num)
+	}
+	return nil
+}
+
+// PageCount returns the number of pages found by walking the page tree,
+// falling back to counting page objects when the tree cannot be read. The
+// /Count of the tree isn't trusted, as a broken or crafted file may claim
+// any number of pages.
+func (f *pdfFile) PageCount() int {
+	if pages := f.Pages(); len(pages) > 0 {
+		return len(pages)
+	}
+	return len(pdfPageRe.FindAllIndex(f.data, -1))
+}
+
+// Encrypted reports whether the trailer references an encryption dictionary.
+func (f *pdfFile) Encrypted() bool {
+	return f.trailer["Encrypt"] != nil
+}
+
+// Pages returns the page dictionaries in order, walking the page tree.
+// Resources, MediaBox, CropBox, and Rotate inherited from parent nodes are
+// copied into each page.
+func (f *pdfFile) Pages() []pdfDict {
+	root, ok := f.resolve(f.trailer["Root"]).(pdfDict)
+	if !ok {
+		return nil
+	}
+	var pages []pdfDict
+	f.collectPages(f.resolve(root["Pages"]), pdfDict{}, &pages, make(map[int]bool), 0)
+	return pages
+}
+
+// collectPages appends the pages under node. Each object is visited once,
+// so that a tree whose nodes refer to each other can't loop or multiply.
+func (f *pdfFile) collectPages(node any, inherited pdfDict, pages *[]pdfDict, visited map[int]bool, depth int) {
+	dict, ok := node.(pdfDict)
+	if !ok || depth > 64 {
+		return
+	}
+
+	attrs := maps.Clone(inherited)
+	for _, key := range []string{"Resources", "MediaBox", "CropBox", "Rotate"} {
+		if v, ok := dict[key]; ok {
+			attrs[key] = v
+		}
+	}
+
+	if dict["Type"] == pdfName("Page") {
+		page := maps.Clone(dict)
+		maps.Copy(page, attrs)
+		*pages = append(*pages, page)
+		return
+	}
+
+	kids, _ := f.resolve(dict["Kids"]).([]any)
+	for _, kid := range kids {
+		if ref, ok := kid.(pdfRef); ok {
+			if visited[ref.Num] {
+				continue
+			}
+			visited[ref.Num] = true
+		}
+		f.collectPages(f.resolve(kid), attrs, pages, visited, depth+1)
+	}
+}
+
+// decode returns the stream data with its filters applied. Only FlateDecode
+// without predictors is supported, which covers object streams.
+func (s *pdfStream) decode() ([]byte, error) {
+	return s.decodeLimit(maxDecodedStreamSize)
+}
+
+// decodeLimit is decode with the decoded data limited to limit bytes.
+func (s *pdfStream) decodeLimit(limit int) ([]byte, error) {
+	var filters []any
+	switch v := s.Dict["Filter"].(type) {
+	case nil:
+		return s.Data, nil
+	case pdfName:
+		filters = []any{v}
+	case []any:
+		filters = v
+	}
+
+	data := s.Data
+	for _, filter := range filters {
+		if filter != pdfName("FlateDecode") {
+			return nil, fmt.Errorf("pdf: unsupported filter %v", filter)
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		if len(data) > limit {
+			return nil, fmt.Errorf("%w: more than %d bytes", errStreamSize, limit)
+		}
+	}
+	return data, nil
+}
+
+// pdfInt converts a parsed numeric object to an int.
+func pdfInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// pdfParser parses PDF objects from a byte slice.
+type pdfParser struct {
+	data []byte
+	pos  int
+	// file, if set, resolves indirect stream lengths.
+	file *pdfFile
+}
+
+// parseIndirect parses the body of an indirect object, including stream
+// data when present. The position must be just past the "obj" keyword.
+func (p *pdfParser) parseIndirect() (any, error) {
+	obj, err := p.parseObject(0)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return obj, nil
+	}
+
+	p.skipSpace()
+	if !bytes.HasPrefix(p.data[p.pos:], []byte("stream")) {
+		return dict, nil
+	}
+	p.pos += len("stream")
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+
+	length, ok := dict["Length"].(int)
+	if ref, isRef := dict["Length"].(pdfRef); isRef && p.file != nil {
+		length, ok = p.file.streamLength(ref)
+	}
+	if ok && length >= 0 && start+length <= len(p.data) {
+		rest := bytes.TrimLeft(p.data[start+length:], " \t\r\n")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			return &pdfStream{Dict: dict, Data: p.data[start : start+length]}, nil
+		}
+	}
+
+	// Length is missing or wrong; fall back to searching for the end marker,
+	// which follows the data and one end-of-line marker.
+	end := bytes.Index(p.data[start:], []byte("endstream"))
+	if end == -1 {
+		return nil, errors.New("pdf: unterminated stream")
+	}
+	data := p.data[start : start+end]
+	if bytes.HasSuffix(data, []byte("\r\n")) {
+		data = data[:len(data)-2]
+	} else if bytes.HasSuffix(data, []byte("\n")) {
+		data = data[:len(data)-1]
+	}
+	return &pdfStream{Dict: dict, Data: data}, nil
+}
+
+// parseObject parses a single direct object or reference.
+func (p *pdfParser) parseObject(depth int) (any, error) {
+	if depth > 64 {
+		return nil, errors.New("pdf: objects nested too deeply")
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	c := p.data[p.pos]
+	switch {
+	case c == '/':
+		return p.parseName(), nil
+	case c == '(':
+		return p.parseLiteralString()
+	case c == '<':
+		if p.pos+1 < len(p.data) && p.data[p.pos+1] == '<' {
+			p.pos += 2
+			return p.parseDict(depth)
+		}
+		return p.parseHexString()
+	case c == '[':
+		p.pos++
+		return p.parseArray(depth)
+	case c == '+' || c == '-' || c == '.' || isPDFDigit(c):
+		return p.parseNumberOrRef()
+	}
+
+	start := p.pos
+	switch kw := p.parseKeyword(); kw {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("pdf: unexpected token %q at offset %d", kw, start)
+	}
+}
+
+func (p *pdfParser) parseDict(depth int) (pdfDict, error) {
+	dict := make(pdfDict)
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+			p.pos += 2
+			return dict, nil
+		}
+		if p.data[p.pos] != '/' {
+			return nil, fmt.Errorf("pdf: expected name key at offset %d", p.pos)
+		}
+		key := p.parseName()
+		value, err := p.parseObject(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		dict[string(key)] = value
+	}
+}
+
+func (p *pdfParser) parseArray(depth int) ([]any, error) {
+	var arr []any
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if p.data[p.pos] == ']' {
+			p.pos++
+			return arr, nil
+		}
+		value, err := p.parseObject(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, value)
+	}
+}
+
+func (p *pdfParser) parseName() pdfName {
+	p.pos++ // skip '/'
+	var b []byte
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if isPDFSpace(c) || isPDFDelim(c) {
+			break
+		}
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		p.pos++
+	}
+	return pdfName(b)
+}
+
+func (p *pdfParser) parseLiteralString() (string, error) {
+	p.pos++ // skip '('
+	var b []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(b), nil
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				return "", io.ErrUnexpectedEOF
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return "", io.ErrUnexpectedEOF
+}
+
+func (p *pdfParser) parseHexString() (string, error) {
+	p.pos++ // skip '<'
+	var digits []byte
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			b := make([]byte, len(digits)/2)
+			for i := range b {
+				v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+				if err != nil {
+					return "", fmt.Errorf("pdf: invalid hex string: %w", err)
+				}
+				b[i] = byte(v)
+			}
+			return string(b), nil
+		}
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	return "", io.ErrUnexpectedEOF
+}
+
+// parseNumberOrRef parses a number, or a reference when the number is
+// followed by a generation number and "R".
+func (p *pdfParser) parseNumberOrRef() (any, error) {
+	tok := p.parseKeyword()
+	n, err := strconv.Atoi(tok)
+	if err != nil {
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("pdf: invalid number %q", tok)
+		}
+		return f, nil
+	}
+
+	save := p.pos
+	p.skipSpace()
+	if p.pos < len(p.data) && isPDFDigit(p.data[p.pos]) {
+		if gen, err := strconv.Atoi(p.parseKeyword()); err == nil {
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == 'R' &&
+				(p.pos+1 == len(p.data) || isPDFSpace(p.data[p.pos+1]) || isPDFDelim(p.data[p.pos+1])) {
+				p.pos++
+				return pdfRef{Num: n, Gen: gen}, nil
+			}
+		}
+	}
+	p.pos = save
+	return n, nil
+}
+
+func (p *pdfParser) parseKeyword() string {
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelim(p.data[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start && p.pos < len(p.data) {
+		p.pos++ // consume a stray delimiter so callers always make progress
+	}
+	return string(p.data[start:p.pos])
+}
+
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case isPDFSpace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func isPDFDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildTestPDF returns a minimal PDF with the given number of pages. With
+// objStm set, the catalog and page tree live in a compressed object stream
+// and the trailer is a cross-reference stream, as in PDF 1.5+ files.
+func buildTestPDF(pages int, objStm bool) []byte {
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", i+3))
+	}
+	catalog := "<< /Type /Catalog /Pages 2 0 R >>"
+	tree := fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pages)
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	for i := range pages {
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>\nendobj\n", i+3)
+	}
+
+	if !objStm {
+		fmt.Fprintf(&b, "1 0 obj\n%s\nendobj\n2 0 obj\n%s\nendobj\n", catalog, tree)
+		b.WriteString("xref\n0 0\ntrailer\n<< /Size 3 /Root 1 0 R >>\nstartxref\n0\n%%EOF\n")
+		return b.Bytes()
+	}
+
+	header := fmt.Sprintf("1 0 2 %d ", len(catalog)+1)
+	var packed bytes.Buffer
+	zw := zlib.NewWriter(&packed)
+	zw.Write([]byte(header + catalog + " " + tree))
+	zw.Close()
+
+	n := pages + 3
+	fmt.Fprintf(&b, "%d 0 obj\n<< /Type /ObjStm /N 2 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", n, len(header), packed.Len())
+	b.Write(packed.Bytes())
+	b.WriteString("\nendstream\nendobj\n")
+	fmt.Fprintf(&b, "%d 0 obj\n<< /Type /XRef /Size %d /Root 1 0 R /Length 0 >>\nstream\n\nendstream\nendobj\n", n+1, n+2)
+	b.WriteString("startxref\n0\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestParsePDF_PageCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		pages  int
+		objStm bool
+	}{
+		{"classic trailer", 3, false},
+		{"object stream", 5, true},
+		{"single page", 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdf, err := parsePDF(buildTestPDF(tt.pages, tt.objStm))
+			if err != nil {
+				t.Fatalf("parsePDF failed: %v", err)
+			}
+
+			if got := pdf.PageCount(); got != tt.pages {
+				t.Errorf("expected %d pages, got %d", tt.pages, got)
+			}
+
+			if pdf.Encrypted() {
+				t.Error("expected unencrypted PDF")
+			}
+		})
+	}
+}
+
+func TestParsePDF_PageCountNotTrusted(t *testing.T) {
+	// A /Count far above the pages in the file, and a tree whose nodes
+	// refer to each other.
+	for name, tree := range map[string]string{
+		"count":     "<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 1000000000 >>",
+		"self":      "<< /Type /Pages /Kids [2 0 R 3 0 R 2 0 R 4 0 R] /Count 2 >>",
+		"duplicate": "<< /Type /Pages /Kids [3 0 R 3 0 R 4 0 R] /Count 3 >>",
+	} {
+		data := bytes.Replace(buildTestPDF(2, false), []byte("<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>"), []byte(tree), 1)
+		pdf, err := parsePDF(data)
+		if err != nil {
+			t.Fatalf("%s: parsePDF failed: %v", name, err)
+		}
+		if got := pdf.PageCount(); got != 2 {
+			t.Errorf("%s: expected 2 pages, got %d", name, got)
+		}
+	}
+}
+
+func TestParsePDF_NotPDF(t *testing.T) {
+	if _, err := parsePDF([]byte("\x89PNG\r\n\x1a\n")); err != errNotPDF {
+		t.Errorf("expected errNotPDF, got %v", err)
+	}
+}
+
+func TestParsePDF_Encrypted(t *testing.T) {
+	data := bytes.Replace(buildTestPDF(1, false), []byte("/Root 1 0 R"), []byte("/Root 1 0 R /Encrypt 9 0 R"), 1)
+
+	pdf, err := parsePDF(data)
+	if err != nil {
+		t.Fatalf("parsePDF failed: %v", err)
+	}
+
+	if !pdf.Encrypted() {
+		t.Error("expected encrypted PDF")
+	}
+}
+
+func TestPDFParser_Objects(t *testing.T) {
+	p := &pdfParser{data: []byte(`<< /Name /A#20B /Str (a\(b\)\101) /Hex <48 69> /Arr [1 -2.5 3 0 R true null] >>`)}
+
+	obj, err := p.parseObject(0)
+	if err != nil {
+		t.Fatalf("parseObject failed: %v", err)
+	}
+
+	dict := obj.(pdfDict)
+	if dict["Name"] != pdfName("A B") {
+		t.Errorf("unexpected name: %v", dict["Name"])
+	}
+	if dict["Str"] != "a(b)A" {
+		t.Errorf("unexpected literal string: %q", dict["Str"])
+	}
+	if dict["Hex"] != "Hi" {
+		t.Errorf("unexpected hex string: %q", dict["Hex"])
+	}
+
+	arr := dict["Arr"].([]any)
+	want := []any{1, -2.5, pdfRef{Num: 3}, true, nil}
+	if len(arr) != len(want) {
+		t.Fatalf("expected %d array elements, got %d", len(want), len(arr))
+	}
+	for i := range want {
+		if arr[i] != want[i] {
+			t.Errorf("element %d: expected %v, got %v", i, want[i], arr[i])
+		}
+	}
+}
+
+func TestParsePDF_StreamLength(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	for i, obj := range []string{
+		"<< /Type /Catalog >>",
+		// Binary data ending in line breaks, with its length in object 3.
+		"<< /Length 3 0 R >>\nstream\nab\n\r\n\nendstream",
+		"5",
+		// The length can't be resolved, so only the last EOL is dropped.
+		"<< /Length 9 0 R >>\nstream\nab\n\r\n\nendstream",
+		"<< /Length 9 0 R >>\nstream\nab\n\r\nendstream",
+	} {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	f, err := parsePDF(b.Bytes())
+	if err != nil {
+		t.Fatalf("parsePDF failed: %v", err)
+	}
+	for num, want := range map[int]string{2: "ab\n\r\n", 4: "ab\n\r\n", 5: "ab\n"} {
+		stream, ok := f.object(num).(*pdfStream)
+		if !ok || string(stream.Data) != want {
+			t.Errorf("object %d: expected %q, got %+v", num, want, f.object(num))
+		}
+	}
+}
+
+func TestPDFStream_DecodeLimit(t *testing.T) {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write(make([]byte, 1000))
+	zw.Close()
+	stream := &pdfStream{Dict: pdfDict{"Filter": pdfName("FlateDecode")}, Data: b.Bytes()}
+
+	if data, err := stream.decodeLimit(1000); err != nil || len(data) != 1000 {
+		t.Errorf("expected 1000 bytes at the limit, got %d (%v)", len(data), err)
+	}
+	if _, err := stream.decodeLimit(999); !errors.Is(err, errStreamSize) {
+		t.Errorf("expected errStreamSize over the limit, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfWriter writes a PDF file object by object. Object numbers are reserved
+// first, so that objects can reference each other before they are written.
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func newPDFWriter() *pdfWriter {
+	w := &pdfWriter{}
+	// The comment with high bytes marks the file as binary.
+	w.buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	return w
+}
+
+// reserve returns the number of a new object.
+func (w *pdfWriter) reserve() int {
+	w.offsets = append(w.offsets, -1)
+	return len(w.offsets)
+}
+
+// object writes an object with the given body.
+func (w *pdfWriter) object(num int, body string) {
+	w.offsets[num-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+// stream writes a stream object. dict holds the entries of its dictionary
+// other than Length.
+func (w *pdfWriter) stream(num int, dict string, data []byte) {
+	w.offsets[num-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", num, dict, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// compressedStream writes a stream compressed with FlateDecode.
+func (w *pdfWriter) compressedStream(num int, dict string, data []byte) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	w.stream(num, strings.TrimSpace(dict+" /Filter /FlateDecode"), buf.Bytes())
+}
+
+// finish writes the cross-reference table and trailer and returns the file.
+func (w *pdfWriter) finish(root, info int) []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", max(offset, 0))
+	}
+
+	id := sha256.Sum256(w.buf.Bytes())
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R /ID [<%x> <%x>] >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, root, info, id[:16], id[:16], xref)
+	return w.buf.Bytes()
+}
+
+// pdfTextString encodes s as a PDF text string, as UTF-16 if it isn't ASCII.
+func pdfTextString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "(" + pdfEscape(s) + ")"
+	}
+
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// pdfEscape escapes the characters that are special in a literal string.
+func pdfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+}
+
+// winAnsiHigh maps the characters of WinAnsiEncoding between 0x80 and 0x9F,
+// where it differs from Latin-1.
+var winAnsiHigh = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfWinAnsiString encodes s as a literal string in WinAnsiEncoding, for
+// text shown with a standard font. Characters it can't represent become "?".
+func pdfWinAnsiString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		c := byte('?')
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			c = byte(r)
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		case winAnsiHigh[r] != 0:
+			c = winAnsiHigh[r]
+		}
+		if c >= 0x80 {
+			// Octal escapes keep the content stream ASCII.
+			fmt.Fprintf(&b, `\%03o`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// formatPDFObject writes a parsed PDF object back in PDF syntax, resolving
+// references in f. It fails for streams, which can't be copied inline.
+func formatPDFObject(f *pdfFile, v any, depth int) (string, bool) {
+	if depth > 16 {
+		return "", false
+	}
+	switch v := v.(type) {
+	case pdfRef:
+		return formatPDFObject(f, f.resolve(v), depth+1)
+	case pdfName:
+		return "/" + string(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return fmt.Sprintf("<%x>", v), true
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, ok := formatPDFObject(f, item, depth+1)
+			if !ok {
+				return "", false
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, " ") + "]", true
+	case pdfDict:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(v))
+		for _, key := range keys {
+			s, ok := formatPDFObject(f, v[key], depth+1)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, "/"+key+" "+s)
+		}
+		return "<< " + strings.Join(parts, " ") + " >>", true
+	case nil:
+		return "null", true
+	}
+	return "", false
+}
+
+// pdfCopier copies objects from a parsed PDF into a pdfWriter. Each indirect
+// object reached is copied once, under a new number; streams are copied
+// with their data still encoded.
+type pdfCopier struct {
+	f     *pdfFile
+	w     *pdfWriter
+	nums  map[int]int
+	queue []int
+}
+
+func newPDFCopier(f *pdfFile, w *pdfWriter) *pdfCopier {
+	return &pdfCopier{f: f, w: w, nums: make(map[int]int)}
+}
+
+// format writes v in PDF syntax. The indirect objects it references are
+// queued to be written by flush.
+func (c *pdfCopier) format(v any) string {
+	switch v := v.(type) {
+	case pdfRef:
+		num, ok := c.nums[v.Num]
+		if !ok {
+			num = c.w.reserve()
+			c.nums[v.Num] = num
+			c.queue = append(c.queue, v.Num)
+		}
+		return fmt.Sprintf("%d 0 R", num)
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = c.format(item)
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	case pdfDict:
+		return "<< " + c.entries(v) + " >>"
+	}
+	// Direct objects other than arrays and dictionaries reference nothing.
+	if s, ok := formatPDFObject(c.f, v, 0); ok {
+		return s
+	}
+	return "null"
+}
+
+// entries writes the entries of dict, leaving out the keys in skip.
+func (c *pdfCopier) entries(dict pdfDict, skip ...string) string {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		if !slices.Contains(skip, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = "/" + key + " " + c.format(dict[key])
+	}
+	return strings.Join(parts, " ")
+}
+
+// flush writes the queued objects and those they reference in turn.
+func (c *pdfCopier) flush() {
+	for len(c.queue) > 0 {
+		src := c.queue[0]
+		c.queue = c.queue[1:]
+		num := c.nums[src]
+		if stream, ok := c.f.object(src).(*pdfStream); ok {
+			c.w.stream(num, c.entries(stream.Dict, "Length"), stream.Data)
+			continue
+		}
+		c.w.object(num, c.format(c.f.object(src)))
+	}
+}
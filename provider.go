@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Provider runs OCR on documents. Results are normalized to the pages,
+// images, and annotations of the Mistral OCR API, so that the outputs don't
+// depend on the backend.
+type Provider interface {
+	// ProcessBytesStream processes a document held in memory and calls fn
+	// with each page in order. The returned response has everything but
+	// the pages.
+	ProcessBytesStream(ctx context.Context, name string, docData []byte, opts OCROptions, fn func(Page) error) (*OCRResponse, error)
+}
+
+var (
+	_ Provider = (*Client)(nil)
+	_ Provider = (*OpenAIProvider)(nil)
+)
+
+// Providers selected with -provider.
+const (
+	providerMistral = "mistral"
+	providerOpenAI  = "openai"
+)
+
+// newProvider returns the provider selected in cfg.
+func newProvider(cfg *Config, apiKey string) (Provider, error) {
+	opts := []ClientOption{WithRetries(cfg.Int("retries"))}
+
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		opts = append(opts, WithHTTPClient(&http.Client{Transport: transport}))
+	}
+
+	switch name := cfg.String("provider"); name {
+	case providerMistral:
+		opts = append(opts, WithBaseURL(cfg.String("base_url")), WithModel(cfg.String("model")))
+		return NewClient(apiKey, opts...), nil
+
+	case providerOpenAI:
+		// The defaults are those of the Mistral API.
+		if cfg.Layer("base_url") != layerDefault {
+			opts = append(opts, WithBaseURL(cfg.String("base_url")))
+		}
+		if cfg.Layer("model") == layerDefault {
+			return nil, fmt.Errorf("-provider openai needs -model, the vision model to use")
+		}
+		opts = append(opts, WithModel(cfg.String("model")))
+		return NewOpenAIProvider(apiKey, opts...), nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider %q (expected %s or %s)", name, providerMistral, providerOpenAI)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// recordedHeaders are the headers kept in recordings. Others, such as
+// Authorization, are left out.
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// interaction is the metadata of a recorded request and its response. The
+// response body is stored as it was received, in a file next to it.
+type interaction struct {
+	Method  string          `json:"method"`
+	Path    string          `json:"path"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Status  int             `json:"status"`
+	Header  http.Header     `json:"header,omitempty"`
+}
+
+// RecordingTransport is an http.RoundTripper that saves each API request and
+// its response to a directory, for ReplayTransport to serve later. Request
+// bodies are saved as JSON with base64 data, such as the document, replaced
+// by its SHA-256 hash; other bodies are replaced by their hash. The API key
+// is not saved.
+type RecordingTransport struct {
+	dir  string
+	next http.RoundTripper
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewRecordingTransport returns a transport that sends requests with next,
+// or http.DefaultTransport if nil, and records them to dir. The directory
+// must be empty or not exist yet, so that a recording isn't mixed with an
+// earlier one.
+func NewRecordingTransport(dir string, next http.RoundTripper) (*RecordingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating recording directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading recording directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("recording directory %s is not empty", dir)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RecordingTransport{dir: dir, next: next}, nil
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.n++
+	name := filepath.Join(t.dir, fmt.Sprintf("%04d", t.n))
+	t.mu.Unlock()
+
+	rec := interaction{
+		Method:  req.Method,
+		Path:    req.URL.RequestURI(),
+		Request: sanitizeBody(req.Header.Get("Content-Type"), body),
+		Status:  resp.StatusCode,
+		Header:  http.Header{},
+	}
+	for _, key := range recordedHeaders {
+		if values := resp.Header.Values(key); len(values) > 0 {
+			rec.Header[key] = values
+		}
+	}
+	meta, err := json.MarshalIndent(rec, "", "  ")
+	if err == nil {
+		err = os.WriteFile(name+".json", append(meta, '\n'), 0644)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("recording response: %w", err)
+	}
+
+	// The body is saved as it is read, so that a response cut off is
+	// recorded as it was received.
+	f, err := os.Create(name + ".body")
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("recording response: %w", err)
+	}
+	resp.Body = &recordedBody{Reader: io.TeeReader(resp.Body, f), body: resp.Body, file: f}
+	return resp, nil
+}
+
+// recordedBody copies a response body to a file as it is read.
+type recordedBody struct {
+	io.Reader
+	body io.Closer
+	file *os.File
+}
+
+func (b *recordedBody) Close() error {
+	err := b.body.Close()
+	if ferr := b.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// ReplayTransport is an http.RoundTripper that serves responses recorded by
+// RecordingTransport instead of calling the API. A request gets the first
+// response not served yet that was recorded for the same method, path, and
+// body, so that retries and concurrent requests are replayed as recorded.
+type ReplayTransport struct {
+	dir          string
+	interactions []interaction
+	keys         []string
+
+	mu     sync.Mutex
+	served []bool
+}
+
+// NewReplayTransport loads the recording in dir.
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no recording in %s", dir)
+	}
+	slices.Sort(names)
+
+	t := &ReplayTransport{dir: dir, served: make([]bool, len(names))}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading recording: %w", err)
+		}
+		var rec interaction
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("reading recording %s: %w", name, err)
+		}
+		// Requests are compared compacted, as they are sanitized.
+		var request bytes.Buffer
+		if len(rec.Request) > 0 {
+			if err := json.Compact(&request, rec.Request); err != nil {
+				return nil, fmt.Errorf("reading recording %s: %w", name, err)
+			}
+		}
+		rec.Request = request.Bytes()
+		t.interactions = append(t.interactions, rec)
+		t.keys = append(t.keys, strings.TrimSuffix(name, ".json"))
+	}
+	return t, nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	request := sanitizeBody(req.Header.Get("Content-Type"), body)
+	path := req.URL.RequestURI()
+
+	t.mu.Lock()
+	i := -1
+	for j, rec := range t.interactions {
+		if !t.served[j] && rec.Method == req.Method && rec.Path == path && bytes.Equal(rec.Request, request) {
+			i = j
+			t.served[j] = true
+			break
+		}
+	}
+	t.mu.Unlock()
+
+	if i == -1 {
+		return nil, fmt.Errorf("no recorded response for %s %s in %s", req.Method, path, t.dir)
+	}
+
+	rec := t.interactions[i]
+	data, err := os.ReadFile(t.keys[i] + ".body")
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %w", err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode:    rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// readRequestBody returns the body of req and replaces it with a copy, so
+// that it can still be sent.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading request: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// sanitizeBody returns a request body as it is recorded: JSON with base64
+// data replaced by its hash, compacted with sorted keys so that equal
+// requests compare equal; for an upload, its fields with the hash of the
+// file; for other bodies, just their hash.
+func sanitizeBody(contentType string, body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var v any
+	if mediaType, params, _ := mime.ParseMediaType(contentType); mediaType == "multipart/form-data" {
+		v = sanitizeForm(multipart.NewReader(bytes.NewReader(body), params["boundary"]), body)
+	} else if err := json.Unmarshal(body, &v); err != nil {
+		v = hashString(body)
+	}
+	data, err := json.Marshal(sanitizeValue(v))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// sanitizeForm returns the fields of a multipart form, with files replaced
+// by their hash. The boundary is left out, as it is random.
+func sanitizeForm(r *multipart.Reader, body []byte) any {
+	form := map[string]any{}
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return form
+		}
+		if err != nil {
+			return hashString(body)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return hashString(body)
+		}
+		if part.FileName() != "" {
+			form[part.FormName()] = map[string]string{"filename": part.FileName(), "data": hashString(data)}
+		} else {
+			form[part.FormName()] = string(data)
+		}
+	}
+}
+
+// sanitizeValue replaces the base64 data URLs in v with their hash.
+func sanitizeValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = sanitizeValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = sanitizeValue(item)
+		}
+	case string:
+		if header, _, ok := strings.Cut(v, ","); ok && strings.HasPrefix(header, "data:") && strings.HasSuffix(header, ";base64") {
+			return header + "," + hashString([]byte(v))
+		}
+	}
+	return v
+}
+
+func hashString(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newTransport returns the transport for -record or -replay, or nil to call
+// the API directly.
+func newTransport(cfg *Config) (http.RoundTripper, error) {
+	record, replay := cfg.Path("record"), cfg.Path("replay")
+	switch {
+	case record != "" && replay != "":
+		return nil, fmt.Errorf("-record and -replay cannot be used together")
+	case record != "":
+		return NewRecordingTransport(record, nil)
+	case replay != "":
+		return NewReplayTransport(replay)
+	}
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ocr/ocrtest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	server := ocrtest.New(t)
+	server.RespondFixture("testdata/invoice.json")
+	server.Script(ocrtest.RateLimited("0"))
+
+	dir := filepath.Join(t.TempDir(), "recording")
+	recorder, err := NewRecordingTransport(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("secret-key", WithBaseURL(server.URL), WithRetries(1), WithHTTPClient(&http.Client{Transport: recorder}))
+	client.retryDelay = time.Millisecond
+
+	pdf := buildTestPDF(2, false)
+	recorded, err := client.ProcessBytes(context.Background(), "invoice.pdf", pdf, OCROptions{})
+	if err != nil {
+		t.Fatalf("ProcessBytes failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 4 {
+		t.Fatalf("expected the rate-limited and the successful exchange recorded, got %v", files)
+	}
+	meta, err := os.ReadFile(filepath.Join(dir, "0001.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(meta), `"document_url": "data:application/pdf;base64,sha256:`) ||
+		!strings.Contains(string(meta), `"status": 429`) || strings.Contains(string(meta), "secret-key") {
+		t.Errorf("expected the document hashed and the key left out, got %s", meta)
+	}
+
+	// A recording isn't mixed with an earlier one.
+	if _, err := NewRecordingTransport(dir, nil); err == nil || !strings.Contains(err.Error(), "is not empty") {
+		t.Errorf("expected an error for a directory with a recording, got %v", err)
+	}
+
+	// The server is no longer needed.
+	server.Close()
+
+	replayer, err := NewReplayTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = NewClient("", WithBaseURL(server.URL), WithRetries(1), WithHTTPClient(&http.Client{Transport: replayer}))
+	client.retryDelay = time.Millisecond
+
+	replayed, err := client.ProcessBytes(context.Background(), "invoice.pdf", pdf, OCROptions{})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if len(replayed.Pages) != len(recorded.Pages) || replayed.Pages[0].Markdown != recorded.Pages[0].Markdown ||
+		replayed.DocumentAnnotation != recorded.DocumentAnnotation {
+		t.Errorf("expected the recorded response, got %+v", replayed)
+	}
+
+	// Each recorded response is served once.
+	if _, err := client.ProcessBytes(context.Background(), "invoice.pdf", pdf, OCROptions{}); err == nil ||
+		!strings.Contains(err.Error(), "no recorded response for POST /ocr") {
+		t.Errorf("expected an error once the recording is used up, got %v", err)
+	}
+	if _, err := NewReplayTransport(t.TempDir()); err == nil {
+		t.Error("expected an error for an empty recording")
+	}
+}
+
+func TestSanitizeBody_Upload(t *testing.T) {
+	upload := func(data string) (string, []byte) {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		w.WriteField("purpose", "ocr")
+		part, _ := w.CreateFormFile("file", "big.pdf")
+		part.Write([]byte(data))
+		w.Close()
+		return w.FormDataContentType(), body.Bytes()
+	}
+
+	first := sanitizeBody(upload("%PDF-1.7 a"))
+	if second := sanitizeBody(upload("%PDF-1.7 a")); !bytes.Equal(first, second) {
+		t.Errorf("expected uploads of the same file to match despite the boundary, got %s and %s", first, second)
+	}
+	if other := sanitizeBody(upload("%PDF-1.7 b")); bytes.Equal(first, other) {
+		t.Errorf("expected uploads of different files to differ, got %s", other)
+	}
+	if !strings.Contains(string(first), `"purpose":"ocr"`) || strings.Contains(string(first), "PDF") {
+		t.Errorf("expected the fields kept and the file hashed, got %s", first)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// hyphenatedRe matches a word broken with a hyphen at the end of a line.
+	hyphenatedRe = regexp.MustCompile(`(\p{L}+)[-\x{00AD}]$`)
+	// leadingWordRe matches the word a line starts with.
+	leadingWordRe = regexp.MustCompile(`^\p{L}+`)
+	// wordRe matches words, including hyphenated compounds.
+	wordRe = regexp.MustCompile(`\p{L}+(?:-\p{L}+)*`)
+)
+
+// reflowPages joins the Markdown of pages into a single document, repairing
+// the breaks that page and line ends leave in the text: paragraphs that
+// continue on the next page are joined, words hyphenated at the end of a line
+// are rejoined, and tables that continue on the next page with the same
+// header row are merged.
+func reflowPages(pages []Page) string {
+	words := documentWords(pages)
+
+	var text string
+	for _, page := range pages {
+		md := dehyphenateLines(strings.Trim(page.Markdown, "\n"), words)
+		switch {
+		case md == "":
+			continue
+		case text == "":
+			text = md
+		default:
+			text = joinPages(text, md, words)
+		}
+	}
+	return text + "\n"
+}
+
+// documentWords returns the lowercased words of the document, so that a
+// hyphenated word can be rejoined the way it is spelled elsewhere.
+func documentWords(pages []Page) map[string]bool {
+	words := make(map[string]bool)
+	for _, page := range pages {
+		for _, w := range wordRe.FindAllString(page.Markdown, -1) {
+			words[strings.ToLower(w)] = true
+		}
+	}
+	return words
+}
+
+// joinPages appends the Markdown of the next page to text.
+func joinPages(text, next string, words map[string]bool) string {
+	prevLines := strings.Split(text, "\n")
+	nextLines := strings.Split(next, "\n")
+	last := prevLines[len(prevLines)-1]
+	first := nextLines[0]
+
+	if isTableRow(last) && isTableRow(first) && len(nextLines) > 1 && isTableSeparator(nextLines[1]) {
+		// The header row of the table the previous page ends with.
+		header := len(prevLines) - 1
+		for header > 0 && isTableRow(prevLines[header-1]) {
+			header--
+		}
+		if sameTableRow(prevLines[header], first) {
+			return text + "\n" + strings.Join(nextLines[2:], "\n")
+		}
+	}
+
+	if !inCodeBlock(text) && isTextLine(last) && isTextLine(first) && !isListItem(first) {
+		if joined, ok := joinLines(last, first, words); ok {
+			return strings.TrimSuffix(text, last) + joined + strings.TrimPrefix(next, first)
+		}
+	}
+
+	return text + "\n\n" + next
+}
+
+// dehyphenateLines rejoins words hyphenated at the end of a line within a
+// paragraph.
+func dehyphenateLines(md string, words map[string]bool) string {
+	lines := strings.Split(md, "\n")
+	out := lines[:0]
+	inCode := false
+	for _, line := range lines {
+		if n := len(out); n > 0 && !inCode && isTextLine(out[n-1]) && isTextLine(line) && !isListItem(line) &&
+			hyphenatedRe.MatchString(out[n-1]) {
+			if joined, ok := joinLines(out[n-1], line, words); ok {
+				out[n-1] = joined
+				continue
+			}
+		}
+		if isCodeFence(line) {
+			inCode = !inCode
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// joinLines joins two lines of text if the second continues the first:
+// either the first ends with a hyphenated word, or it doesn't end a sentence
+// and the second starts in lowercase.
+func joinLines(last, first string, words map[string]bool) (string, bool) {
+	last = strings.TrimRight(last, " \t")
+	first = strings.TrimLeft(first, " \t")
+
+	if m := hyphenatedRe.FindStringSubmatch(last); m != nil {
+		right := leadingWordRe.FindString(first)
+		if right == "" {
+			return "", false
+		}
+		stem := strings.TrimSuffix(last, m[0]) + m[1]
+		if keepHyphen(m[1], right, words) {
+			return stem + "-" + first, true
+		}
+		return stem + first, true
+	}
+
+	r, _ := utf8.DecodeRuneInString(first)
+	if endsSentence(last) || !unicode.IsLower(r) {
+		return "", false
+	}
+	return last + " " + first, true
+}
+
+// keepHyphen reports whether a word broken as left-right is a hyphenated
+// compound. The spelling used elsewhere in the document decides; otherwise
+// the hyphen is kept only before a capital letter, as in "non-European".
+func keepHyphen(left, right string, words map[string]bool) bool {
+	switch {
+	case words[strings.ToLower(left+right)]:
+		return false
+	case words[strings.ToLower(left+"-"+right)]:
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(right)
+	return !unicode.IsLower(r)
+}
+
+// endsSentence reports whether a line ends with sentence punctuation,
+// ignoring closing quotes, brackets, and emphasis.
+func endsSentence(line string) bool {
+	line = strings.TrimRight(line, `"'”’»)]*_`)
+	r, _ := utf8.DecodeLastRuneInString(line)
+	return strings.ContainsRune(".!?:", r)
+}
+
+// isTextLine reports whether a line is running text that a paragraph could
+// continue from or into, rather than a heading, table, image, or code.
+func isTextLine(line string) bool {
+	s := strings.TrimSpace(line)
+	if s == "" {
+		return false
+	}
+	for _, prefix := range []string{"#", "|", "![", "```", "~~~", "<", "$$", "---", "***"} {
+		if strings.HasPrefix(s, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func isListItem(line string) bool {
+	s := strings.TrimSpace(line)
+	if strings.HasPrefix(s, "- ") || strings.HasPrefix(s, "* ") || strings.HasPrefix(s, "+ ") {
+		return true
+	}
+	digits := strings.TrimLeft(s, "0123456789")
+	return len(digits) < len(s) && (strings.HasPrefix(digits, ". ") || strings.HasPrefix(digits, ") "))
+}
+
+func isCodeFence(line string) bool {
+	s := strings.TrimSpace(line)
+	return strings.HasPrefix(s, "```") || strings.HasPrefix(s, "~~~")
+}
+
+// inCodeBlock reports whether text ends inside a fenced code block.
+func inCodeBlock(text string) bool {
+	open := false
+	for _, line := range strings.Split(text, "\n") {
+		if isCodeFence(line) {
+			open = !open
+		}
+	}
+	return open
+}
+
+func isTableRow(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "|")
+}
+
+// isTableSeparator reports whether a line is the delimiter row below a
+// table's header, such as "|---|:---:|".
+func isTableSeparator(line string) bool {
+	s := strings.TrimSpace(line)
+	return isTableRow(s) && strings.Contains(s, "-") && strings.Trim(s, "|:- \t") == ""
+}
+
+// sameTableRow reports whether two table rows have the same cells, ignoring
+// case and spacing.
+func sameTableRow(a, b string) bool {
+	cells := func(row string) []string {
+		parts := strings.Split(strings.Trim(strings.TrimSpace(row), "|"), "|")
+		for i, p := range parts {
+			parts[i] = strings.ToLower(strings.Join(strings.Fields(p), " "))
+		}
+		return parts
+	}
+	return slices.Equal(cells(a), cells(b))
+}
@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestReflowPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []string
+		want  string
+	}{
+		{
+			name:  "paragraph continues on next page",
+			pages: []string{"# Intro\n\nThe results of the", "study show a clear trend.\n\nNext paragraph."},
+			want:  "# Intro\n\nThe results of the study show a clear trend.\n\nNext paragraph.\n",
+		},
+		{
+			name:  "sentence ends at page break",
+			pages: []string{"The study is complete.", "results are below."},
+			want:  "The study is complete.\n\nresults are below.\n",
+		},
+		{
+			name:  "capitalized start is a new paragraph",
+			pages: []string{"Figures are shown in the appendix", "Results"},
+			want:  "Figures are shown in the appendix\n\nResults\n",
+		},
+		{
+			name:  "hyphenated across page break",
+			pages: []string{"This is an exam-", "ple of reflow."},
+			want:  "This is an example of reflow.\n",
+		},
+		{
+			name:  "hyphenated within page",
+			pages: []string{"The exam-\nple and the well-\nknown well-known case.\n\nA non-\nEuropean view."},
+			want:  "The example and the well-known well-known case.\n\nA non-European view.\n",
+		},
+		{
+			name:  "document spelling decides",
+			pages: []string{"A co-\noperative and a cooperative."},
+			want:  "A cooperative and a cooperative.\n",
+		},
+		{
+			name: "table continues with same header",
+			pages: []string{
+				"| Item | Price |\n|---|---|\n| Apple | 1 |",
+				"| item | price |\n| :--- | ---: |\n| Pear | 2 |\n\nTotal: 3",
+			},
+			want: "| Item | Price |\n|---|---|\n| Apple | 1 |\n| Pear | 2 |\n\nTotal: 3\n",
+		},
+		{
+			name: "different table",
+			pages: []string{
+				"| Item | Price |\n|---|---|\n| Apple | 1 |",
+				"| Name | Age |\n|---|---|\n| Ann | 3 |",
+			},
+			want: "| Item | Price |\n|---|---|\n| Apple | 1 |\n\n| Name | Age |\n|---|---|\n| Ann | 3 |\n",
+		},
+		{
+			name:  "headings, lists, and code are not joined",
+			pages: []string{"Some text", "- list item", "```\nfoo(", "bar)\n```", "# Heading"},
+			want:  "Some text\n\n- list item\n\n```\nfoo(\n\nbar)\n```\n\n# Heading\n",
+		},
+		{
+			name:  "empty pages are skipped",
+			pages: []string{"The results of the", "", "study."},
+			want:  "The results of the study.\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages []Page
+			for i, md := range tt.pages {
+				pages = append(pages, Page{Index: i, Markdown: md})
+			}
+			if got := reflowPages(pages); got != tt.want {
+				t.Errorf("expected:\n%q\ngot:\n%q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractText_Reflow(t *testing.T) {
+	resp := &OCRResponse{Pages: []Page{
+		{Markdown: "The results of the", Images: []Image{{ID: "img-0.jpeg"}}},
+		{Markdown: "study."},
+	}}
+
+	text, images := extractText(resp, false)
+	if text != "The results of the\n\nstudy.\n\n" || images != 1 {
+		t.Errorf("expected pages joined as-is, got %q with %d images", text, images)
+	}
+
+	text, images = extractText(resp, true)
+	if text != "The results of the study.\n" || images != 1 {
+		t.Errorf("expected reflowed text, got %q with %d images", text, images)
+	}
+}
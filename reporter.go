@@ -3,32 +3,59 @@ package main
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
-// Reporter handles progress and verbose output.
+// Reporter handles progress, verbose, and error output.
 type Reporter struct {
 	w       io.Writer
+	errw    io.Writer
 	verbose bool
+	secrets []string
 }
 
 // NewReporter creates a reporter that writes to w.
-// If quiet is true, all output is suppressed.
+// If quiet is true, all output but errors is suppressed.
 // If verbose is true, extra details are shown.
 func NewReporter(w io.Writer, quiet, verbose bool) *Reporter {
 	if quiet {
-		return &Reporter{w: io.Discard}
+		return &Reporter{w: io.Discard, errw: w}
+	}
+	return &Reporter{w: w, errw: w, verbose: verbose}
+}
+
+// Redact hides secret in all further messages.
+func (r *Reporter) Redact(secret string) {
+	if secret != "" {
+		r.secrets = append(r.secrets, secret)
 	}
-	return &Reporter{w: w, verbose: verbose}
 }
 
 // Progress prints a progress message.
 func (r *Reporter) Progress(format string, args ...any) {
-	fmt.Fprintf(r.w, format, args...)
+	r.print(format, args...)
 }
 
 // Verbose prints a message only in verbose mode.
 func (r *Reporter) Verbose(format string, args ...any) {
 	if r.verbose {
-		fmt.Fprintf(r.w, format, args...)
+		r.print(format, args...)
+	}
+}
+
+// Error prints an error message, even in quiet mode.
+func (r *Reporter) Error(format string, args ...any) {
+	r.fprint(r.errw, "Error: "+format, args...)
+}
+
+func (r *Reporter) print(format string, args ...any) {
+	r.fprint(r.w, format, args...)
+}
+
+func (r *Reporter) fprint(w io.Writer, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	for _, secret := range r.secrets {
+		msg = strings.ReplaceAll(msg, secret, redacted)
 	}
+	io.WriteString(w, msg)
 }
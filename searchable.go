@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// Layout of the searchable PDF, in points.
+const (
+	pointsPerInch     = 72
+	defaultPageWidth  = 595 // A4
+	defaultPageHeight = 842
+	textMargin        = 36 // at most; less on small pages
+	maxFontSize       = 11
+	minFontSize       = 2
+)
+
+// pdfImage is an image XObject: its dictionary entries other than Type,
+// Subtype, Width, Height, and Length, and its encoded data.
+type pdfImage struct {
+	Width, Height int
+	Dict          string
+	Data          []byte
+}
+
+// scanImage is an image covering a whole page, and the page's size in
+// points if it is known.
+type scanImage struct {
+	Image         pdfImage
+	Width, Height float64
+}
+
+// writeSearchablePDF writes a PDF with a page for each page of the OCR
+// result: the scanned page image, or else the extracted images at their
+// positions, under an invisible layer of the recognized text.
+func writeSearchablePDF(src formatSource) ([]byte, error) {
+	w := newPDFWriter()
+	catalog, pagesObj, font, info := w.reserve(), w.reserve(), w.reserve(), w.reserve()
+	scans := scanImages(src.Data, w)
+
+	var kids []string
+	for _, page := range src.Resp.Pages {
+		width, height := pageSizePoints(page, scans[page.Index])
+		var content strings.Builder
+		xobjects := make(map[string]int)
+
+		addImage := func(img pdfImage, x, y, dw, dh float64) {
+			name := fmt.Sprintf("Im%d", len(xobjects))
+			num := w.reserve()
+			w.stream(num, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d %s", img.Width, img.Height, img.Dict), img.Data)
+			xobjects[name] = num
+			fmt.Fprintf(&content, "q %s 0 0 %s %s %s cm /%s Do Q\n", pdfNum(dw), pdfNum(dh), pdfNum(x), pdfNum(y), name)
+		}
+
+		if scan, ok := scans[page.Index]; ok {
+			addImage(scan.Image, 0, 0, width, height)
+		} else if page.Dimensions != nil && page.Dimensions.Width > 0 && page.Dimensions.Height > 0 {
+			sx := width / float64(page.Dimensions.Width)
+			sy := height / float64(page.Dimensions.Height)
+			for _, img := range page.Images {
+				xobj, err := imageXObject(decodeImageData(img))
+				if err != nil {
+					continue
+				}
+				x0, y0 := float64(img.TopLeftX)*sx, float64(img.TopLeftY)*sy
+				x1, y1 := float64(img.BottomRightX)*sx, float64(img.BottomRightY)*sy
+				if x1 <= x0 || y1 <= y0 {
+					continue
+				}
+				addImage(xobj, x0, height-y1, x1-x0, y1-y0)
+			}
+		}
+
+		// Without an image to show, such as for a WebP image or a digital
+		// PDF page, the text is shown instead, so that the page isn't blank.
+		writeTextLayer(&content, pageTextLines(page.Markdown), width, height, len(xobjects) == 0)
+
+		contentObj := w.reserve()
+		w.compressedStream(contentObj, "", []byte(content.String()))
+
+		var resources strings.Builder
+		fmt.Fprintf(&resources, "/Font << /F1 %d 0 R >>", font)
+		if len(xobjects) > 0 {
+			resources.WriteString(" /XObject <<")
+			for i := range len(xobjects) {
+				name := fmt.Sprintf("Im%d", i)
+				fmt.Fprintf(&resources, " /%s %d 0 R", name, xobjects[name])
+			}
+			resources.WriteString(" >>")
+		}
+
+		pageObj := w.reserve()
+		w.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << %s >> /Contents %d 0 R >>",
+			pagesObj, pdfNum(width), pdfNum(height), resources.String(), contentObj))
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+	}
+
+	w.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	w.object(font, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	w.object(info, fmt.Sprintf("<< /Title %s /Producer (ocr) /CreationDate (D:%s) >>",
+		pdfTextString(filepath.Base(src.Path)), src.Time.UTC().Format("20060102150405Z")))
+
+	return w.finish(catalog, info), nil
+}
+
+// pageSizePoints returns the size of a page in points: the size of the
+// original PDF page if known, or else the rendered size at its resolution.
+func pageSizePoints(page Page, scan scanImage) (float64, float64) {
+	if scan.Width > 0 && scan.Height > 0 {
+		return scan.Width, scan.Height
+	}
+	if d := page.Dimensions; d != nil && d.Width > 0 && d.Height > 0 {
+		dpi := d.DPI
+		if dpi <= 0 {
+			dpi = pointsPerInch
+		}
+		return float64(d.Width) * pointsPerInch / float64(dpi), float64(d.Height) * pointsPerInch / float64(dpi)
+	}
+	if scan.Image.Width > 0 && scan.Image.Height > 0 {
+		return float64(scan.Image.Width), float64(scan.Image.Height)
+	}
+	return defaultPageWidth, defaultPageHeight
+}
+
+// pageTextLines returns the plain text lines of a page's Markdown.
+func pageTextLines(md string) []string {
+	var lines []string
+	for _, block := range markdownBlocks(md) {
+		for _, line := range block.plainLines() {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// writeTextLayer writes the text as invisible text (render mode 3), or
+// visible text if visible is set, from the top of the page down, wrapped to
+// the page width at the largest font size at which it fits. Selecting and
+// searching the text works, although the words are not placed exactly over
+// their images.
+func writeTextLayer(content *strings.Builder, lines []string, width, height float64, visible bool) {
+	if len(lines) == 0 {
+		return
+	}
+
+	margin := min(textMargin, width/20, height/20)
+	var size float64
+	var wrapped []string
+	for size = maxFontSize; size > minFontSize; size-- {
+		wrapped = wrapLines(lines, width-2*margin, size)
+		if float64(len(wrapped))*size*1.2 <= height-2*margin {
+			break
+		}
+	}
+
+	leading := size * 1.2
+	mode := 3
+	if visible {
+		mode = 0
+	}
+	fmt.Fprintf(content, "BT %d Tr /F1 %s Tf %s TL %s %s Td\n", mode, pdfNum(size), pdfNum(leading), pdfNum(margin), pdfNum(height-margin-size))
+	for _, line := range wrapped {
+		fmt.Fprintf(content, "%s Tj T*\n", pdfWinAnsiString(line))
+	}
+	content.WriteString("ET\n")
+}
+
+// wrapLines wraps lines at word boundaries to fit width at the font size,
+// estimating the width of a character as half the font size.
+func wrapLines(lines []string, width, size float64) []string {
+	maxChars := max(int(width/(size*0.5)), 10)
+
+	var out []string
+	for _, line := range lines {
+		var current string
+		for _, word := range strings.Fields(line) {
+			switch {
+			case current == "":
+				current = word
+			case len([]rune(current))+1+len([]rune(word)) > maxChars:
+				out = append(out, current)
+				current = word
+			default:
+				current += " " + word
+			}
+		}
+		if current != "" {
+			out = append(out, current)
+		}
+	}
+	return out
+}
+
+func pdfNum(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
+
+// decodeImageData returns the data of an extracted image, or nil if it
+// can't be decoded.
+func decodeImageData(img Image) []byte {
+	data, _ := img.Data()
+	return data
+}
+
+// imageXObject converts a JPEG, PNG, or GIF image to an image XObject.
+// JPEG images are embedded as they are; others are decoded and stored as
+// compressed RGB.
+func imageXObject(data []byte) (pdfImage, error) {
+	if len(data) == 0 {
+		return pdfImage{}, fmt.Errorf("no image data")
+	}
+
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(data)); err == nil {
+		img := pdfImage{Width: cfg.Width, Height: cfg.Height, Data: data}
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			img.Dict = "/ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode"
+		case color.CMYKModel:
+			// Adobe CMYK JPEGs store inverted values.
+			img.Dict = "/ColorSpace /DeviceCMYK /BitsPerComponent 8 /Decode [1 0 1 0 1 0 1 0] /Filter /DCTDecode"
+		default:
+			img.Dict = "/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode"
+		}
+		return img, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, fmt.Errorf("decoding image: %w", err)
+	}
+	bounds := decoded.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			// Transparent pixels are drawn on white.
+			a := uint32(c.A)
+			blend := func(v uint8) byte { return byte((uint32(v)*a + 255*(255-a)) / 255) }
+			rgb = append(rgb, blend(c.R), blend(c.G), blend(c.B))
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(rgb)
+	zw.Close()
+	return pdfImage{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Dict:   "/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+		Data:   buf.Bytes(),
+	}, nil
+}
+
+// scanImages returns the images of a scanned document by page index: the
+// document itself if it is an image, or, for each page of a PDF that
+// consists of a single image with the page's proportions, that image. The
+// objects the images of a PDF refer to, such as ICC profiles, are copied to
+// w.
+func scanImages(data []byte, w *pdfWriter) map[int]scanImage {
+	scans := make(map[int]scanImage)
+
+	switch detectMIMEType(data) {
+	case "image/jpeg", "image/png", "image/gif":
+		if img, err := imageXObject(data); err == nil {
+			scans[0] = scanImage{Image: img}
+		}
+		return scans
+	case "application/pdf":
+	default:
+		return scans
+	}
+
+	f, err := parsePDF(data)
+	if err != nil || f.Encrypted() {
+		return scans
+	}
+
+	c := newPDFCopier(f, w)
+	defer c.flush()
+	for i, page := range f.Pages() {
+		stream, width, height, ok := pageScan(f, page)
+		if !ok {
+			continue
+		}
+		// The image is copied as it is, with the objects it refers to.
+		imgWidth, _ := pdfInt(f.resolve(stream.Dict["Width"]))
+		imgHeight, _ := pdfInt(f.resolve(stream.Dict["Height"]))
+		img := pdfImage{
+			Width:  imgWidth,
+			Height: imgHeight,
+			Dict:   c.entries(stream.Dict, "Type", "Subtype", "Width", "Height", "Length"),
+			Data:   stream.Data,
+		}
+		scans[i] = scanImage{Image: img, Width: width, Height: height}
+	}
+	return scans
+}
+
+// pageScan returns the image of a PDF page that is a scan: the page's only
+// image XObject, with the page's proportions, so that it is drawn over the
+// whole page. It also returns the size of the page in points.
+func pageScan(f *pdfFile, page pdfDict) (*pdfStream, float64, float64, bool) {
+	box, _ := f.resolve(page["MediaBox"]).([]any)
+	if len(box) != 4 {
+		return nil, 0, 0, false
+	}
+	var coords [4]float64
+	for j, v := range box {
+		coords[j] = pdfFloat(f.resolve(v))
+	}
+	width, height := coords[2]-coords[0], coords[3]-coords[1]
+	if width <= 0 || height <= 0 {
+		return nil, 0, 0, false
+	}
+
+	resources, _ := f.resolve(page["Resources"]).(pdfDict)
+	xobjects, _ := f.resolve(resources["XObject"]).(pdfDict)
+	var found *pdfStream
+	for _, v := range xobjects {
+		stream, ok := f.resolve(v).(*pdfStream)
+		if !ok || stream.Dict["Subtype"] != pdfName("Image") {
+			continue
+		}
+		if found != nil {
+			return nil, 0, 0, false
+		}
+		found = stream
+	}
+	if found == nil {
+		return nil, 0, 0, false
+	}
+
+	imgWidth, _ := pdfInt(f.resolve(found.Dict["Width"]))
+	imgHeight, _ := pdfInt(f.resolve(found.Dict["Height"]))
+	ratio := float64(imgWidth) / float64(imgHeight)
+	if imgWidth <= 0 || imgHeight <= 0 || math.Abs(ratio-width/height) > 0.05*width/height {
+		return nil, 0, 0, false
+	}
+	return found, width, height, true
+}
+
+// scanPageImages returns the scanned image of each page of a PDF as a JPEG
+// or PNG file. JPEG images are extracted as they are; gray and RGB images
+// without compression or with FlateDecode are converted to PNG. It fails
+// for a page that isn't a scan, such as the page of a born-digital PDF, or
+// whose image is encoded otherwise.
+func scanPageImages(data []byte) ([][]byte, error) {
+	f, err := parsePDF(data)
+	if err != nil {
+		return nil, err
+	}
+	pages := f.Pages()
+	files := make([][]byte, len(pages))
+	for i, page := range pages {
+		stream, _, _, ok := pageScan(f, page)
+		if !ok {
+			return nil, fmt.Errorf("page %d is not a scanned image", i+1)
+		}
+		if files[i], err = scanImageFile(f, stream); err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+	}
+	return files, nil
+}
+
+// scanImageFile converts an image XObject to a JPEG or PNG file.
+func scanImageFile(f *pdfFile, stream *pdfStream) ([]byte, error) {
+	filter := f.resolve(stream.Dict["Filter"])
+	if filters, ok := filter.([]any); ok && len(filters) == 1 {
+		filter = f.resolve(filters[0])
+	}
+	switch filter {
+	case pdfName("DCTDecode"):
+		return stream.Data, nil
+	case nil, pdfName("FlateDecode"):
+	default:
+		return nil, fmt.Errorf("unsupported image filter %v", filter)
+	}
+	parms, _ := f.resolve(stream.Dict["DecodeParms"]).(pdfDict)
+	if predictor, _ := pdfInt(f.resolve(parms["Predictor"])); predictor > 1 {
+		return nil, fmt.Errorf("unsupported image predictor %d", predictor)
+	}
+
+	// ICC profiles are taken as gray or RGB by their number of components.
+	var components int
+	switch cs := f.resolve(stream.Dict["ColorSpace"]).(type) {
+	case pdfName:
+		components = map[pdfName]int{"DeviceGray": 1, "DeviceRGB": 3}[cs]
+	case []any:
+		if len(cs) == 2 && f.resolve(cs[0]) == pdfName("ICCBased") {
+			if profile, ok := f.resolve(cs[1]).(*pdfStream); ok {
+				components, _ = pdfInt(f.resolve(profile.Dict["N"]))
+			}
+		}
+	}
+	bits, _ := pdfInt(f.resolve(stream.Dict["BitsPerComponent"]))
+	if components != 1 && components != 3 || bits != 8 && (bits != 1 || components != 1) {
+		return nil, fmt.Errorf("unsupported image color space")
+	}
+
+	pix, err := stream.decode()
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	width, _ := pdfInt(f.resolve(stream.Dict["Width"]))
+	height, _ := pdfInt(f.resolve(stream.Dict["Height"]))
+	stride := (width*components*bits + 7) / 8
+	if len(pix)/stride < height {
+		return nil, fmt.Errorf("image data is truncated")
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	var img image.Image
+	switch {
+	case bits == 1:
+		gray := image.NewGray(rect)
+		for y := range height {
+			for x := range width {
+				if pix[y*stride+x/8]&(0x80>>(x%8)) != 0 {
+					gray.Pix[y*gray.Stride+x] = 0xff
+				}
+			}
+		}
+		img = gray
+	case components == 1:
+		img = &image.Gray{Pix: pix, Stride: stride, Rect: rect}
+	default:
+		rgba := image.NewRGBA(rect)
+		for y := range height {
+			for x := range width {
+				copy(rgba.Pix[y*rgba.Stride+x*4:], pix[y*stride+x*3:y*stride+x*3+3])
+				rgba.Pix[y*rgba.Stride+x*4+3] = 0xff
+			}
+		}
+		img = rgba
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfFloat converts a parsed numeric object to a float64.
+func pdfFloat(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
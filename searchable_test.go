@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testImage(t *testing.T, width, height int, encode func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(buf *bytes.Buffer, img image.Image) error { return jpeg.Encode(buf, img, nil) }
+func encodePNG(buf *bytes.Buffer, img image.Image) error  { return png.Encode(buf, img) }
+
+// pageContent returns the decoded content stream and the image XObjects of
+// a page of a PDF written by writeSearchablePDF.
+func pageContent(t *testing.T, f *pdfFile, page pdfDict) (string, []*pdfStream) {
+	t.Helper()
+
+	stream, ok := f.resolve(page["Contents"]).(*pdfStream)
+	if !ok {
+		t.Fatalf("page has no content stream: %v", page)
+	}
+	content, err := stream.decode()
+	if err != nil {
+		t.Fatalf("failed to decode content: %v", err)
+	}
+
+	var images []*pdfStream
+	resources, _ := f.resolve(page["Resources"]).(pdfDict)
+	xobjects, _ := f.resolve(resources["XObject"]).(pdfDict)
+	for _, v := range xobjects {
+		if img, ok := f.resolve(v).(*pdfStream); ok {
+			images = append(images, img)
+		}
+	}
+	return string(content), images
+}
+
+// checkXref verifies that the cross-reference table of a written PDF points
+// at its objects.
+func checkXref(t *testing.T, data []byte) {
+	t.Helper()
+
+	idx := bytes.LastIndex(data, []byte("startxref\n"))
+	var xref int
+	if idx == -1 {
+		t.Fatal("no startxref")
+	}
+	fmt.Sscanf(string(data[idx+len("startxref\n"):]), "%d", &xref)
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n0 ")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	lines := strings.Split(string(data[xref:]), "\n")
+	var count int
+	fmt.Sscanf(lines[1], "0 %d", &count)
+	for num := 1; num < count; num++ {
+		var offset int
+		fmt.Sscanf(lines[2+num], "%d", &offset)
+		if want := fmt.Sprintf("%d 0 obj", num); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q", num, data[offset:min(offset+10, len(data))])
+		}
+	}
+}
+
+func TestWriteSearchablePDF_Image(t *testing.T) {
+	scan := testImage(t, 100, 140, encodeJPEG)
+	resp := &OCRResponse{Pages: []Page{{
+		Index:      0,
+		Markdown:   "# Invoice (copy)\n\nTotal: 5 € for the café\n\n| Item | Price |\n|---|---|\n| Tea | 2 |",
+		Dimensions: &PageDimensions{DPI: 200, Width: 1000, Height: 1400},
+	}}}
+
+	data, err := writeSearchablePDF(formatSource{Path: "scan.jpg", Data: scan, Resp: resp})
+	if err != nil {
+		t.Fatalf("writeSearchablePDF failed: %v", err)
+	}
+	checkXref(t, data)
+
+	f, err := parsePDF(data)
+	if err != nil {
+		t.Fatalf("failed to parse output: %v", err)
+	}
+	pages := f.Pages()
+	if len(pages) != 1 || f.PageCount() != 1 {
+		t.Fatalf("expected 1 page, got %d", len(pages))
+	}
+	if box, _ := formatPDFObject(f, pages[0]["MediaBox"], 0); box != "[0 0 360 504]" {
+		t.Errorf("expected page size from the dimensions at 200 dpi, got %s", box)
+	}
+
+	content, images := pageContent(t, f, pages[0])
+	if len(images) != 1 || images[0].Dict["Filter"] != pdfName("DCTDecode") || !bytes.Equal(images[0].Data, scan) {
+		t.Errorf("expected the input JPEG as the page image, got %d images", len(images))
+	}
+	if !strings.Contains(content, "q 360 0 0 504 0 0 cm /Im0 Do Q") {
+		t.Errorf("expected the image over the whole page, got:\n%s", content)
+	}
+	for _, want := range []string{"3 Tr", `(Invoice \(copy\)) Tj`, `(Total: 5 \200 for the caf\351) Tj`, "(Item Price) Tj", "(Tea 2) Tj"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("expected %q in content, got:\n%s", want, content)
+		}
+	}
+}
+
+func TestWriteSearchablePDF_PDF(t *testing.T) {
+	scan := testImage(t, 85, 110, encodeJPEG)
+	figure := testImage(t, 4, 2, encodePNG)
+
+	// A scanned page with a single image, and a digital page without one.
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n")
+	b.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	b.WriteString("2 0 obj\n<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /MediaBox [0 0 612 792] >>\nendobj\n")
+	b.WriteString("3 0 obj\n<< /Type /Page /Parent 2 0 R /Resources << /XObject << /Scan 5 0 R >> >> >>\nendobj\n")
+	b.WriteString("4 0 obj\n<< /Type /Page /Parent 2 0 R >>\nendobj\n")
+	fmt.Fprintf(&b, "5 0 obj\n<< /Type /XObject /Subtype /Image /Width 85 /Height 110 /ColorSpace [/ICCBased 6 0 R] /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n", len(scan))
+	b.Write(scan)
+	b.WriteString("\nendstream\nendobj\n")
+	b.WriteString("6 0 obj\n<< /N 3 /Length 7 >>\nstream\nprofile\nendstream\nendobj\n")
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	resp := &OCRResponse{Pages: []Page{
+		{Index: 0, Markdown: "Scanned text.", Dimensions: &PageDimensions{DPI: 200, Width: 1700, Height: 2200}},
+		{
+			Index:      1,
+			Markdown:   "Digital text.\n\n![img-0.png](img-0.png)",
+			Dimensions: &PageDimensions{DPI: 200, Width: 1700, Height: 2200},
+			Images: []Image{{
+				ID: "img-0.png", TopLeftX: 100, TopLeftY: 200, BottomRightX: 500, BottomRightY: 400,
+				ImageBase64: "data:image/png;base64," + base64.StdEncoding.EncodeToString(figure),
+			}},
+		},
+	}}
+
+	data, err := writeSearchablePDF(formatSource{Path: "scan.pdf", Data: b.Bytes(), Resp: resp})
+	if err != nil {
+		t.Fatalf("writeSearchablePDF failed: %v", err)
+	}
+	f, err := parsePDF(data)
+	if err != nil {
+		t.Fatalf("failed to parse output: %v", err)
+	}
+	pages := f.Pages()
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(pages))
+	}
+
+	content, images := pageContent(t, f, pages[0])
+	if len(images) != 1 || !bytes.Equal(images[0].Data, scan) {
+		t.Fatalf("expected the scanned image to be copied, got %d images", len(images))
+	}
+	colorSpace, _ := f.resolve(images[0].Dict["ColorSpace"]).([]any)
+	if profile, ok := f.resolve(colorSpace[len(colorSpace)-1]).(*pdfStream); len(colorSpace) != 2 || !ok || string(profile.Data) != "profile" {
+		t.Errorf("expected the ICC profile copied with the image, got %v", colorSpace)
+	}
+	if box, _ := formatPDFObject(f, pages[0]["MediaBox"], 0); box != "[0 0 612 792]" {
+		t.Errorf("expected the original page size, got %s", box)
+	}
+	if !strings.Contains(content, "q 612 0 0 792 0 0 cm /Im0 Do Q") || !strings.Contains(content, "(Scanned text.) Tj") {
+		t.Errorf("unexpected content of scanned page:\n%s", content)
+	}
+
+	content, images = pageContent(t, f, pages[1])
+	if len(images) != 1 || images[0].Dict["Filter"] != pdfName("FlateDecode") {
+		t.Fatalf("expected the extracted PNG as a compressed image, got %d images", len(images))
+	}
+	if pixels, err := images[0].decode(); err != nil || len(pixels) != 4*2*3 {
+		t.Errorf("expected 4x2 RGB pixels, got %d bytes (%v)", len(pixels), err)
+	}
+	// 1700x2200 pixels at 200 dpi is 612x792 points: a scale of 0.36.
+	if !strings.Contains(content, "q 144 0 0 72 36 648 cm /Im0 Do Q") || !strings.Contains(content, "3 Tr") {
+		t.Errorf("expected the image at its bounding box under invisible text, got:\n%s", content)
+	}
+}
+
+func TestWriteSearchablePDF_NoImage(t *testing.T) {
+	// WebP images can't be embedded, so the text is shown instead.
+	webp := []byte("RIFF\x1a\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00\x63\x00\x00\x8b\x00\x00")
+	resp := &OCRResponse{Pages: []Page{{Index: 0, Markdown: "Scanned text.", Dimensions: &PageDimensions{DPI: 200, Width: 1000, Height: 1400}}}}
+
+	data, err := writeSearchablePDF(formatSource{Path: "scan.webp", Data: webp, Resp: resp})
+	if err != nil {
+		t.Fatalf("writeSearchablePDF failed: %v", err)
+	}
+	f, err := parsePDF(data)
+	if err != nil {
+		t.Fatalf("failed to parse output: %v", err)
+	}
+	content, images := pageContent(t, f, f.Pages()[0])
+	if len(images) != 0 || !strings.Contains(content, "BT 0 Tr") || !strings.Contains(content, "(Scanned text.) Tj") {
+		t.Errorf("expected the text shown on a page without an image, got:\n%s", content)
+	}
+}
+
+// buildScanPDF returns a PDF with a page for each image, drawn over the
+// whole page.
+func buildScanPDF(images ...pdfImage) []byte {
+	w := newPDFWriter()
+	catalog, pagesObj, info := w.reserve(), w.reserve(), w.reserve()
+	var kids []string
+	for _, img := range images {
+		imgObj, contentObj, pageObj := w.reserve(), w.reserve(), w.reserve()
+		w.stream(imgObj, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d %s", img.Width, img.Height, img.Dict), img.Data)
+		w.stream(contentObj, "", []byte(fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", img.Width, img.Height)))
+		w.object(pageObj, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, img.Width, img.Height, imgObj, contentObj))
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+	}
+	w.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	w.object(info, "<< /Producer (ocr) >>")
+	return w.finish(catalog, info)
+}
+
+func TestScanPageImages(t *testing.T) {
+	jpegScan := testImage(t, 8, 6, encodeJPEG)
+	jpegImage, _ := imageXObject(jpegScan)
+	rgbImage, _ := imageXObject(testImage(t, 8, 6, encodePNG))
+	// A bilevel image, black with a white first column.
+	bilevel := pdfImage{Width: 8, Height: 2, Dict: "/ColorSpace /DeviceGray /BitsPerComponent 1", Data: []byte{0x80, 0x80}}
+
+	files, err := scanPageImages(buildScanPDF(jpegImage, rgbImage, bilevel))
+	if err != nil {
+		t.Fatalf("scanPageImages failed: %v", err)
+	}
+	if len(files) != 3 || !bytes.Equal(files[0], jpegScan) {
+		t.Fatalf("expected 3 pages with the JPEG as it is, got %d", len(files))
+	}
+
+	rgb, err := png.Decode(bytes.NewReader(files[1]))
+	if err != nil || rgb.Bounds().Dx() != 8 || rgb.Bounds().Dy() != 6 {
+		t.Fatalf("expected an 8x6 PNG, got %v (%v)", rgb.Bounds(), err)
+	}
+	if r, g, b, _ := rgb.At(3, 2).RGBA(); r>>8 != 3 || g>>8 != 2 || b>>8 != 128 {
+		t.Errorf("expected the pixel colors kept, got %d %d %d", r>>8, g>>8, b>>8)
+	}
+	gray, err := png.Decode(bytes.NewReader(files[2]))
+	if err != nil {
+		t.Fatalf("expected a PNG, got %v", err)
+	}
+	if c := color.GrayModel.Convert(gray.At(0, 1)).(color.Gray); c.Y != 0xff {
+		t.Errorf("expected a white first column, got %v", c)
+	}
+	if c := color.GrayModel.Convert(gray.At(1, 1)).(color.Gray); c.Y != 0 {
+		t.Errorf("expected black elsewhere, got %v", c)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"born-digital", buildTestPDF(1, false), "page 1 is not a scanned image"},
+		{"CCITT", buildScanPDF(jpegImage, pdfImage{Width: 8, Height: 6, Dict: "/Filter /CCITTFaxDecode", Data: []byte{0}}),
+			"page 2: unsupported image filter CCITTFaxDecode"},
+		{"truncated", buildScanPDF(pdfImage{Width: 8, Height: 6, Dict: "/ColorSpace /DeviceRGB /BitsPerComponent 8", Data: []byte{0}}),
+			"page 1: image data is truncated"},
+	} {
+		if _, err := scanPageImages(tt.data); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: expected %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	formats, err := parseFormats(" Markdown, searchable-pdf,markdown")
+	if err != nil || len(formats) != 2 || formats[0] != "markdown" || formats[1] != "searchable-pdf" {
+		t.Errorf("unexpected formats %q (%v)", formats, err)
+	}
+
+	if _, err := parseFormats("markdown,pdf"); err == nil || !strings.Contains(err.Error(), `unknown output format "pdf"`) {
+		t.Errorf("expected unknown format error, got %v", err)
+	}
+	if _, err := parseFormats(" , "); err == nil {
+		t.Error("expected error for no formats")
+	}
+}
+
+func TestProcessFile_Formats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OCRResponse{Pages: []Page{{Index: 0, Markdown: "Searchable text."}}})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: filepath.Join(dir, "scan.pdf"), Data: buildTestPDF(1, false), Dir: dir}
+	ro := runOptions{Formats: []string{"searchable-pdf"}}
+
+	if _, err := processFile(context.Background(), client, doc, ro, NewReporter(io.Discard, true, false)); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "scan.md")); !os.IsNotExist(err) {
+		t.Errorf("expected no Markdown file without the markdown format, got %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "scan.ocr.pdf"))
+	if err != nil {
+		t.Fatalf("expected searchable PDF: %v", err)
+	}
+	f, err := parsePDF(data)
+	if err != nil || f.PageCount() != 1 {
+		t.Errorf("expected a 1-page PDF, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// processSplit processes a document that exceeds the per-request page or
+// size limits. Oversized documents are uploaded once through the Files API and
+// referenced by a signed URL. The pages of a PDF are then requested in ranges
+// of at most opts.MaxPagesPerRequest pages, opts.Concurrency at a time, and
+// the responses are merged with mergeResponses; other documents are
+// requested whole. Each range is sent with request.
+func (c *Client) processSplit(ctx context.Context, info *DocumentInfo, data []byte, opts OCROptions,
+	request func(context.Context, OCRRequest) (*OCRResponse, error)) (*OCRResponse, error) {
+	var documentURL string
+	if len(data) > maxInlineDocumentSize {
+		fileID, err := c.uploadFile(ctx, info.Name, data)
+		if err != nil {
+			return nil, err
+		}
+		defer c.deleteFile(context.WithoutCancel(ctx), fileID)
+
+		documentURL, err = c.signedURL(ctx, fileID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		documentURL = dataURL(info.MIMEType, data)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := documentRanges(info, opts)
+	results := make([]*OCRResponse, len(chunks))
+	errs := make(chan error, len(chunks))
+	sem := make(chan struct{}, opts.concurrency())
+
+	var wg sync.WaitGroup
+	for i, pages := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			resp, err := request(ctx, c.newOCRRequest(newDocumentURL(info.MIMEType, documentURL), pages, opts))
+			if err != nil {
+				errs <- fmt.Errorf("processing %s: %w", describePages(pages), err)
+				cancel()
+				return
+			}
+			results[i] = resp
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// The first error is the one that cancelled the remaining requests.
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return mergeResponses(chunks, results), nil
+}
+
+// documentRanges returns the page ranges a document is requested in: a
+// single nil range for the whole document unless it is Splittable.
+func documentRanges(info *DocumentInfo, opts OCROptions) [][]int {
+	if !info.Splittable() {
+		return [][]int{nil}
+	}
+	return pageRanges(info.Pages, opts.maxPages())
+}
+
+// pageRanges splits pageCount zero-based page indexes into consecutive
+// ranges of at most size pages. An unknown page count yields a single nil
+// range, which requests the whole document.
+func pageRanges(pageCount, size int) [][]int {
+	if pageCount <= 0 {
+		return [][]int{nil}
+	}
+
+	var ranges [][]int
+	for start := 0; start < pageCount; start += size {
+		end := min(start+size, pageCount)
+		pages := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			pages = append(pages, i)
+		}
+		ranges = append(ranges, pages)
+	}
+	return ranges
+}
+
+// describePages formats a page range for error messages using one-based
+// page numbers.
+func describePages(pages []int) string {
+	if len(pages) == 0 {
+		return "document"
+	}
+	return fmt.Sprintf("pages %d-%d", pages[0]+1, pages[len(pages)-1]+1)
+}
+
+// mergeResponses combines the responses for the page ranges in chunks into a
+// single response. Page indexes are made global, images are renumbered across
+// the whole document (rewriting the Markdown references to them), usage is
+// summed, and the document annotations are merged with mergeAnnotations.
+func mergeResponses(chunks [][]int, results []*OCRResponse) *OCRResponse {
+	merged := &OCRResponse{}
+	imgIndex := 0
+
+	for i, resp := range results {
+		pages := chunks[i]
+
+		for j, page := range resp.Pages {
+			// The API reports indexes relative to the full document, but be
+			// defensive about servers that number the requested pages from 0.
+			if j < len(pages) && !slices.Contains(pages, page.Index) {
+				page.Index = pages[j]
+			}
+
+			var refs []string
+			images := make([]Image, len(page.Images))
+			for k, img := range page.Images {
+				id := fmt.Sprintf("img-%d%s", imgIndex, path.Ext(img.ID))
+				imgIndex++
+				if img.ID != "" && img.ID != id {
+					refs = append(refs, "!["+img.ID+"]", "!["+id+"]", "("+img.ID+")", "("+id+")")
+				}
+				img.ID = id
+				images[k] = img
+			}
+			page.Images = images
+			if len(refs) > 0 {
+				page.Markdown = strings.NewReplacer(refs...).Replace(page.Markdown)
+			}
+
+			merged.Pages = append(merged.Pages, page)
+		}
+
+		merged.DocumentAnnotation = mergeAnnotations(merged.DocumentAnnotation, resp.DocumentAnnotation)
+
+		if merged.Model == "" {
+			merged.Model = resp.Model
+		}
+
+		// Every range reports the size of the whole document, but bills
+		// only its own pages.
+		if resp.UsageInfo != nil {
+			if merged.UsageInfo == nil {
+				merged.UsageInfo = &UsageInfo{}
+			}
+			merged.UsageInfo.PagesProcessed += resp.UsageInfo.PagesProcessed
+			merged.UsageInfo.DocSizeBytes = max(merged.UsageInfo.DocSizeBytes, resp.UsageInfo.DocSizeBytes)
+		}
+	}
+
+	slices.SortStableFunc(merged.Pages, func(a, b Page) int {
+		return a.Index - b.Index
+	})
+
+	return merged
+}
+
+// mergeAnnotations merges the document annotations of two page ranges into
+// one. Objects are merged key by key, arrays are concatenated, and for all
+// other values the first non-empty one wins. Annotations returned as
+// string-encoded JSON are decoded first.
+func mergeAnnotations(a, b any) any {
+	return mergeAnnotationValues(decodeAnnotation(a), decodeAnnotation(b))
+}
+
+func mergeAnnotationValues(a, b any) any {
+	if a == nil || a == "" {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			return a
+		}
+		out := maps.Clone(av)
+		for k, v := range bv {
+			out[k] = mergeAnnotationValues(out[k], v)
+		}
+		return out
+	case []any:
+		if bv, ok := b.([]any); ok {
+			return append(slices.Clone(av), bv...)
+		}
+	}
+
+	return a
+}
+
+// decodeAnnotation parses an annotation the API returned as a JSON string.
+// Other values, and strings that are not JSON, are returned unchanged.
+func decodeAnnotation(annotation any) any {
+	str, ok := annotation.(string)
+	if !ok {
+		return annotation
+	}
+
+	var parsed any
+	if err := json.Unmarshal([]byte(str), &parsed); err != nil {
+		return annotation
+	}
+	return parsed
+}
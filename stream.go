@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxDataURLHeader is the longest "data:<type>;base64," prefix looked for
+// at the start of streamed image data.
+const maxDataURLHeader = 256
+
+// decodeResponseStream decodes an OCR response from r as it is read, calling
+// fn with each page in turn, so that only one page is held in memory at a
+// time. When imageDir is set, image data is decoded straight to a file in it
+// and Image.File is set instead of Image.ImageBase64. The returned response
+// has everything but the pages.
+func decodeResponseStream(r io.Reader, imageDir string, fn func(Page) error) (*OCRResponse, error) {
+	s := &jsonScanner{r: bufio.NewReader(r)}
+
+	// Errors returned by fn are passed on as they are.
+	var fnErr error
+	fields := map[string]json.RawMessage{}
+	err := s.object(func(key string) error {
+		if key != "pages" {
+			raw, err := s.raw()
+			fields[key] = raw
+			return err
+		}
+		return s.array(func() error {
+			page, err := s.page(imageDir)
+			if err != nil {
+				return err
+			}
+			fnErr = fn(page)
+			return fnErr
+		})
+	})
+	if fnErr != nil {
+		return nil, fnErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	var resp OCRResponse
+	if err := unmarshalFields(fields, &resp); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &resp, nil
+}
+
+// page decodes the page at the current position.
+func (s *jsonScanner) page(imageDir string) (Page, error) {
+	var page Page
+	var images []Image
+	fields := map[string]json.RawMessage{}
+	err := s.object(func(key string) error {
+		if key != "images" {
+			raw, err := s.raw()
+			fields[key] = raw
+			return err
+		}
+		return s.array(func() error {
+			img, err := s.image(imageDir)
+			images = append(images, img)
+			return err
+		})
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	if err := unmarshalFields(fields, &page); err != nil {
+		return Page{}, err
+	}
+	page.Images = images
+	return page, nil
+}
+
+// image decodes the image at the current position, writing its data to a
+// file in imageDir if set.
+func (s *jsonScanner) image(imageDir string) (Image, error) {
+	var img Image
+	var file string
+	var decodeErr error
+	fields := map[string]json.RawMessage{}
+	err := s.object(func(key string) error {
+		if c, err := s.peek(); err != nil || key != "image_base64" || imageDir == "" || c != '"' {
+			raw, err := s.raw()
+			fields[key] = raw
+			return err
+		}
+		w := &imageFileWriter{dir: imageDir}
+		if err := s.copyString(w); err != nil {
+			if w.file != nil {
+				w.file.Close()
+			}
+			return err
+		}
+		var err error
+		file, err = w.Close()
+		decodeErr = w.err
+		return err
+	})
+	if err != nil {
+		return Image{}, err
+	}
+	if err := unmarshalFields(fields, &img); err != nil {
+		return Image{}, err
+	}
+	img.File = file
+	img.err = decodeErr
+	return img, nil
+}
+
+// unmarshalFields decodes the fields of a JSON object into v.
+func unmarshalFields(fields map[string]json.RawMessage, v any) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jsonScanner reads JSON from a stream value by value, so that large
+// strings can be copied out without holding them in memory.
+type jsonScanner struct {
+	r *bufio.Reader
+}
+
+// peek returns the next byte that isn't whitespace, without consuming it.
+func (s *jsonScanner) peek() (byte, error) {
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return c, s.r.UnreadByte()
+	}
+}
+
+// expect consumes the next byte that isn't whitespace, which must be want.
+func (s *jsonScanner) expect(want byte) error {
+	c, err := s.peek()
+	if err != nil {
+		return err
+	}
+	if c != want {
+		return fmt.Errorf("expected %q, found %q", want, c)
+	}
+	_, err = s.r.ReadByte()
+	return err
+}
+
+// null consumes a null at the current position, if there is one.
+func (s *jsonScanner) null() (bool, error) {
+	if c, err := s.peek(); err != nil || c != 'n' {
+		return false, err
+	}
+	raw, err := s.raw()
+	if err != nil {
+		return false, err
+	}
+	if string(raw) != "null" {
+		return false, fmt.Errorf("invalid value %q", raw)
+	}
+	return true, nil
+}
+
+// object calls fn with each key of the object at the current position. fn
+// must consume the value. Null is read as an empty object.
+func (s *jsonScanner) object(fn func(key string) error) error {
+	return s.items('{', '}', func() error {
+		raw, err := s.raw()
+		if err != nil {
+			return err
+		}
+		var key string
+		if err := json.Unmarshal(raw, &key); err != nil {
+			return fmt.Errorf("invalid object key %s", raw)
+		}
+		if err := s.expect(':'); err != nil {
+			return err
+		}
+		return fn(key)
+	})
+}
+
+// array calls fn for each element of the array at the current position. fn
+// must consume the element. Null is read as an empty array.
+func (s *jsonScanner) array(fn func() error) error {
+	return s.items('[', ']', fn)
+}
+
+// items reads the comma-separated items between open and close, calling fn
+// to consume each.
+func (s *jsonScanner) items(open, close byte, fn func() error) error {
+	if null, err := s.null(); null || err != nil {
+		return err
+	}
+	if err := s.expect(open); err != nil {
+		return err
+	}
+	if c, err := s.peek(); err != nil {
+		return err
+	} else if c == close {
+		_, err := s.r.ReadByte()
+		return err
+	}
+
+	for {
+		if err := fn(); err != nil {
+			return err
+		}
+		c, err := s.peek()
+		if err != nil {
+			return err
+		}
+		s.r.ReadByte()
+		switch c {
+		case ',':
+		case close:
+			return nil
+		default:
+			return fmt.Errorf("expected ',' or %q, found %q", close, c)
+		}
+	}
+}
+
+// raw returns the value at the current position as it is. It is only
+// checked for validity when unmarshaled.
+func (s *jsonScanner) raw() (json.RawMessage, error) {
+	first, err := s.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if first != '"' && first != '{' && first != '[' {
+		// Numbers and literals end at the next delimiter.
+		for {
+			c, err := s.r.ReadByte()
+			if err == io.EOF && len(buf) > 0 {
+				return buf, nil
+			}
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			switch c {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				return buf, s.r.UnreadByte()
+			}
+			buf = append(buf, c)
+		}
+	}
+
+	depth, inString, escaped := 0, false, false
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		buf = append(buf, c)
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+		if !inString && depth == 0 {
+			return buf, nil
+		}
+	}
+}
+
+// copyString writes the unescaped contents of the string at the current
+// position to w, a buffer at a time.
+func (s *jsonScanner) copyString(w io.Writer) error {
+	if err := s.expect('"'); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 32*1024)
+	flush := func() error {
+		_, err := w.Write(buf)
+		buf = buf[:0]
+		return err
+	}
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		switch c {
+		case '"':
+			return flush()
+		case '\\':
+			r, err := s.escape()
+			if err != nil {
+				return err
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			buf = append(buf, c)
+		}
+		if len(buf) >= cap(buf)-utf8.UTFMax {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// escape reads the rest of an escape sequence in a string, after the
+// backslash.
+func (s *jsonScanner) escape() (rune, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	switch c {
+	case '"', '\\', '/':
+		return rune(c), nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'u':
+		r, err := s.hex4()
+		if err != nil || !utf16.IsSurrogate(r) {
+			return r, err
+		}
+		// The second half of a surrogate pair follows as another escape.
+		if next, err := s.r.Peek(2); err != nil || string(next) != `\u` {
+			return utf8.RuneError, nil
+		}
+		s.r.Discard(2)
+		r2, err := s.hex4()
+		return utf16.DecodeRune(r, r2), err
+	}
+	return 0, fmt.Errorf("invalid escape \\%c", c)
+}
+
+// hex4 reads the four hex digits of a \u escape.
+func (s *jsonScanner) hex4() (rune, error) {
+	var digits [4]byte
+	if _, err := io.ReadFull(s.r, digits[:]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	n, err := strconv.ParseUint(string(digits[:]), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid escape \\u%s", digits)
+	}
+	return rune(n), nil
+}
+
+// unexpectedEOF turns the end of the stream inside a value into an error.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// imageFileWriter decodes the base64 data URL of an image, as it is
+// written, to a new file in dir with the extension of its type. Data that
+// can't be decoded doesn't stop the response: the file is removed, the rest
+// of the image is skipped, and the error is kept in err.
+type imageFileWriter struct {
+	dir    string
+	header []byte
+	file   *os.File
+	data   *base64Writer
+	err    error
+}
+
+func (w *imageFileWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	if w.file != nil {
+		if _, err := w.data.Write(p); err != nil {
+			return w.fail(len(p), err)
+		}
+		return len(p), nil
+	}
+
+	// Hold back the start until the "data:<type>;base64," prefix is
+	// complete.
+	w.header = append(w.header, p...)
+	if len(w.header) < maxDataURLHeader && bytes.IndexByte(w.header, ',') == -1 &&
+		(bytes.HasPrefix(w.header, []byte("data:")) || bytes.HasPrefix([]byte("data:"), w.header)) {
+		return len(p), nil
+	}
+	if err := w.open(); err != nil {
+		return w.fail(len(p), err)
+	}
+	return len(p), nil
+}
+
+// open creates the file and writes the data held back so far.
+func (w *imageFileWriter) open() error {
+	var prefix string
+	rest := w.header
+	if i := bytes.IndexByte(w.header, ','); i != -1 && bytes.HasPrefix(w.header, []byte("data:")) {
+		prefix, rest = string(w.header[:i+1]), w.header[i+1:]
+	}
+
+	file, err := os.CreateTemp(w.dir, "image-*"+imageExtension(prefix))
+	if err != nil {
+		return fmt.Errorf("writing image: %w", err)
+	}
+	w.file = file
+	w.data = &base64Writer{w: file}
+	w.header = nil
+	_, err = w.data.Write(rest)
+	return err
+}
+
+// fail handles an error writing n bytes. Invalid base64 is kept in w.err,
+// and the file removed; other errors are returned.
+func (w *imageFileWriter) fail(n int, err error) (int, error) {
+	var corrupt base64.CorruptInputError
+	if !errors.As(err, &corrupt) {
+		return 0, err
+	}
+	w.err = err
+	w.file.Close()
+	os.Remove(w.file.Name())
+	w.file = nil
+	return n, nil
+}
+
+// Close finishes the file and returns its path, or "" if there was no
+// data or it couldn't be decoded.
+func (w *imageFileWriter) Close() (string, error) {
+	if w.err != nil {
+		return "", nil
+	}
+	if w.file == nil {
+		if len(w.header) == 0 {
+			return "", nil
+		}
+		if err := w.open(); err != nil {
+			if _, err := w.fail(0, err); err != nil {
+				if w.file != nil {
+					w.file.Close()
+				}
+				return "", err
+			}
+			return "", nil
+		}
+	}
+	if err := w.data.Close(); err != nil {
+		_, err = w.fail(0, err)
+		return "", err
+	}
+	if err := w.file.Close(); err != nil {
+		return "", fmt.Errorf("writing image: %w", err)
+	}
+	return w.file.Name(), nil
+}
+
+// base64Writer decodes standard base64 written to it and writes the result
+// to w. Line breaks are ignored.
+type base64Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (b *base64Writer) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c != '\n' && c != '\r' {
+			b.buf = append(b.buf, c)
+		}
+	}
+	n := len(b.buf) / 4 * 4
+	if err := b.decode(b.buf[:n]); err != nil {
+		return 0, err
+	}
+	b.buf = append(b.buf[:0], b.buf[n:]...)
+	return len(p), nil
+}
+
+// Close decodes what is left, which is an error unless it is empty.
+func (b *base64Writer) Close() error {
+	return b.decode(b.buf)
+}
+
+func (b *base64Writer) decode(src []byte) error {
+	if len(src) == 0 {
+		return nil
+	}
+	dst := make([]byte, base64.StdEncoding.DecodedLen(len(src)))
+	n, err := base64.StdEncoding.Decode(dst, src)
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+	_, err = b.w.Write(dst[:n])
+	return err
+}
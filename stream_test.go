@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeResponseStream(t *testing.T) {
+	jpegData := strings.Repeat("\xff\xd8jpeg data", 10000)
+	pngData := "\x89PNG data"
+	// Image data may be escaped or wrapped, and come before the ID.
+	response := `{"model": "mistral-ocr-latest", "pages": [
+		{"index": 0, "markdown": "# Title\n\n![img-0.jpeg](img-0.jpeg)", "images": [{
+			"image_base64": "data:image\/jpeg;base64,` + base64.StdEncoding.EncodeToString([]byte(jpegData)) + `",
+			"id": "img-0.jpeg", "top_left_x": 10, "image_annotation": "{\"type\": \"photo\"}"
+		}], "dimensions": {"dpi": 200, "width": 100, "height": 200}, "unknown": [1, {"a": "}"}]},
+		{"index": 1, "markdown": "Café 😀", "images": [
+			{"id": "img-1.png", "image_base64": "` + base64.StdEncoding.EncodeToString([]byte(pngData))[:8] + `\n` + base64.StdEncoding.EncodeToString([]byte(pngData))[8:] + `"},
+			{"id": "img-2.png", "image_base64": null},
+			{"id": "img-3.png", "image_base64": ""}
+		]},
+		{"index": 2, "markdown": "", "images": null}
+	], "usage_info": {"pages_processed": 3, "doc_size_bytes": 42}, "document_annotation": {"title": "T"}}`
+
+	dir := t.TempDir()
+	var pages []Page
+	resp, err := decodeResponseStream(strings.NewReader(response), dir, func(page Page) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decodeResponseStream failed: %v", err)
+	}
+
+	if resp.Model != "mistral-ocr-latest" || resp.UsageInfo == nil || resp.UsageInfo.PagesProcessed != 3 ||
+		resp.DocumentAnnotation == nil || resp.Pages != nil {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(pages) != 3 || pages[1].Markdown != "Café 😀" || pages[0].Dimensions == nil || pages[0].Dimensions.Height != 200 {
+		t.Fatalf("unexpected pages: %+v", pages)
+	}
+
+	img := pages[0].Images[0]
+	if img.ID != "img-0.jpeg" || img.TopLeftX != 10 || img.ImageAnnotation != `{"type": "photo"}` || img.ImageBase64 != "" {
+		t.Errorf("unexpected image: %+v", img)
+	}
+	if filepath.Dir(img.File) != dir || filepath.Ext(img.File) != ".jpg" || imageFileName(img, 0, 0) != "page_0_img_0.jpg" {
+		t.Errorf("expected the image written to a .jpg file in %s, got %q", dir, img.File)
+	}
+	if data, err := img.Data(); err != nil || string(data) != jpegData {
+		t.Errorf("expected the decoded JPEG data, got %d bytes (%v)", len(data), err)
+	}
+
+	images := pages[1].Images
+	if data, err := images[0].Data(); err != nil || string(data) != pngData || filepath.Ext(images[0].File) != ".png" {
+		t.Errorf("expected the decoded PNG data in a .png file, got %q in %q (%v)", data, images[0].File, err)
+	}
+	if images[1].HasData() || images[2].HasData() {
+		t.Errorf("expected images without data, got %+v", images[1:])
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("expected 2 image files, got %d", len(entries))
+	}
+}
+
+func TestDecodeResponseStream_Errors(t *testing.T) {
+	response := `{"pages": [{"index": 0, "markdown": "a"}, {"index": 1, "markdown": "b"}]}`
+
+	// Errors from the callback stop decoding and are returned as they are.
+	errStop := errors.New("stop")
+	calls := 0
+	_, err := decodeResponseStream(strings.NewReader(response), "", func(Page) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("expected the callback error after 1 page, got %v after %d", err, calls)
+	}
+
+	for _, bad := range []string{
+		response[:40],
+		`{"pages": [{"index": 0,, "markdown": "a"}]}`,
+		`{"pages": [{"index": "zero"}]}`,
+		`[]`,
+	} {
+		if _, err := decodeResponseStream(strings.NewReader(bad), t.TempDir(), func(Page) error { return nil }); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestDecodeResponseStream_CorruptImage(t *testing.T) {
+	good := base64.StdEncoding.EncodeToString([]byte("png data"))
+	response := `{"pages": [` +
+		`{"index": 0, "images": [{"id": "img-0.png", "image_base64": "data:image/png;base64,!!!!` + good + `"}]},` +
+		`{"index": 1, "images": [{"id": "img-1.png", "image_base64": "data:image/png;base64,` + good + `"}]}]}`
+
+	imageDir := t.TempDir()
+	var pages []Page
+	_, err := decodeResponseStream(strings.NewReader(response), imageDir, func(page Page) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil || len(pages) != 2 {
+		t.Fatalf("expected both pages despite the corrupt image, got %d (%v)", len(pages), err)
+	}
+
+	corrupt := pages[0].Images[0]
+	if _, err := corrupt.Data(); corrupt.File != "" || !corrupt.HasData() || err == nil || !strings.Contains(err.Error(), "decoding image") {
+		t.Errorf("expected the corrupt image without a file, reporting the error, got %+v (%v)", corrupt, err)
+	}
+	if data, err := pages[1].Images[0].Data(); err != nil || string(data) != "png data" {
+		t.Errorf("expected the next image decoded, got %q (%v)", data, err)
+	}
+	if entries, _ := os.ReadDir(imageDir); len(entries) != 1 {
+		t.Errorf("expected only the decoded image's file left, got %v", entries)
+	}
+}
+
+func TestProcessDocumentStream(t *testing.T) {
+	imageData := base64.StdEncoding.EncodeToString([]byte("gif data"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"pages": [{"index": 0, "markdown": "![img-0.gif](img-0.gif)", "images": [` +
+			`{"id": "img-0.gif", "image_base64": "data:image/gif;base64,` + imageData + `"}]}], "model": "m"}`))
+	}))
+	defer server.Close()
+
+	docPath := filepath.Join(t.TempDir(), "scan.pdf")
+	if err := os.WriteFile(docPath, buildTestPDF(1, false), 0644); err != nil {
+		t.Fatal(err)
+	}
+	imageDir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+
+	var pages []Page
+	resp, err := client.ProcessDocumentStream(context.Background(), docPath, OCROptions{ImageDir: imageDir}, func(page Page) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ProcessDocumentStream failed: %v", err)
+	}
+	if resp.Model != "m" || len(pages) != 1 || len(pages[0].Images) != 1 {
+		t.Fatalf("unexpected response %+v with pages %+v", resp, pages)
+	}
+	if data, err := os.ReadFile(pages[0].Images[0].File); err != nil || string(data) != "gif data" {
+		t.Errorf("expected the image in %s, got %q (%v)", imageDir, data, err)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tablesDir is the directory next to the Markdown file that tables are
+// written to.
+const tablesDir = "tables"
+
+var (
+	htmlTableRe = regexp.MustCompile(`(?is)<table\b.*?</table>`)
+	htmlCellRe  = regexp.MustCompile(`(?is)<(/?)(thead|tbody|tfoot|tr|th|td|br)\b([^>]*)>`)
+	htmlTagRe   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>`)
+	colspanRe   = regexp.MustCompile(`(?i)colspan\s*=\s*["']?(\d+)`)
+	rowspanRe   = regexp.MustCompile(`(?i)rowspan\s*=\s*["']?(\d+)`)
+)
+
+// Table is a table found in a page's Markdown, either a pipe table or an
+// HTML table.
+type Table struct {
+	Page   int
+	Index  int
+	Format string // "markdown" or "html"
+
+	// Header has a name for each column. Tables with several header rows,
+	// such as a year spanning two quarters, have them joined as "2024 Q1".
+	Header     []string
+	HeaderRows int
+	Rows       [][]string
+
+	// Start and End are the byte offsets of the table in the page's
+	// Markdown; StartLine and EndLine its first and last line, from 1.
+	Start, End         int
+	StartLine, EndLine int
+}
+
+// TableIndex is the JSON index of the tables written for a document.
+type TableIndex struct {
+	Source string       `json:"source"`
+	Tables []TableEntry `json:"tables"`
+}
+
+// TableEntry describes a table in the index: where it was found and the CSV
+// file it was written to.
+type TableEntry struct {
+	Page       int      `json:"page"`
+	Index      int      `json:"index"`
+	File       string   `json:"file"`
+	Format     string   `json:"format"`
+	StartLine  int      `json:"start_line"`
+	EndLine    int      `json:"end_line"`
+	HeaderRows int      `json:"header_rows"`
+	Columns    int      `json:"columns"`
+	Rows       int      `json:"rows"`
+	Header     []string `json:"header"`
+}
+
+// inlineTables replaces the links to tables returned separately from the
+// Markdown, such as [tbl-0.html](tbl-0.html), with the tables themselves.
+func inlineTables(pages []Page) {
+	for i := range pages {
+		page := &pages[i]
+		for _, t := range page.Tables {
+			link := "[" + t.ID + "](" + t.ID + ")"
+			if strings.Contains(page.Markdown, link) {
+				page.Markdown = strings.ReplaceAll(page.Markdown, link, t.Content)
+			} else {
+				page.Markdown = joinBlocks(page.Markdown, t.Content)
+			}
+		}
+		page.Tables = nil
+	}
+}
+
+func joinBlocks(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return strings.TrimRight(a, "\n") + "\n\n" + b
+}
+
+// findTables returns the tables in the pages' Markdown, numbered across the
+// document.
+func findTables(pages []Page) []Table {
+	var tables []Table
+	for _, page := range pages {
+		found := append(pipeTables(page.Markdown), htmlTables(page.Markdown)...)
+		// Number the tables in the order they appear.
+		slices.SortFunc(found, func(a, b Table) int { return a.Start - b.Start })
+		for _, t := range found {
+			t.Page = page.Index
+			t.Index = len(tables)
+			t.StartLine = strings.Count(page.Markdown[:t.Start], "\n") + 1
+			t.EndLine = t.StartLine + strings.Count(strings.TrimRight(page.Markdown[t.Start:t.End], "\n"), "\n")
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// tableFileName returns the name of a table's CSV file.
+func tableFileName(t Table) string {
+	return fmt.Sprintf("page_%d_table_%d.csv", t.Page, t.Index)
+}
+
+// linkTables replaces each table in the pages' Markdown with a link to its
+// CSV file, relative to the Markdown file.
+func linkTables(pages []Page, tables []Table) {
+	// Replace from the end of each page, so earlier offsets stay valid.
+	for i := len(tables) - 1; i >= 0; i-- {
+		t := tables[i]
+		for j := range pages {
+			page := &pages[j]
+			if page.Index != t.Page {
+				continue
+			}
+			file := path.Join(tablesDir, tableFileName(t))
+			link := fmt.Sprintf("[Table %d (page %d)](%s)", t.Index, t.Page, file)
+			page.Markdown = page.Markdown[:t.Start] + link + page.Markdown[t.End:]
+		}
+	}
+}
+
+// encodeTableCSV encodes a table as CSV, with the header as the first row.
+func encodeTableCSV(t Table) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if t.HeaderRows > 0 {
+		w.Write(t.Header)
+	}
+	for _, row := range t.Rows {
+		w.Write(padRow(row, len(t.Header)))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("encoding table: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTables writes each table as CSV to the tables directory in outDir,
+// and the index of the tables to <baseName>.tables.json.
+func writeTables(docPath string, tables []Table, sink outputSink, outDir, baseName string, report *Reporter) error {
+	report.Progress("Extracting %d tables\n", len(tables))
+
+	index := TableIndex{Source: docPath, Tables: make([]TableEntry, 0, len(tables))}
+	for _, t := range tables {
+		data, err := encodeTableCSV(t)
+		if err != nil {
+			return err
+		}
+		name := path.Join(outDir, tablesDir, tableFileName(t))
+		tablePath, err := sink.WriteFile(name, data)
+		if err != nil {
+			return fmt.Errorf("writing table: %w", err)
+		}
+		report.Verbose("Wrote table: %s\n", tablePath)
+
+		index.Tables = append(index.Tables, TableEntry{
+			Page:       t.Page,
+			Index:      t.Index,
+			File:       path.Join(tablesDir, tableFileName(t)),
+			Format:     t.Format,
+			StartLine:  t.StartLine,
+			EndLine:    t.EndLine,
+			HeaderRows: t.HeaderRows,
+			Columns:    len(t.Header),
+			Rows:       len(t.Rows),
+			Header:     t.Header,
+		})
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding table index: %w", err)
+	}
+	indexPath, err := sink.WriteFile(path.Join(outDir, baseName+".tables.json"), data)
+	if err != nil {
+		return fmt.Errorf("writing table index: %w", err)
+	}
+	report.Verbose("Wrote table index to: %s\n", indexPath)
+	return nil
+}
+
+// pipeTables returns the Markdown pipe tables in md: runs of lines starting
+// with "|" that include a delimiter row. Rows above the delimiter row are
+// header rows. Tables in code blocks are ignored.
+func pipeTables(md string) []Table {
+	var tables []Table
+	var rows []string
+	start, offset := 0, 0
+	inCode := false
+
+	flush := func(end int) {
+		if t, ok := parsePipeTable(rows); ok {
+			t.Start, t.End = start, start+len(strings.TrimRight(md[start:end], "\n"))
+			tables = append(tables, t)
+		}
+		rows = nil
+	}
+
+	for _, line := range strings.SplitAfter(md, "\n") {
+		text := strings.TrimRight(line, "\n")
+		switch {
+		case isCodeFence(text):
+			inCode = !inCode
+		case !inCode && isTableRow(text):
+			if rows == nil {
+				start = offset
+			}
+			rows = append(rows, text)
+			offset += len(line)
+			continue
+		}
+		if rows != nil {
+			flush(offset)
+		}
+		offset += len(line)
+	}
+	if rows != nil {
+		flush(len(md))
+	}
+	return tables
+}
+
+func parsePipeTable(lines []string) (Table, bool) {
+	sep := -1
+	for i, line := range lines {
+		if isTableSeparator(line) {
+			sep = i
+			break
+		}
+	}
+	if sep < 1 {
+		return Table{}, false
+	}
+
+	var header [][]string
+	for _, line := range lines[:sep] {
+		header = append(header, splitPipeRow(line))
+	}
+	var rows [][]string
+	for _, line := range lines[sep+1:] {
+		rows = append(rows, splitPipeRow(line))
+	}
+
+	// A pipe table can't span columns, so an empty cell in an upper header
+	// row continues the cell to its left.
+	for _, row := range header[:len(header)-1] {
+		for i := 1; i < len(row); i++ {
+			if row[i] == "" {
+				row[i] = row[i-1]
+			}
+		}
+	}
+
+	return Table{
+		Format:     "markdown",
+		Header:     flattenHeader(header),
+		HeaderRows: len(header),
+		Rows:       rows,
+	}, true
+}
+
+// splitPipeRow returns the cells of a pipe table row.
+func splitPipeRow(line string) []string {
+	s := strings.TrimSpace(line)
+	s = strings.TrimPrefix(s, "|")
+	if strings.HasSuffix(s, "|") && !strings.HasSuffix(s, `\|`) {
+		s = s[:len(s)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case s[i] == '|':
+			cells = append(cells, cleanCell(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(s[i])
+		}
+	}
+	return append(cells, cleanCell(cell.String()))
+}
+
+// cleanCell returns the text of a table cell, without markup.
+func cleanCell(s string) string {
+	s = htmlBreakRe.ReplaceAllString(s, " ")
+	s = html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// htmlCell is a cell of an HTML table before spans are expanded.
+type htmlCell struct {
+	text             string
+	header           bool
+	colspan, rowspan int
+}
+
+// htmlTables returns the HTML tables in md. Header rows are the rows in
+// <thead>, or else the leading rows that contain only <th> cells. Cells
+// spanning several columns or rows are repeated in each.
+func htmlTables(md string) []Table {
+	var tables []Table
+	for _, loc := range htmlTableRe.FindAllStringIndex(md, -1) {
+		t := parseHTMLTable(md[loc[0]:loc[1]])
+		if len(t.Rows) == 0 && t.HeaderRows == 0 {
+			continue
+		}
+		t.Start, t.End = loc[0], loc[1]
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+func parseHTMLTable(s string) Table {
+	var rows [][]htmlCell
+	var inHead []bool
+	var row []htmlCell
+	var cell *htmlCell
+	var text strings.Builder
+	thead, inRow := false, false
+
+	endCell := func() {
+		if cell != nil {
+			cell.text = cleanCell(text.String())
+			row = append(row, *cell)
+			cell = nil
+		}
+	}
+	endRow := func() {
+		endCell()
+		if inRow {
+			rows = append(rows, row)
+			inHead = append(inHead, thead)
+		}
+		row, inRow = nil, false
+	}
+
+	last := 0
+	for _, m := range htmlCellRe.FindAllStringSubmatchIndex(s, -1) {
+		if cell != nil {
+			text.WriteString(s[last:m[0]])
+		}
+		last = m[1]
+
+		closing := m[3] > m[2]
+		tag := strings.ToLower(s[m[4]:m[5]])
+		attrs := s[m[6]:m[7]]
+		switch {
+		case tag == "br":
+			text.WriteString(" ")
+		case tag == "thead":
+			endRow()
+			thead = !closing
+		case tag == "tbody" || tag == "tfoot":
+			endRow()
+			thead = false
+		case tag == "tr":
+			endRow()
+			inRow = !closing
+		case closing:
+			endCell()
+		default: // th or td
+			endCell()
+			inRow = true
+			cell = &htmlCell{header: tag == "th", colspan: spanAttr(colspanRe, attrs), rowspan: spanAttr(rowspanRe, attrs)}
+			text.Reset()
+		}
+	}
+	endRow()
+
+	grid := expandSpans(rows)
+
+	headerRows := 0
+	for i, r := range rows {
+		allTH := len(r) > 0
+		for _, c := range r {
+			allTH = allTH && c.header
+		}
+		if !inHead[i] && !allTH {
+			break
+		}
+		headerRows++
+	}
+
+	t := Table{Format: "html", HeaderRows: headerRows, Rows: grid[headerRows:]}
+	if headerRows > 0 {
+		t.Header = flattenHeader(grid[:headerRows])
+	} else if len(grid) > 0 {
+		t.Header = make([]string, len(grid[0]))
+	}
+	return t
+}
+
+func spanAttr(re *regexp.Regexp, attrs string) int {
+	if m := re.FindStringSubmatch(attrs); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > 1 {
+			return min(n, 1000)
+		}
+	}
+	return 1
+}
+
+// expandSpans lays out rows of cells on a grid, repeating cells that span
+// several columns or rows in each, and pads the rows to the same width.
+func expandSpans(rows [][]htmlCell) [][]string {
+	var grid [][]string
+	// pending holds the cells spanning down from earlier rows, by column.
+	pending := make(map[int]htmlCell)
+	width := 0
+
+	for _, row := range rows {
+		var out []string
+		col := 0
+		place := func(c htmlCell) {
+			for range c.colspan {
+				if c.rowspan > 1 {
+					pending[col] = htmlCell{text: c.text, colspan: 1, rowspan: c.rowspan - 1}
+				}
+				out = append(out, c.text)
+				col++
+			}
+		}
+		fillPending := func() {
+			for {
+				p, ok := pending[col]
+				if !ok {
+					return
+				}
+				delete(pending, col)
+				place(p)
+			}
+		}
+
+		for _, c := range row {
+			fillPending()
+			place(c)
+		}
+		fillPending()
+		// Cells spanning down past the end of this row's cells.
+		for {
+			next := -1
+			for c := range pending {
+				if c >= col && (next < 0 || c < next) {
+					next = c
+				}
+			}
+			if next < 0 {
+				break
+			}
+			for col < next {
+				out = append(out, "")
+				col++
+			}
+			fillPending()
+		}
+
+		width = max(width, len(out))
+		grid = append(grid, out)
+	}
+
+	for i := range grid {
+		grid[i] = padRow(grid[i], width)
+	}
+	return grid
+}
+
+// flattenHeader joins header rows into a single name per column, skipping
+// parts repeated from the row above, as in a cell spanning two rows.
+func flattenHeader(rows [][]string) []string {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+
+	header := make([]string, width)
+	for col := range width {
+		var parts []string
+		for _, row := range rows {
+			if col >= len(row) || row[col] == "" {
+				continue
+			}
+			if len(parts) > 0 && parts[len(parts)-1] == row[col] {
+				continue
+			}
+			parts = append(parts, row[col])
+		}
+		header[col] = strings.Join(parts, " ")
+	}
+	return header
+}
+
+// padRow returns row with empty cells added up to width.
+func padRow(row []string, width int) []string {
+	for len(row) < width {
+		row = append(row, "")
+	}
+	return row
+}
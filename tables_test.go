@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFindTables_Markdown(t *testing.T) {
+	pages := []Page{
+		{Index: 0, Markdown: "# Prices\n\n| Item | Price |\n|---|---:|\n| Apple | 1 |\n| Pipe \\| fitting | <b>2</b> |\n\nText."},
+		{Index: 1, Markdown: "```\n| not | a table |\n|---|---|\n```\n\n" +
+			"| | 2023 | | 2024 | |\n| Region | Q1 | Q2 | Q1 | Q2 |\n|---|---|---|---|---|\n| North | 1 | 2 | 3 | 4 |"},
+	}
+
+	tables := findTables(pages)
+	if len(tables) != 2 {
+		t.Fatalf("expected 2 tables, got %d: %+v", len(tables), tables)
+	}
+
+	first := tables[0]
+	if first.Page != 0 || first.Index != 0 || first.StartLine != 3 || first.EndLine != 6 {
+		t.Errorf("expected table 0 on page 0 lines 3-6, got page %d index %d lines %d-%d",
+			first.Page, first.Index, first.StartLine, first.EndLine)
+	}
+	if !slices.Equal(first.Header, []string{"Item", "Price"}) {
+		t.Errorf("expected header [Item Price], got %q", first.Header)
+	}
+	if !slices.Equal(first.Rows[1], []string{"Pipe | fitting", "2"}) {
+		t.Errorf("expected escaped pipe and markup removed, got %q", first.Rows[1])
+	}
+
+	merged := tables[1]
+	if merged.Page != 1 || merged.Index != 1 || merged.HeaderRows != 2 {
+		t.Errorf("expected table 1 on page 1 with 2 header rows, got %+v", merged)
+	}
+	want := []string{"Region", "2023 Q1", "2023 Q2", "2024 Q1", "2024 Q2"}
+	if !slices.Equal(merged.Header, want) {
+		t.Errorf("expected merged header %q, got %q", want, merged.Header)
+	}
+}
+
+func TestFindTables_HTML(t *testing.T) {
+	md := `Before.
+
+<table>
+  <thead>
+    <tr><th rowspan="2">Region</th><th colspan="2">2024</th></tr>
+    <tr><th>Q1</th><th>Q2 &amp; Q3</th></tr>
+  </thead>
+  <tbody>
+    <tr><td rowspan="2">North</td><td>1</td><td>2<br>(est.)</td></tr>
+    <tr><td>3</td><td>4</td></tr>
+  </tbody>
+</table>`
+
+	tables := findTables([]Page{{Index: 2, Markdown: md}})
+	if len(tables) != 1 {
+		t.Fatalf("expected 1 table, got %d", len(tables))
+	}
+	table := tables[0]
+
+	if table.Format != "html" || table.HeaderRows != 2 || table.StartLine != 3 || table.EndLine != 12 {
+		t.Errorf("expected HTML table with 2 header rows on lines 3-12, got %+v", table)
+	}
+	if want := []string{"Region", "2024 Q1", "2024 Q2 & Q3"}; !slices.Equal(table.Header, want) {
+		t.Errorf("expected header %q, got %q", want, table.Header)
+	}
+	wantRows := [][]string{{"North", "1", "2 (est.)"}, {"North", "3", "4"}}
+	for i, row := range wantRows {
+		if i >= len(table.Rows) || !slices.Equal(table.Rows[i], row) {
+			t.Errorf("expected rows %q, got %q", wantRows, table.Rows)
+			break
+		}
+	}
+
+	data, err := encodeTableCSV(table)
+	if err != nil {
+		t.Fatalf("encodeTableCSV failed: %v", err)
+	}
+	wantCSV := "Region,2024 Q1,2024 Q2 & Q3\nNorth,1,2 (est.)\nNorth,3,4\n"
+	if string(data) != wantCSV {
+		t.Errorf("expected CSV %q, got %q", wantCSV, data)
+	}
+}
+
+func TestProcessFile_Tables(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OCRRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.TableFormat != "html" {
+			t.Errorf("expected table_format html, got %q", req.TableFormat)
+		}
+		json.NewEncoder(w).Encode(OCRResponse{Pages: []Page{
+			{Index: 0, Markdown: "# Totals\n\n| A | B |\n|---|---|\n| 1 | 2 |\n\nEnd."},
+			{
+				Index:    1,
+				Markdown: "See [tbl-1.html](tbl-1.html) below.",
+				Tables:   []PageTable{{ID: "tbl-1.html", Content: "<table><tr><th>C</th></tr><tr><td>3</td></tr></table>"}},
+			},
+		}})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient("test-api-key", WithBaseURL(server.URL))
+	doc := document{Path: filepath.Join(dir, "report.pdf"), Data: buildTestPDF(2, false), Dir: dir}
+	ro := runOptions{
+		OCR:        OCROptions{TableFormat: "html"},
+		Tables:     true,
+		TableLinks: true,
+	}
+
+	if _, err := processFile(context.Background(), client, doc, ro, NewReporter(io.Discard, true, false)); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+
+	md, err := os.ReadFile(filepath.Join(dir, "report.md"))
+	if err != nil {
+		t.Fatalf("failed to read Markdown: %v", err)
+	}
+	for _, want := range []string{
+		"# Totals\n\n[Table 0 (page 0)](tables/page_0_table_0.csv)\n\nEnd.",
+		"See [Table 1 (page 1)](tables/page_1_table_1.csv) below.",
+	} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("expected %q in Markdown, got %q", want, md)
+		}
+	}
+
+	csv, err := os.ReadFile(filepath.Join(dir, "tables", "page_1_table_1.csv"))
+	if err != nil || string(csv) != "C\n3\n" {
+		t.Errorf("expected CSV of the separate HTML table, got %q (%v)", csv, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "report.tables.json"))
+	if err != nil {
+		t.Fatalf("failed to read table index: %v", err)
+	}
+	var index TableIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("failed to parse table index: %v", err)
+	}
+	if len(index.Tables) != 2 || index.Tables[0].File != "tables/page_0_table_0.csv" ||
+		index.Tables[0].StartLine != 3 || index.Tables[1].Format != "html" {
+		t.Errorf("unexpected table index: %s", data)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML used by the configuration file:
+// tables ("[a.b]"), key/value pairs with bare, quoted, or dotted keys, and
+// values that are strings, integers, floats, booleans, single-line arrays,
+// or inline tables. Tables are returned as nested maps.
+func parseTOML(data []byte) (map[string]any, error) {
+	root := make(map[string]any)
+	current := root
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: arrays of tables are not supported", lineNum)
+			}
+			end := strings.LastIndex(stripTOMLComment(line), "]")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated table header", lineNum)
+			}
+			keys, rest, err := parseTOMLKey(line[1:end])
+			if err != nil || strings.TrimSpace(rest) != "" {
+				return nil, fmt.Errorf("line %d: invalid table header %q", lineNum, line)
+			}
+			current, err = tomlTable(root, keys)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			continue
+		}
+
+		keys, rest, err := parseTOMLKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "=") {
+			return nil, fmt.Errorf("line %d: expected '=' after key", lineNum)
+		}
+
+		value, rest, err := parseTOMLValue(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after value", lineNum, rest)
+		}
+
+		table, err := tomlTable(current, keys[:len(keys)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		table[keys[len(keys)-1]] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// tomlTable returns the nested table at keys, creating it if needed.
+func tomlTable(root map[string]any, keys []string) (map[string]any, error) {
+	table := root
+	for _, key := range keys {
+		switch v := table[key].(type) {
+		case nil:
+			next := make(map[string]any)
+			table[key] = next
+			table = next
+		case map[string]any:
+			table = v
+		default:
+			return nil, fmt.Errorf("key %q is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+// parseTOMLKey parses a possibly dotted key and returns its segments and
+// the remaining input.
+func parseTOMLKey(s string) ([]string, string, error) {
+	var keys []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		var key string
+		switch {
+		case strings.HasPrefix(s, `"`), strings.HasPrefix(s, "'"):
+			v, rest, err := parseTOMLValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			key, s = v.(string), rest
+		default:
+			end := strings.IndexFunc(s, func(r rune) bool {
+				return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+			})
+			if end == -1 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, "", fmt.Errorf("invalid key at %q", s)
+			}
+			key, s = s[:end], s[end:]
+		}
+		keys = append(keys, key)
+
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return keys, s, nil
+		}
+		s = s[1:]
+	}
+}
+
+// parseTOMLValue parses a single value and returns it with the remaining
+// input.
+func parseTOMLValue(s string) (any, string, error) {
+	switch {
+	case s == "":
+		return nil, "", fmt.Errorf("missing value")
+
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				str, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return str, s[i+1:], nil
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end == -1 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+
+	case strings.HasPrefix(s, "["):
+		arr := []any{}
+		s = strings.TrimLeft(s[1:], " \t")
+		for !strings.HasPrefix(s, "]") {
+			v, rest, err := parseTOMLValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			arr = append(arr, v)
+			s = strings.TrimLeft(rest, " \t")
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimLeft(s[1:], " \t")
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", fmt.Errorf("expected ',' or ']' in array")
+			}
+		}
+		return arr, s[1:], nil
+
+	case strings.HasPrefix(s, "{"):
+		table := make(map[string]any)
+		s = strings.TrimLeft(s[1:], " \t")
+		for !strings.HasPrefix(s, "}") {
+			keys, rest, err := parseTOMLKey(s)
+			if err != nil {
+				return nil, "", err
+			}
+			rest = strings.TrimLeft(rest, " \t")
+			if !strings.HasPrefix(rest, "=") {
+				return nil, "", fmt.Errorf("expected '=' in inline table")
+			}
+			v, rest, err := parseTOMLValue(strings.TrimLeft(rest[1:], " \t"))
+			if err != nil {
+				return nil, "", err
+			}
+			t, err := tomlTable(table, keys[:len(keys)-1])
+			if err != nil {
+				return nil, "", err
+			}
+			t[keys[len(keys)-1]] = v
+			s = strings.TrimLeft(rest, " \t")
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimLeft(s[1:], " \t")
+			} else if !strings.HasPrefix(s, "}") {
+				return nil, "", fmt.Errorf("expected ',' or '}' in inline table")
+			}
+		}
+		return table, s[1:], nil
+	}
+
+	end := strings.IndexAny(s, " \t,]}#")
+	if end == -1 {
+		end = len(s)
+	}
+	tok, rest := s[:end], s[end:]
+
+	switch tok {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	if n, err := strconv.ParseInt(strings.ReplaceAll(tok, "_", ""), 0, 64); err == nil {
+		return int(n), rest, nil
+	}
+	if f, err := strconv.ParseFloat(strings.ReplaceAll(tok, "_", ""), 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q", tok)
+}
+
+// stripTOMLComment removes a trailing comment from a line that contains no
+// strings, such as a table header.
+func stripTOMLComment(line string) string {
+	if i := strings.Index(line, "#"); i != -1 && !strings.ContainsAny(line[:i], `"'`) {
+		return line[:i]
+	}
+	return line
+}
@@ -4,7 +4,13 @@ package main
 type OCRRequest struct {
 	Model                    string            `json:"model"`
 	Document                 DocumentURL       `json:"document"`
+	Pages                    []int             `json:"pages,omitempty"`
 	IncludeImageBase64       bool              `json:"include_image_base64"`
+	ImageLimit               int               `json:"image_limit,omitempty"`
+	ImageMinSize             int               `json:"image_min_size,omitempty"`
+	ExtractHeader            bool              `json:"extract_header,omitempty"`
+	ExtractFooter            bool              `json:"extract_footer,omitempty"`
+	TableFormat              string            `json:"table_format,omitempty"`
 	BBoxAnnotationFormat     *AnnotationFormat `json:"bbox_annotation_format,omitempty"`
 	DocumentAnnotationFormat *AnnotationFormat `json:"document_annotation_format,omitempty"`
 }
@@ -21,23 +27,60 @@ type JSONSchema struct {
 	Schema any    `json:"schema"`
 }
 
-// DocumentURL wraps the document data URL.
+// DocumentURL wraps the document data URL or a signed URL to an uploaded file.
+// Images are sent as an image_url chunk, other documents as a document_url
+// chunk.
 type DocumentURL struct {
 	Type        string `json:"type"`
-	DocumentURL string `json:"document_url"`
+	DocumentURL string `json:"document_url,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
 }
 
 // OCRResponse represents the response from the Mistral OCR API.
 type OCRResponse struct {
-	Pages              []Page `json:"pages"`
-	DocumentAnnotation any    `json:"document_annotation,omitempty"`
+	Pages              []Page     `json:"pages"`
+	Model              string     `json:"model,omitempty"`
+	UsageInfo          *UsageInfo `json:"usage_info,omitempty"`
+	DocumentAnnotation any        `json:"document_annotation,omitempty"`
+}
+
+// UsageInfo reports what the API processed, and bills, for a request.
+type UsageInfo struct {
+	PagesProcessed int `json:"pages_processed"`
+	DocSizeBytes   int `json:"doc_size_bytes"`
 }
 
 // Page represents a single page in the OCR response.
 type Page struct {
-	Index    int     `json:"index"`
-	Markdown string  `json:"markdown"`
-	Images   []Image `json:"images"`
+	Index      int             `json:"index"`
+	Markdown   string          `json:"markdown"`
+	Images     []Image         `json:"images"`
+	Dimensions *PageDimensions `json:"dimensions,omitempty"`
+
+	// Header and Footer are returned separately from the Markdown when
+	// requested with OCROptions.ExtractHeaders, or filled in by
+	// stripRepeatedLines.
+	Header string `json:"header,omitempty"`
+	Footer string `json:"footer,omitempty"`
+
+	// Tables are returned separately from the Markdown when requested with
+	// OCROptions.TableFormat. The Markdown links to each by its ID.
+	Tables []PageTable `json:"tables,omitempty"`
+}
+
+// PageTable is a table returned separately from a page's Markdown, in the
+// requested format.
+type PageTable struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+// PageDimensions is the size of the rendered page in pixels, and the
+// resolution it was rendered at. Image coordinates are relative to it.
+type PageDimensions struct {
+	DPI    int `json:"dpi"`
+	Height int `json:"height"`
+	Width  int `json:"width"`
 }
 
 // Image represents an extracted image from the document.
@@ -49,6 +92,23 @@ type Image struct {
 	BottomRightY    int    `json:"bottom_right_y"`
 	ImageBase64     string `json:"image_base64"`
 	ImageAnnotation any    `json:"image_annotation,omitempty"`
+
+	// File is where the image data was written when the response was
+	// streamed with OCROptions.ImageDir set. ImageBase64 is then empty.
+	File string `json:"-"`
+	// err is why the streamed data couldn't be decoded, in which case File
+	// is empty.
+	err error
+}
+
+// FileUpload represents the response from the Mistral Files API upload endpoint.
+type FileUpload struct {
+	ID string `json:"id"`
+}
+
+// SignedURL represents the response from the Mistral Files API URL endpoint.
+type SignedURL struct {
+	URL string `json:"url"`
 }
 
 // ImageMetadata contains extracted metadata for an image.
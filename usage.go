@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+)
+
+// Price is the cost of a model in USD per 1000 pages.
+type Price struct {
+	OCR        float64 `json:"ocr"`
+	Annotation float64 `json:"annotation"`
+}
+
+// PriceTable maps model names to prices. The "default" entry applies to
+// models that are not listed.
+type PriceTable map[string]Price
+
+// defaultPriceTable holds the published Mistral OCR prices.
+var defaultPriceTable = PriceTable{
+	"default": {OCR: 1, Annotation: 3},
+}
+
+// loadPriceTable reads a JSON price table. Entries override the defaults.
+func loadPriceTable(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var table PriceTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+
+	merged := maps.Clone(defaultPriceTable)
+	maps.Copy(merged, table)
+	return merged, nil
+}
+
+// Cost returns the cost in USD of processing pages with model. Annotated
+// pages (with -m or -a) are billed at the annotation price on top.
+func (t PriceTable) Cost(model string, pages int, annotated bool) float64 {
+	price, ok := t[model]
+	if !ok {
+		price = t["default"]
+	}
+
+	perPage := price.OCR
+	if annotated {
+		perPage += price.Annotation
+	}
+	return float64(pages) * perPage / 1000
+}
+
+// Usage accumulates API usage over a run.
+type Usage struct {
+	Documents int     `json:"documents"`
+	Pages     int     `json:"pages"`
+	Bytes     int     `json:"bytes"`
+	Cost      float64 `json:"cost_usd"`
+}
+
+// Add records the usage reported in resp. Responses without usage_info are
+// counted by their returned pages.
+func (u *Usage) Add(resp *OCRResponse, annotated bool, prices PriceTable) {
+	pages := len(resp.Pages)
+	if resp.UsageInfo != nil {
+		pages = resp.UsageInfo.PagesProcessed
+		u.Bytes += resp.UsageInfo.DocSizeBytes
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = ocrModel
+	}
+
+	u.Documents++
+	u.Pages += pages
+	u.Cost += prices.Cost(model, pages, annotated)
+}
+
+// String summarizes the usage for the end of a run.
+func (u Usage) String() string {
+	return fmt.Sprintf("Processed %d document(s), %d page(s), %s; estimated cost $%.3f",
+		u.Documents, u.Pages, formatBytes(u.Bytes), u.Cost)
+}
+
+// checkBudget returns an error if processing pages more pages on top of
+// used would exceed budget. A budget of zero or less means no limit.
+func checkBudget(budget, used, pages int) error {
+	if budget <= 0 || used+pages <= budget {
+		return nil
+	}
+	return fmt.Errorf("page budget exceeded: %d page(s) needed, %d of %d remaining", pages, budget-used, budget)
+}
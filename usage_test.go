@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPriceTable_Cost(t *testing.T) {
+	prices := PriceTable{
+		"default":          {OCR: 1, Annotation: 3},
+		"mistral-ocr-2505": {OCR: 2, Annotation: 4},
+	}
+
+	tests := []struct {
+		model     string
+		pages     int
+		annotated bool
+		want      float64
+	}{
+		{"mistral-ocr-latest", 1000, false, 1},
+		{"mistral-ocr-latest", 500, true, 2},
+		{"mistral-ocr-2505", 1000, false, 2},
+		{"mistral-ocr-2505", 1000, true, 6},
+	}
+
+	for _, tt := range tests {
+		if got := prices.Cost(tt.model, tt.pages, tt.annotated); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Cost(%s, %d, %v): expected %.3f, got %.3f", tt.model, tt.pages, tt.annotated, tt.want, got)
+		}
+	}
+}
+
+func TestLoadPriceTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	if err := os.WriteFile(path, []byte(`{"custom-model": {"ocr": 0.5}}`), 0644); err != nil {
+		t.Fatalf("failed to write price table: %v", err)
+	}
+
+	prices, err := loadPriceTable(path)
+	if err != nil {
+		t.Fatalf("loadPriceTable failed: %v", err)
+	}
+
+	if prices["custom-model"].OCR != 0.5 {
+		t.Errorf("expected custom price 0.5, got %v", prices["custom-model"].OCR)
+	}
+	if prices["default"] != defaultPriceTable["default"] {
+		t.Errorf("expected default price to be kept, got %v", prices["default"])
+	}
+}
+
+func TestUsage_Add(t *testing.T) {
+	var resp OCRResponse
+	body := `{"pages": [{"index": 0}], "model": "mistral-ocr-2505", "usage_info": {"pages_processed": 3, "doc_size_bytes": 2048}}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	var usage Usage
+	usage.Add(&resp, false, defaultPriceTable)
+	usage.Add(&OCRResponse{Pages: make([]Page, 2)}, true, defaultPriceTable)
+
+	if usage.Documents != 2 {
+		t.Errorf("expected 2 documents, got %d", usage.Documents)
+	}
+	if usage.Pages != 5 {
+		t.Errorf("expected usage_info pages plus counted pages (5), got %d", usage.Pages)
+	}
+	if usage.Bytes != 2048 {
+		t.Errorf("expected 2048 bytes, got %d", usage.Bytes)
+	}
+	if math.Abs(usage.Cost-0.011) > 1e-9 {
+		t.Errorf("expected cost 0.011, got %v", usage.Cost)
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	if err := checkBudget(0, 100, 100); err != nil {
+		t.Errorf("expected no limit for zero budget, got: %v", err)
+	}
+	if err := checkBudget(10, 4, 6); err != nil {
+		t.Errorf("expected budget to be met exactly, got: %v", err)
+	}
+
+	err := checkBudget(10, 4, 7)
+	if err == nil {
+		t.Fatal("expected budget error")
+	}
+	if !strings.Contains(err.Error(), "7 page(s) needed, 6 of 10 remaining") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// maxDocumentSize is the largest document the Files API accepts. Documents
+// up to maxInlineDocumentSize are sent inline, larger ones are uploaded.
+const maxDocumentSize = 512 << 20
+
+// DocumentInfo holds the facts the pre-flight checks gather about a document
+// before anything is sent to the API.
+type DocumentInfo struct {
+	Name     string
+	Size     int
+	MIMEType string
+
+	// PDF documents only.
+	PDFVersion string
+	Pages      int
+	Encrypted  bool
+	Truncated  bool
+	pdfErr     error
+
+	// Image documents only.
+	Width, Height int
+	imageErr      error
+}
+
+// inspectDocument examines the document data locally. It never fails; any
+// problems found are reported by Validate.
+func inspectDocument(name string, data []byte) *DocumentInfo {
+	info := &DocumentInfo{
+		Name:     name,
+		Size:     len(data),
+		MIMEType: detectMIMEType(data),
+	}
+
+	switch info.MIMEType {
+	case "application/pdf":
+		info.inspectPDF(data)
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		info.Pages = 1
+		info.Width, info.Height, info.imageErr = imageDimensions(info.MIMEType, data)
+	case mimeDOCX, mimePPTX, mimeODT, mimeEPUB:
+		info.Pages = zipDocumentPages(info.MIMEType, data)
+	}
+
+	return info
+}
+
+// Splittable reports whether the document can be requested in page ranges.
+// Only PDFs are, as their pages are counted in the page tree; the page
+// counts of office documents come from metadata that word processors often
+// leave stale, so ranges from it could leave out pages or ask for ones that
+// don't exist.
+func (d *DocumentInfo) Splittable() bool {
+	return d.MIMEType == "application/pdf" && d.Pages > 0
+}
+
+func (d *DocumentInfo) inspectPDF(data []byte) {
+	if idx := bytes.Index(data, []byte("%PDF-")); idx != -1 {
+		version := data[idx+len("%PDF-"):]
+		if end := bytes.IndexAny(version, " \t\r\n%"); end != -1 {
+			version = version[:end]
+		}
+		d.PDFVersion = string(version[:min(len(version), 8)])
+	}
+
+	tail := data[max(0, len(data)-1024):]
+	d.Truncated = !bytes.Contains(tail, []byte("%%EOF"))
+
+	pdf, err := parsePDF(data)
+	if err != nil {
+		d.pdfErr = err
+		return
+	}
+	d.Pages = pdf.PageCount()
+	d.Encrypted = pdf.Encrypted()
+}
+
+// Validate reports the problems that would make the API reject the document.
+func (d *DocumentInfo) Validate() error {
+	var errs []error
+
+	switch {
+	case d.Size == 0:
+		errs = append(errs, errors.New("file is empty"))
+	case d.MIMEType == mimeXLSX:
+		errs = append(errs, errors.New("spreadsheets (XLSX) are not supported; export the sheets as PDF first"))
+	case mimeExtensions[d.MIMEType] == "":
+		errs = append(errs, errors.New("unsupported document type (expected PDF, DOCX, PPTX, ODT, EPUB, PNG, JPEG, GIF, or WebP)"))
+	}
+
+	if d.Size > maxDocumentSize {
+		errs = append(errs, fmt.Errorf("file is %s, the maximum is %s", formatBytes(d.Size), formatBytes(maxDocumentSize)))
+	}
+
+	if d.MIMEType == "application/pdf" {
+		switch {
+		case d.Truncated:
+			errs = append(errs, errors.New("PDF appears to be truncated (no %EOF marker)"))
+		case d.pdfErr != nil:
+			errs = append(errs, fmt.Errorf("PDF structure is unreadable: %w", d.pdfErr))
+		case d.Encrypted:
+			errs = append(errs, errors.New("PDF is encrypted or password-protected; remove the protection first"))
+		case d.Pages == 0:
+			errs = append(errs, errors.New("PDF has no pages"))
+		}
+	}
+
+	if d.imageErr != nil {
+		errs = append(errs, fmt.Errorf("image is unreadable: %w", d.imageErr))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", d.Name, errors.Join(errs...))
+}
+
+// mimeExtensions maps supported document types to file extensions.
+var mimeExtensions = map[string]string{
+	"application/pdf": ".pdf",
+	mimeDOCX:          ".docx",
+	mimePPTX:          ".pptx",
+	mimeODT:           ".odt",
+	mimeEPUB:          ".epub",
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+}
+
+// detectMIMEType identifies the document type from its magic bytes, and
+// zip based formats from their entries. It returns an empty string for
+// unknown types.
+func detectMIMEType(data []byte) string {
+	switch {
+	case bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "image/gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "image/webp"
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return zipDocumentType(data)
+	}
+	return ""
+}
+
+// imageDimensions reads the pixel dimensions from the image header.
+func imageDimensions(mimeType string, data []byte) (int, int, error) {
+	if mimeType == "image/webp" {
+		return webpDimensions(data)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// webpDimensions reads the canvas size from a WebP header, which the
+// standard library cannot decode.
+func webpDimensions(data []byte) (int, int, error) {
+	if len(data) < 30 {
+		return 0, 0, errors.New("webp: header too short")
+	}
+
+	switch string(data[12:16]) {
+	case "VP8 ":
+		w := int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff)
+		return w, h, nil
+	case "VP8L":
+		b := data[21:25]
+		w := 1 + (int(b[0]) | int(b[1]&0x3f)<<8)
+		h := 1 + (int(b[1]>>6) | int(b[2])<<2 | int(b[3]&0x0f)<<10)
+		return w, h, nil
+	case "VP8X":
+		w := 1 + (int(data[24]) | int(data[25])<<8 | int(data[26])<<16)
+		h := 1 + (int(data[27]) | int(data[28])<<8 | int(data[29])<<16)
+		return w, h, nil
+	}
+	return 0, 0, fmt.Errorf("webp: unknown chunk %q", data[12:16])
+}
+
+// formatBytes formats a byte count for humans.
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
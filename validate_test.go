@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestDetectMIMEType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"pdf with leading junk", []byte("\r\n\r\n%PDF-1.4\n"), "application/pdf"},
+		{"png", []byte("\x89PNG\r\n\x1a\n...."), "image/png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0"), "image/jpeg"},
+		{"gif", []byte("GIF89a"), "image/gif"},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"docx", buildTestZip(t, map[string][]byte{"[Content_Types].xml": nil, "word/document.xml": nil}), mimeDOCX},
+		{"pptx", buildTestZip(t, map[string][]byte{"[Content_Types].xml": nil, "ppt/presentation.xml": nil}), mimePPTX},
+		{"xlsx", buildTestZip(t, map[string][]byte{"[Content_Types].xml": nil, "xl/workbook.xml": nil}), mimeXLSX},
+		{"odt", buildTestZip(t, map[string][]byte{"mimetype": []byte(mimeODT), "content.xml": nil}), mimeODT},
+		{"epub", buildTestZip(t, map[string][]byte{"mimetype": []byte(mimeEPUB), "META-INF/container.xml": nil}), mimeEPUB},
+		{"zip", buildTestZip(t, map[string][]byte{"scan.pdf": nil}), ""},
+		{"text", []byte("hello"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectMIMEType(tt.data); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInspectDocument_PDF(t *testing.T) {
+	info := inspectDocument("doc.pdf", buildTestPDF(3, false))
+
+	if info.MIMEType != "application/pdf" {
+		t.Errorf("expected application/pdf, got %s", info.MIMEType)
+	}
+	if info.PDFVersion != "1.7" {
+		t.Errorf("expected version 1.7, got %q", info.PDFVersion)
+	}
+	if info.Pages != 3 {
+		t.Errorf("expected 3 pages, got %d", info.Pages)
+	}
+	if err := info.Validate(); err != nil {
+		t.Errorf("expected valid document, got: %v", err)
+	}
+}
+
+func TestInspectDocument_Image(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+
+	info := inspectDocument("scan.png", buf.Bytes())
+
+	if info.Width != 40 || info.Height != 30 {
+		t.Errorf("expected 40x30, got %dx%d", info.Width, info.Height)
+	}
+	if info.Pages != 1 {
+		t.Errorf("expected 1 page, got %d", info.Pages)
+	}
+	if err := info.Validate(); err != nil {
+		t.Errorf("expected valid image, got: %v", err)
+	}
+}
+
+func TestInspectDocument_Office(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		pages int
+	}{
+		{"report.docx", map[string][]byte{
+			"word/document.xml": nil,
+			"docProps/app.xml":  []byte("<Properties><Pages>12</Pages><Words>3400</Words></Properties>"),
+		}, 12},
+		{"deck.pptx", map[string][]byte{
+			"ppt/presentation.xml":             nil,
+			"ppt/slides/slide1.xml":            nil,
+			"ppt/slides/slide2.xml":            nil,
+			"ppt/slides/_rels/slide1.xml.rels": nil,
+		}, 2},
+		{"letter.odt", map[string][]byte{
+			"mimetype": []byte(mimeODT),
+			"meta.xml": []byte(`<office:meta><meta:document-statistic meta:page-count="3" meta:word-count="900"/></office:meta>`),
+		}, 3},
+		{"book.epub", map[string][]byte{"mimetype": []byte(mimeEPUB)}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := inspectDocument(tt.name, buildTestZip(t, tt.files))
+			if info.Pages != tt.pages {
+				t.Errorf("expected %d pages, got %d", tt.pages, info.Pages)
+			}
+			if err := info.Validate(); err != nil {
+				t.Errorf("expected valid document, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestInspectDocument_Invalid(t *testing.T) {
+	pdf := buildTestPDF(1, false)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "file is empty"},
+		{"unsupported", []byte("just text"), "unsupported document type"},
+		{"spreadsheet", buildTestZip(t, map[string][]byte{"xl/workbook.xml": nil}), "spreadsheets (XLSX) are not supported"},
+		{"truncated", pdf[:len(pdf)/2], "truncated (no %EOF marker)"},
+		{"encrypted", bytes.Replace(pdf, []byte("/Root 1 0 R"), []byte("/Root 1 0 R /Encrypt 5 0 R"), 1), "encrypted"},
+		{"no pages", buildTestPDF(0, false), "no pages"},
+		{"corrupt image", []byte("\x89PNG\r\n\x1a\ngarbage"), "image is unreadable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := inspectDocument("doc", tt.data).Validate()
+			if err == nil {
+				t.Fatal("expected validation error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestWebPDimensions(t *testing.T) {
+	header := func(chunk string, payload ...byte) []byte {
+		data := []byte("RIFF\x00\x00\x00\x00WEBP" + chunk + "\x00\x00\x00\x00")
+		data = append(data, payload...)
+		return append(data, make([]byte, 32)...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+	}{
+		// Lossy: frame tag and start code, then 14-bit width and height.
+		{"VP8", header("VP8 ", 0, 0, 0, 0x9d, 0x01, 0x2a, 0x40, 0x01, 0xf0, 0x00), 320, 240},
+		// Lossless: signature, then 14-bit width-1 and height-1, bit-packed.
+		{"VP8L", header("VP8L", 0x2f, 0x3f, 0xc0, 0x3b, 0x00), 64, 240},
+		// Extended: flags and reserved bytes, then 24-bit width-1 and height-1.
+		{"VP8X", header("VP8X", 0, 0, 0, 0, 0x7f, 0x07, 0x00, 0x37, 0x04, 0x00), 1920, 1080},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, err := webpDimensions(tt.data)
+			if err != nil {
+				t.Fatalf("webpDimensions failed: %v", err)
+			}
+			if w != tt.w || h != tt.h {
+				t.Errorf("expected %dx%d, got %dx%d", tt.w, tt.h, w, h)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a non-empty line of a YAML document with its comment removed.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// parseYAML parses the subset of YAML used by the project configuration
+// file: nested block mappings, block sequences, flow sequences, and quoted
+// or plain scalars. Anchors, tags, multi-document streams, and block
+// scalars are not supported.
+func parseYAML(data []byte) (map[string]any, error) {
+	var lines []yamlLine
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; scanner.Scan(); num++ {
+		raw := strings.TrimRight(stripYAMLComment(scanner.Text()), " \t")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", num)
+		}
+		lines = append(lines, yamlLine{num: num, indent: len(raw) - len(text), text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+
+	value, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].num)
+	}
+
+	m, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("line %d: expected a mapping at the top level", lines[0].num)
+	}
+	return m, nil
+}
+
+// parseYAMLBlock parses the mapping or sequence starting at lines[i], whose
+// entries are at the given indentation, and returns it with the index of
+// the first line after it.
+func parseYAMLBlock(lines []yamlLine, i, indent int) (any, int, error) {
+	if lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ") {
+		var seq []any
+		for i < len(lines) && lines[i].indent == indent && (lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ")) {
+			item := strings.TrimSpace(strings.TrimPrefix(lines[i].text, "-"))
+			if item == "" {
+				if i+1 >= len(lines) || lines[i+1].indent <= indent {
+					seq = append(seq, nil)
+					i++
+					continue
+				}
+				value, next, err := parseYAMLBlock(lines, i+1, lines[i+1].indent)
+				if err != nil {
+					return nil, 0, err
+				}
+				seq, i = append(seq, value), next
+				continue
+			}
+			value, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", lines[i].num, err)
+			}
+			seq = append(seq, value)
+			i++
+		}
+		return seq, i, nil
+	}
+
+	m := make(map[string]any)
+	for i < len(lines) && lines[i].indent == indent {
+		line := lines[i]
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, 0, fmt.Errorf("line %d: expected 'key: value'", line.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, 0, fmt.Errorf("line %d: duplicate key %q", line.num, key)
+		}
+
+		if rest != "" {
+			value, err := parseYAMLScalar(rest)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", line.num, err)
+			}
+			m[key] = value
+			i++
+			continue
+		}
+
+		// A key without a value introduces a nested block, or is null.
+		// Sequences may be indented at the same level as their key.
+		if i+1 < len(lines) && (lines[i+1].indent > indent ||
+			lines[i+1].indent == indent && strings.HasPrefix(lines[i+1].text, "- ")) {
+			value, next, err := parseYAMLBlock(lines, i+1, lines[i+1].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], i = value, next
+			continue
+		}
+		m[key] = nil
+		i++
+	}
+
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, fmt.Errorf("line %d: unexpected indentation", lines[i].num)
+	}
+	return m, i, nil
+}
+
+// splitYAMLKey splits "key: value" into its key and value.
+func splitYAMLKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		key, rest, err := parseYAMLQuoted(text)
+		if err != nil || !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+
+	idx := strings.Index(text, ": ")
+	if idx == -1 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false
+		}
+		idx = len(text) - 1
+	}
+	return strings.TrimSpace(text[:idx]), strings.TrimSpace(text[idx+1:]), true
+}
+
+// parseYAMLScalar parses a scalar or a flow sequence of scalars.
+func parseYAMLScalar(text string) (any, error) {
+	switch {
+	case strings.HasPrefix(text, `"`), strings.HasPrefix(text, "'"):
+		value, rest, err := parseYAMLQuoted(text)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after string", rest)
+		}
+		return value, nil
+
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("unterminated flow sequence")
+		}
+		seq := []any{}
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			return seq, nil
+		}
+		for _, item := range splitYAMLFlow(inner) {
+			value, err := parseYAMLScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, value)
+		}
+		return seq, nil
+
+	case strings.HasPrefix(text, "{"), strings.HasPrefix(text, "&"), strings.HasPrefix(text, "*"),
+		strings.HasPrefix(text, "!"), text == "|", text == ">":
+		return nil, fmt.Errorf("unsupported YAML syntax %q", text)
+	}
+
+	switch text {
+	case "null", "~":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return int(n), nil
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f, nil
+	}
+	return text, nil
+}
+
+// parseYAMLQuoted parses a single- or double-quoted string and returns it
+// with the remaining text.
+func parseYAMLQuoted(text string) (string, string, error) {
+	if text[0] == '\'' {
+		var b strings.Builder
+		for i := 1; i < len(text); i++ {
+			if text[i] != '\'' {
+				b.WriteByte(text[i])
+				continue
+			}
+			if i+1 < len(text) && text[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), text[i+1:], nil
+		}
+		return "", "", fmt.Errorf("unterminated string")
+	}
+
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(text[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %s", text[:i+1])
+			}
+			return value, text[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+// splitYAMLFlow splits the items of a flow sequence on commas outside quotes.
+func splitYAMLFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// stripYAMLComment removes a comment that starts with '#' at the beginning
+// of the line or after whitespace, outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// formatYAMLScalar formats a scalar, or a sequence of scalars as a flow
+// sequence, so that parseYAMLScalar reads it back. Strings are quoted when
+// they would be read as something else.
+func formatYAMLScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return formatYAMLString(v)
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatYAMLScalar(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return formatYAMLString(fmt.Sprint(v))
+}
+
+// formatYAMLString formats a string, plain if that is read back as the same
+// string, and double-quoted otherwise.
+func formatYAMLString(s string) string {
+	plain := s != "" && s == strings.TrimSpace(s) &&
+		!strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") &&
+		!strings.ContainsAny(s, "\n\r\t,[]{}") &&
+		!strings.Contains(s, ": ") && !strings.Contains(s, " #") && !strings.HasSuffix(s, ":")
+	switch strings.ToLower(s) {
+	case "yes", "no", "on", "off", "y", "n", "true", "false", "null", "~":
+		// YAML 1.1 readers take these for booleans or null.
+		plain = false
+	}
+	if plain {
+		if v, err := parseYAMLScalar(s); err != nil || v != s {
+			plain = false
+		}
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			plain = false
+		}
+	}
+	if plain {
+		return s
+	}
+	return strconv.Quote(s)
+}